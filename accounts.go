@@ -0,0 +1,116 @@
+package ec2ssh
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+// AccountAliases maps AWS account IDs to human-readable aliases.
+//
+// Aliases configured in the [account_aliases] table of the config file always
+// win. Other accounts are resolved through iam:ListAccountAliases using the
+// credentials that listed their instances, and the result is cached on disk so
+// subsequent runs don't pay for the extra API call.
+type AccountAliases struct {
+	mu         sync.Mutex
+	configured map[string]string
+	cached     map[string]string
+	attempted  map[string]bool
+	cachePath  string
+}
+
+func NewAccountAliases(configured map[string]string) *AccountAliases {
+	a := &AccountAliases{
+		configured: configured,
+		cached:     make(map[string]string),
+		attempted:  make(map[string]bool),
+		cachePath:  filepath.Join(cacheDir(), "account-aliases.json"),
+	}
+	if a.configured == nil {
+		a.configured = make(map[string]string)
+	}
+
+	if data, err := os.ReadFile(a.cachePath); err == nil {
+		json.Unmarshal(data, &a.cached)
+	}
+	return a
+}
+
+// Lookup returns the alias for an account, or the account ID itself if no
+// alias is known.
+func (a *AccountAliases) Lookup(accountId string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if alias, ok := a.configured[accountId]; ok {
+		return alias
+	}
+	if alias, ok := a.cached[accountId]; ok && alias != "" {
+		return alias
+	}
+	return accountId
+}
+
+// Resolve fetches the alias of accountId through IAM if it isn't already
+// known. The IAM client must use credentials belonging to that account.
+// Failures (typically a missing iam:ListAccountAliases permission) are
+// remembered for the lifetime of the process and otherwise ignored.
+func (a *AccountAliases) Resolve(ctx context.Context, client *iam.Client, accountId string) {
+	if accountId == "" || client == nil {
+		return
+	}
+
+	a.mu.Lock()
+	_, configured := a.configured[accountId]
+	_, cached := a.cached[accountId]
+	if configured || cached || a.attempted[accountId] {
+		a.mu.Unlock()
+		return
+	}
+	a.attempted[accountId] = true
+	a.mu.Unlock()
+
+	output, err := client.ListAccountAliases(ctx, &iam.ListAccountAliasesInput{})
+	if err != nil || len(output.AccountAliases) == 0 {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cached[accountId] = output.AccountAliases[0]
+	a.save()
+}
+
+// Learn records an alias known from elsewhere, e.g. the account name listed
+// by AWS Organizations, unless one is configured or already cached
+func (a *AccountAliases) Learn(accountId, alias string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.configured[accountId]; ok || a.cached[accountId] != "" {
+		return
+	}
+	a.cached[accountId] = alias
+}
+
+// save writes the cached aliases to disk. Callers must hold a.mu.
+func (a *AccountAliases) save() {
+	data, err := json.MarshalIndent(a.cached, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(a.cachePath), 0o755); err != nil {
+		return
+	}
+	os.WriteFile(a.cachePath, data, 0o644)
+}
+
+// cacheDir returns the directory used for ec2-ssh's on-disk caches
+func cacheDir() string {
+	return filepath.Join(os.Getenv("HOME"), ".cache", "ec2-ssh")
+}
@@ -0,0 +1,89 @@
+package ec2ssh
+
+import (
+	"regexp"
+	"sort"
+)
+
+// OutputAnsibleInventory prints the instances as an Ansible dynamic inventory
+const OutputAnsibleInventory = "ansible-inventory"
+
+// ungroupedGroup holds the hosts without the group tag, like in Ansible
+const ungroupedGroup = "ungrouped"
+
+// AnsibleConfig configures the Ansible inventory output
+type AnsibleConfig struct {
+	GroupBy string // tag whose values name the groups
+}
+
+// ansibleGroup is a group of an Ansible dynamic inventory
+type ansibleGroup struct {
+	Hosts    []string `json:"hosts,omitempty"`
+	Children []string `json:"children,omitempty"`
+}
+
+// invalidGroupChars are replaced in group names, which Ansible wants to be
+// valid variable names
+var invalidGroupChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// ansibleInventory returns the instances in the JSON format of Ansible
+// dynamic inventories, with the hostvars of every host under _meta so
+// Ansible doesn't have to call back for each of them
+func (e *Ec2ssh) ansibleInventory(instances []Instance) map[string]interface{} {
+	groups := make(map[string]*ansibleGroup)
+	hostvars := make(map[string]map[string]interface{})
+
+	for i := range instances {
+		instance := &instances[i]
+		host := instance.InstanceId
+
+		vars := map[string]interface{}{
+			"ec2_instance_id":   instance.InstanceId,
+			"ec2_target_id":     instance.TargetID(),
+			"ec2_region":        instance.Region,
+			"ec2_account_id":    instance.OwnerId,
+			"ec2_instance_type": instance.InstanceType,
+			"ec2_private_ip":    instance.PrivateIpAddress,
+			"ec2_public_ip":     instance.PublicIpAddress,
+			"ec2_tags":          instance.Tags,
+		}
+		if instance.Profile != "" {
+			vars["ec2_profile"] = instance.Profile
+		}
+		if e.shouldUseSSM(instance) {
+			vars["ansible_connection"] = "community.aws.aws_ssm"
+			vars["ansible_aws_ssm_instance_id"] = instance.InstanceId
+			vars["ansible_aws_ssm_region"] = instance.Region
+			if instance.Profile != "" {
+				vars["ansible_aws_ssm_profile"] = instance.Profile
+			}
+		} else {
+			vars["ansible_host"] = e.sshHost(instance)
+		}
+		hostvars[host] = vars
+
+		group := ungroupedGroup
+		if value := instance.Tags[e.options.Ansible.GroupBy]; e.options.Ansible.GroupBy != "" && value != "" {
+			group = invalidGroupChars.ReplaceAllString(value, "_")
+			if group == "all" || group == "_meta" {
+				group = "tag_" + group
+			}
+		}
+		if groups[group] == nil {
+			groups[group] = &ansibleGroup{}
+		}
+		groups[group].Hosts = append(groups[group].Hosts, host)
+	}
+
+	inventory := map[string]interface{}{
+		"_meta": map[string]interface{}{"hostvars": hostvars},
+	}
+	children := make([]string, 0, len(groups))
+	for name, group := range groups {
+		children = append(children, name)
+		inventory[name] = group
+	}
+	sort.Strings(children)
+	inventory["all"] = ansibleGroup{Children: children}
+	return inventory
+}
@@ -0,0 +1,173 @@
+package ec2ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+// checkIpURL returns the caller's public IP address
+const checkIpURL = "https://checkip.amazonaws.com"
+
+// checkIpTimeout bounds the request to checkIpURL
+const checkIpTimeout = 5 * time.Second
+
+type AuthorizeConfig struct {
+	SecurityGroup string `mapstructure:"security_group"`
+}
+
+// publicIp returns the public IP address the caller reaches AWS from
+func (e *Ec2ssh) publicIp() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), checkIpTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, checkIpURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get your public IP: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to get your public IP: %w", err)
+	}
+	ip := strings.TrimSpace(string(body))
+	if net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("unexpected public IP %q from %s", ip, checkIpURL)
+	}
+	return ip, nil
+}
+
+// authorizeMyIp temporarily allows SSH from the caller's public IP in the
+// designated security group, in the region of the first SSH connection. The
+// rule is revoked when ec2-ssh exits.
+func (e *Ec2ssh) authorizeMyIp(plans []*ConnectionPlan) error {
+	if err := e.checkWritable("--authorize-my-ip"); err != nil {
+		return err
+	}
+	var plan *ConnectionPlan
+	for _, p := range plans {
+		if p.Method == MethodSSH {
+			plan = p
+			break
+		}
+	}
+	if plan == nil {
+		return nil
+	}
+
+	groupId := e.options.Authorize.SecurityGroup
+	if groupId == "" {
+		return fmt.Errorf("--authorize-my-ip needs the security group to open, set authorize_my_ip.security_group in the config")
+	}
+	if plan.Instance.clients == nil {
+		return fmt.Errorf("no client available for %s", plan.Instance.InstanceId)
+	}
+	client := plan.Instance.clients.EC2
+
+	ip, err := e.publicIp()
+	if err != nil {
+		return err
+	}
+	port := int32(22)
+	if plan.Port != "" {
+		if p, err := strconv.Atoi(plan.Port); err == nil {
+			port = int32(p)
+		}
+	}
+
+	permissions := []types.IpPermission{{
+		IpProtocol: aws.String("tcp"),
+		FromPort:   aws.Int32(port),
+		ToPort:     aws.Int32(port),
+		IpRanges: []types.IpRange{{
+			CidrIp:      aws.String(ip + "/32"),
+			Description: aws.String(fmt.Sprintf("ec2-ssh temporary access for %s", os.Getenv("USER"))),
+		}},
+	}}
+
+	_, err = client.AuthorizeSecurityGroupIngress(context.TODO(), &ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId:       aws.String(groupId),
+		IpPermissions: permissions,
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidPermission.Duplicate" {
+		// Already allowed, by a rule this run doesn't own
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to authorize %s in %s: %w", ip, groupId, err)
+	}
+	fmt.Printf("Authorized %s/32 on port %d in %s\n", ip, port, groupId)
+
+	e.onExit(func() {
+		// The connection may have outlived the command's context
+		_, err := client.RevokeSecurityGroupIngress(context.Background(), &ec2.RevokeSecurityGroupIngressInput{
+			GroupId:       aws.String(groupId),
+			IpPermissions: permissions,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to revoke %s/32 from %s, remove it manually: %v\n", ip, groupId, err)
+			return
+		}
+		fmt.Printf("Revoked %s/32 from %s\n", ip, groupId)
+	})
+	return nil
+}
+
+// onExit registers a cleanup to run when ec2-ssh exits, including when it's
+// terminated. Interrupts are left to the ssh or SSM child process, which
+// exits and lets the cleanups run.
+func (e *Ec2ssh) onExit(cleanup func()) {
+	e.cleanupsLock.Lock()
+	defer e.cleanupsLock.Unlock()
+	if len(e.cleanups) == 0 {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+		go func() {
+			for sig := range signals {
+				if sig != os.Interrupt {
+					e.exit(1)
+				}
+			}
+		}()
+	}
+	e.cleanups = append(e.cleanups, cleanup)
+}
+
+// cleanup runs the registered cleanups once, even when the signal handler
+// and the main goroutine exit at the same time
+func (e *Ec2ssh) cleanup() {
+	e.cleanupsLock.Lock()
+	cleanups := e.cleanups
+	e.cleanups = nil
+	e.cleanupsLock.Unlock()
+	for _, cleanup := range cleanups {
+		cleanup()
+	}
+}
+
+// exit runs the registered cleanups and exits
+func (e *Ec2ssh) exit(code int) {
+	e.cleanup()
+	e.endTrace(fmt.Errorf("exit status %d", code))
+	os.Exit(code)
+}
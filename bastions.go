@@ -0,0 +1,168 @@
+package ec2ssh
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bastionProbeTimeout is how long a bastion with a fallback gets to accept a
+// connection before the fallback is used
+const bastionProbeTimeout = 3 * time.Second
+
+// noBastion is the value of the bastion override tag connecting directly
+const noBastion = "none"
+
+// BastionConfig is a named jump host and the instances reached through it,
+// matched by VPC, subnet, account and tag. Every rule set must match, and
+// any of the values of a rule; a bastion without rules matches every
+// instance, e.g. as the last, default one.
+type BastionConfig struct {
+	Name     string   `mapstructure:"name"`
+	Host     string   `mapstructure:"host"`     // ProxyJump destination, [user@]host[:port] or an ssh config alias
+	VpcIds   []string `mapstructure:"vpc_ids"`  // VPC ids
+	Subnets  []string `mapstructure:"subnets"`  // subnet ids
+	Accounts []string `mapstructure:"accounts"` // account ids
+	Tag      string   `mapstructure:"tag"`      // Key=Value or Key, the value can be a glob
+	Fallback string   `mapstructure:"fallback"` // bastion used when this one doesn't answer
+}
+
+// matches reports whether the bastion serves an instance
+func (b BastionConfig) matches(instance *Instance) bool {
+	if len(b.VpcIds) > 0 && !slices.Contains(b.VpcIds, instance.VpcId) {
+		return false
+	}
+	if len(b.Subnets) > 0 && !slices.Contains(b.Subnets, instance.SubnetId) {
+		return false
+	}
+	if len(b.Accounts) > 0 && !slices.Contains(b.Accounts, instance.OwnerId) {
+		return false
+	}
+	if b.Tag != "" {
+		key, pattern, hasValue := strings.Cut(b.Tag, "=")
+		value, ok := instance.Tags[key]
+		if !ok {
+			return false
+		}
+		if hasValue {
+			if matched, _ := path.Match(pattern, value); !matched {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validateBastions checks that bastions are named, have a host and fall
+// back on bastions that exist, without looping
+func validateBastions(bastions []BastionConfig) error {
+	byName := make(map[string]BastionConfig)
+	for _, bastion := range bastions {
+		if bastion.Name == "" || bastion.Host == "" {
+			return fmt.Errorf("invalid bastion %+v: name and host are required", bastion)
+		}
+		if _, ok := byName[bastion.Name]; ok {
+			return fmt.Errorf("bastion %s is defined twice", bastion.Name)
+		}
+		byName[bastion.Name] = bastion
+	}
+	for _, bastion := range bastions {
+		seen := map[string]bool{bastion.Name: true}
+		for next := bastion.Fallback; next != ""; next = byName[next].Fallback {
+			if _, ok := byName[next]; !ok {
+				return fmt.Errorf("bastion %s falls back on unknown bastion %s", bastion.Name, next)
+			}
+			if seen[next] {
+				return fmt.Errorf("the fallbacks of bastion %s loop", bastion.Name)
+			}
+			seen[next] = true
+		}
+	}
+	return nil
+}
+
+// bastionFor returns the bastion to reach an instance through: the one
+// named by its override tag, or the first one matching it, followed to its
+// fallbacks while it doesn't answer. It's nil when the instance is
+// connected to directly.
+func (e *Ec2ssh) bastionFor(instance *Instance) *BastionConfig {
+	var bastion *BastionConfig
+	if name := instance.Tags[overrideTagPrefix+"bastion"]; name == noBastion {
+		return nil
+	} else if name != "" {
+		bastion = e.bastion(name)
+		if bastion == nil {
+			fmt.Fprintf(os.Stderr, "Warning: %s names unknown bastion %s in its %sbastion tag\n", instance.InstanceId, name, overrideTagPrefix)
+		}
+	}
+	if bastion == nil {
+		for i := range e.options.Bastions {
+			if e.options.Bastions[i].matches(instance) {
+				bastion = &e.options.Bastions[i]
+				break
+			}
+		}
+	}
+
+	for bastion != nil && bastion.Fallback != "" && !bastionReachable(bastion.Host) {
+		fmt.Fprintf(os.Stderr, "Warning: bastion %s doesn't answer, falling back on %s\n", bastion.Name, bastion.Fallback)
+		bastion = e.bastion(bastion.Fallback)
+	}
+	return bastion
+}
+
+// bastion returns a bastion by name
+func (e *Ec2ssh) bastion(name string) *BastionConfig {
+	for i := range e.options.Bastions {
+		if e.options.Bastions[i].Name == name {
+			return &e.options.Bastions[i]
+		}
+	}
+	return nil
+}
+
+// bastionProbes remembers which bastions answered, instances sharing one
+// being planned one after the other
+var bastionProbes sync.Map
+
+// bastionReachable reports whether a bastion accepts TCP connections, on
+// the host name and port ssh resolves for it so ssh config aliases work.
+// Bastions reached through other jump hosts are probed on their first hop.
+func bastionReachable(host string) bool {
+	if reachable, ok := bastionProbes.Load(host); ok {
+		return reachable.(bool)
+	}
+
+	first := parseJumpHost(strings.Split(host, ",")[0])
+	address := net.JoinHostPort(first.destination[strings.LastIndex(first.destination, "@")+1:], "22")
+	if output, err := exec.Command("ssh", append([]string{"-G"}, first.args()...)...).Output(); err == nil {
+		var hostname, port string
+		scanner := bufio.NewScanner(bytes.NewReader(output))
+		for scanner.Scan() {
+			key, value, _ := strings.Cut(scanner.Text(), " ")
+			switch key {
+			case "hostname":
+				hostname = value
+			case "port":
+				port = value
+			}
+		}
+		address = net.JoinHostPort(hostname, port)
+	}
+
+	conn, err := net.DialTimeout("tcp", address, bastionProbeTimeout)
+	reachable := err == nil
+	if reachable {
+		conn.Close()
+	}
+	bastionProbes.Store(host, reachable)
+	return reachable
+}
@@ -0,0 +1,101 @@
+package ec2ssh
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Bookmark is a note kept about an instance, e.g. why it's special
+type Bookmark struct {
+	Note    string
+	Created time.Time
+}
+
+// bookmarksPath returns the file holding the bookmarks, by target id
+func bookmarksPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".config", "ec2-ssh", "bookmarks.json")
+}
+
+// loadBookmarks reads the bookmarks, none when the file doesn't exist yet
+func loadBookmarks() (map[string]Bookmark, error) {
+	bookmarks := make(map[string]Bookmark)
+	data, err := os.ReadFile(bookmarksPath())
+	if os.IsNotExist(err) {
+		return bookmarks, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &bookmarks); err != nil {
+		return nil, fmt.Errorf("invalid bookmarks file %s: %w", bookmarksPath(), err)
+	}
+	return bookmarks, nil
+}
+
+// saveBookmarks writes the bookmarks
+func saveBookmarks(bookmarks map[string]Bookmark) error {
+	data, err := json.MarshalIndent(bookmarks, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(bookmarksPath()), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(bookmarksPath(), data, 0o644)
+}
+
+// bookmarkInstances bookmarks the selected instances with a note, replacing
+// the note of those already bookmarked
+func bookmarkInstances(selected []*Instance, note string) error {
+	bookmarks, err := loadBookmarks()
+	if err != nil {
+		return err
+	}
+	for _, instance := range selected {
+		bookmarks[instance.TargetID()] = Bookmark{Note: note, Created: time.Now()}
+		fmt.Printf("Bookmarked %s: %s\n", instance.InstanceId, note)
+	}
+	return saveBookmarks(bookmarks)
+}
+
+// unbookmarkInstances removes the bookmarks of the selected instances
+func unbookmarkInstances(selected []*Instance) error {
+	bookmarks, err := loadBookmarks()
+	if err != nil {
+		return err
+	}
+	for _, instance := range selected {
+		if _, ok := bookmarks[instance.TargetID()]; !ok {
+			fmt.Printf("%s isn't bookmarked\n", instance.InstanceId)
+			continue
+		}
+		delete(bookmarks, instance.TargetID())
+		fmt.Printf("Removed the bookmark of %s\n", instance.InstanceId)
+	}
+	return saveBookmarks(bookmarks)
+}
+
+// bookmarksFirst moves the bookmarked instances to the top of the list, so
+// they're the first suggestions of the finder
+func bookmarksFirst(instances []Instance, bookmarks map[string]Bookmark) {
+	if len(bookmarks) == 0 {
+		return
+	}
+	sort.SliceStable(instances, func(i, j int) bool {
+		_, iBookmarked := bookmarks[instances[i].TargetID()]
+		_, jBookmarked := bookmarks[instances[j].TargetID()]
+		return iBookmarked && !jBookmarked
+	})
+}
+
+// bookmarkMarker marks bookmarked instances in the list
+func bookmarkMarker(instance *Instance, bookmarks map[string]Bookmark) string {
+	if _, ok := bookmarks[instance.TargetID()]; ok {
+		return "★ "
+	}
+	return ""
+}
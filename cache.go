@@ -0,0 +1,262 @@
+package ec2ssh
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CacheConfig configures the on-disk cache of instance lists
+type CacheConfig struct {
+	TTL     time.Duration // 0 disables the cache
+	Refresh bool          // list again, ignoring cached lists
+}
+
+// instanceCacheEntry is the cached instance list of one profile, region and
+// set of filters
+type instanceCacheEntry struct {
+	Fetched   time.Time  `json:"fetched"`
+	Profile   string     `json:"profile"`
+	Account   string     `json:"account,omitempty"`
+	Region    string     `json:"region"`
+	Filters   []string   `json:"filters,omitempty"`
+	Instances []Instance `json:"instances"`
+}
+
+// instanceCacheDir returns the directory holding the cached instance lists
+func instanceCacheDir() string {
+	return filepath.Join(cacheDir(), "instances")
+}
+
+// cacheName returns the directory name of the clients' cached lists: the
+// profile, or the account in org mode
+func cacheName(c *awsClients) string {
+	switch {
+	case c.Profile == "" && c.Account != "":
+		return c.Account
+	case c.Profile == "":
+		return "default"
+	default:
+		return c.Profile
+	}
+}
+
+// instanceCachePath returns the cache file of the clients' instance list
+// with the given filters
+func instanceCachePath(c *awsClients, filters []string) string {
+	name := c.Region
+	if len(filters) > 0 {
+		sum := sha256.Sum256([]byte(strings.Join(filters, "\n")))
+		name += "-" + hex.EncodeToString(sum[:4])
+	}
+	return filepath.Join(instanceCacheDir(), cacheName(c), name+".json")
+}
+
+// cacheable reports whether listings are cached: lists restricted to some
+// instance ids or to a group, or cut at --max-instances, are always fetched
+func (e *Ec2ssh) cacheable() bool {
+	return e.options.Cache.TTL > 0 && !e.options.Membership.Enabled() && e.options.ResourceGroup == "" && len(e.options.InstanceIds) == 0 &&
+		e.options.Discovery != DiscoveryResourceExplorer && e.options.MaxInstances == 0
+}
+
+// cachedInstances returns the cached instance list of the clients if it's
+// fresh enough
+func (e *Ec2ssh) cachedInstances(c *awsClients) ([]Instance, bool) {
+	if !e.cacheable() || e.options.Cache.Refresh {
+		return nil, false
+	}
+
+	entry, err := readCacheEntry(instanceCachePath(c, e.options.Filters))
+	if err != nil || time.Since(entry.Fetched) > e.options.Cache.TTL {
+		return nil, false
+	}
+	for i := range entry.Instances {
+		entry.Instances[i].detail = &instanceDetail{}
+	}
+	return entry.Instances, true
+}
+
+// storeInstances caches the instance list of the clients. Failures only
+// cost a listing on the next run and are ignored.
+func (e *Ec2ssh) storeInstances(c *awsClients, instances []Instance) {
+	if !e.cacheable() {
+		return
+	}
+
+	data, err := json.Marshal(instanceCacheEntry{
+		Fetched:   time.Now(),
+		Profile:   c.Profile,
+		Account:   c.Account,
+		Region:    c.Region,
+		Filters:   e.options.Filters,
+		Instances: instances,
+	})
+	if err != nil {
+		return
+	}
+	path := instanceCachePath(c, e.options.Filters)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return
+	}
+	os.WriteFile(path, data, 0o644)
+}
+
+// readCacheEntry reads a cached instance list
+func readCacheEntry(path string) (*instanceCacheEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entry instanceCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// cacheEntries returns the cached instance lists, with their path, matching
+// the profile and region when given
+func cacheEntries(profile, region string) (map[string]*instanceCacheEntry, error) {
+	paths, err := filepath.Glob(filepath.Join(instanceCacheDir(), "*", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]*instanceCacheEntry)
+	for _, path := range paths {
+		entry, err := readCacheEntry(path)
+		if err != nil {
+			// Unreadable entries are listed so they can be cleared
+			entry = &instanceCacheEntry{}
+		}
+		name := filepath.Base(filepath.Dir(path))
+		if profile != "" && name != profile {
+			continue
+		}
+		if region != "" && entry.Region != region {
+			continue
+		}
+		entries[path] = entry
+	}
+	return entries, nil
+}
+
+// runCacheCommand handles "ec2-ssh cache ls|clear [profile] [region]". Lists
+// are printed as tab separated lines, or as JSON with --json. "cache warm" is
+// handled by Run as it needs clients.
+func runCacheCommand(args []string, ttl time.Duration) error {
+	if len(args) == 0 {
+		return fmt.Errorf("Usage: ec2-ssh cache ls|clear|warm [profile] [region]")
+	}
+
+	asJSON := false
+	var positional []string
+	for _, arg := range args[1:] {
+		if arg == "--json" {
+			asJSON = true
+			continue
+		}
+		positional = append(positional, arg)
+	}
+	var profile, region string
+	if len(positional) > 0 {
+		profile = positional[0]
+	}
+	if len(positional) > 1 {
+		region = positional[1]
+	}
+
+	entries, err := cacheEntries(profile, region)
+	if err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(entries))
+	for path := range entries {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	switch args[0] {
+	case "ls":
+		return printCacheEntries(paths, entries, ttl, asJSON)
+	case "clear":
+		for _, path := range paths {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Cleared %d cached instance lists\n", len(paths))
+		return nil
+	default:
+		return fmt.Errorf("unknown cache command %q, valid commands are: ls, clear, warm", args[0])
+	}
+}
+
+// cacheListing is the machine-readable description of a cached list
+type cacheListing struct {
+	Name      string    `json:"name"`
+	Region    string    `json:"region"`
+	Filters   []string  `json:"filters,omitempty"`
+	Instances int       `json:"instances"`
+	Fetched   time.Time `json:"fetched"`
+	Age       int       `json:"age_seconds"`
+	Stale     bool      `json:"stale"`
+	Path      string    `json:"path"`
+}
+
+// printCacheEntries prints the cached lists, flagging the ones older than
+// the configured TTL as stale
+func printCacheEntries(paths []string, entries map[string]*instanceCacheEntry, ttl time.Duration, asJSON bool) error {
+	listings := make([]cacheListing, 0, len(paths))
+	for _, path := range paths {
+		entry := entries[path]
+		age := time.Since(entry.Fetched)
+		listings = append(listings, cacheListing{
+			Name:      filepath.Base(filepath.Dir(path)),
+			Region:    entry.Region,
+			Filters:   entry.Filters,
+			Instances: len(entry.Instances),
+			Fetched:   entry.Fetched,
+			Age:       int(age.Seconds()),
+			Stale:     ttl <= 0 || age > ttl,
+			Path:      path,
+		})
+	}
+
+	if asJSON {
+		data, err := json.MarshalIndent(listings, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	for _, l := range listings {
+		fmt.Printf("%s\t%s\t%d\t%s\t%d\t%t\t%s\n", l.Name, l.Region, l.Instances,
+			l.Fetched.Format(time.RFC3339), l.Age, l.Stale, strings.Join(l.Filters, ","))
+	}
+	return nil
+}
+
+// warmCache lists the instances of every profile and region and caches
+// them, regardless of the cached lists' age, e.g. from cron
+func (e *Ec2ssh) warmCache() error {
+	if e.options.Cache.TTL <= 0 {
+		return fmt.Errorf("the instance cache is disabled, set cache.ttl in the config file")
+	}
+	e.options.Cache.Refresh = true
+	if !e.cacheable() {
+		return fmt.Errorf("lists restricted with --asg, --target-group, --behind-lb, --discovery or --max-instances aren't cached")
+	}
+
+	instances := e.listAll()
+	fmt.Printf("Cached %d instances from %d profile and region combinations\n", len(instances), len(e.clients))
+	return nil
+}
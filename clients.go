@@ -0,0 +1,243 @@
+package ec2ssh
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/cloudtrail"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/codedeploy"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2instanceconnect"
+	elb "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go-v2/service/guardduty"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/inspector2"
+	re "github.com/aws/aws-sdk-go-v2/service/resourceexplorer2"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroups"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// awsClients groups the service clients used for one profile and region
+type awsClients struct {
+	Profile string
+	Region  string
+	EC2     *ec2.Client
+	SSM     *ssm.Client
+	IAM     *iam.Client
+
+	AutoScaling      *autoscaling.Client
+	ELB              *elb.Client
+	InstanceConnect  *ec2instanceconnect.Client
+	ResourceExplorer *re.Client
+	CloudTrail       *cloudtrail.Client
+	CodeDeploy       *codedeploy.Client
+	GuardDuty        *guardduty.Client
+	Inspector        *inspector2.Client
+	CloudWatchLogs   *cloudwatchlogs.Client
+	CloudWatch       *cloudwatch.Client
+	STS              *sts.Client
+	ResourceGroups   *resourcegroups.Client
+
+	// Account, AccountName and Credentials are set for the accounts
+	// discovered in org mode, Credentials only when a role was assumed.
+	// Credentials are also set for profiles using a credential helper.
+	Account     string
+	AccountName string
+	Credentials aws.CredentialsProvider
+}
+
+// newClients creates the clients for every profile and region combination,
+// in profile order. An empty profile stands for the default credentials.
+func newClients(options Options) ([]*awsClients, error) {
+	profiles := options.Profiles
+	if len(profiles) == 0 {
+		profiles = []string{""}
+	}
+
+	if err := applyProxyEnvironment(options.HTTP); err != nil {
+		return nil, err
+	}
+	httpClient, err := newHTTPClient(options.HTTP)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Org.Enabled {
+		return newOrgClients(options, httpClient)
+	}
+
+	clients := make([]*awsClients, 0)
+	for _, profile := range profiles {
+		regions := options.Regions
+		if detected, ok := options.ProfileRegions[profile]; ok {
+			regions = detected
+		}
+		if len(regions) == 0 {
+			continue
+		}
+		regions = orderRegions(options, regions)
+
+		// Profiles with a credential helper get their credentials from it
+		// rather than from the shared config, where they may not exist
+		configProfile := profile
+		var credentials aws.CredentialsProvider
+		if command := credentialHelper(options, profile); command != "" {
+			configProfile = ""
+			credentials = helperCredentials(command)
+		}
+
+		// The config, and so the credentials, are resolved once per
+		// profile and copied for each region, so SSO logins and roles
+		// requiring MFA aren't resolved again per region. Like the helper
+		// ones, MFA credentials are passed to the aws CLI which would
+		// otherwise ask again.
+		opts := loadOptions(options, configProfile, regions[0])
+		if credentials != nil {
+			opts = append(opts, config.WithCredentialsProvider(credentials))
+		}
+		if httpClient != nil {
+			opts = append(opts, config.WithHTTPClient(httpClient))
+		}
+		profileCfg, err := config.LoadDefaultConfig(context.TODO(), opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load AWS config: %w", err)
+		}
+		if credentials == nil && profileUsesMFA(profile) {
+			credentials = profileCfg.Credentials
+		}
+
+		// IAM is global, one client per set of credentials is enough
+		iamClient := iam.NewFromConfig(profileCfg, func(o *iam.Options) {
+			o.BaseEndpoint = endpoint(options, "iam")
+		})
+
+		for _, region := range regions {
+			cfg := profileCfg.Copy()
+			cfg.Region = region
+
+			clients = append(clients, &awsClients{
+				Profile: profile,
+				Region:  region,
+				EC2: ec2.NewFromConfig(cfg, func(o *ec2.Options) {
+					o.BaseEndpoint = endpoint(options, "ec2")
+				}),
+				SSM: ssm.NewFromConfig(cfg, func(o *ssm.Options) {
+					o.BaseEndpoint = endpoint(options, "ssm")
+				}),
+				IAM: iamClient,
+				AutoScaling: autoscaling.NewFromConfig(cfg, func(o *autoscaling.Options) {
+					o.BaseEndpoint = endpoint(options, "autoscaling")
+				}),
+				ELB: elb.NewFromConfig(cfg, func(o *elb.Options) {
+					o.BaseEndpoint = endpoint(options, "elasticloadbalancing")
+				}),
+				InstanceConnect: ec2instanceconnect.NewFromConfig(cfg, func(o *ec2instanceconnect.Options) {
+					o.BaseEndpoint = endpoint(options, "ec2-instance-connect")
+				}),
+				ResourceExplorer: re.NewFromConfig(cfg, func(o *re.Options) {
+					o.BaseEndpoint = endpoint(options, "resource-explorer-2")
+				}),
+				CloudTrail: cloudtrail.NewFromConfig(cfg, func(o *cloudtrail.Options) {
+					o.BaseEndpoint = endpoint(options, "cloudtrail")
+				}),
+				CodeDeploy: codedeploy.NewFromConfig(cfg, func(o *codedeploy.Options) {
+					o.BaseEndpoint = endpoint(options, "codedeploy")
+				}),
+				GuardDuty: guardduty.NewFromConfig(cfg, func(o *guardduty.Options) {
+					o.BaseEndpoint = endpoint(options, "guardduty")
+				}),
+				Inspector: inspector2.NewFromConfig(cfg, func(o *inspector2.Options) {
+					o.BaseEndpoint = endpoint(options, "inspector2")
+				}),
+				CloudWatchLogs: cloudwatchlogs.NewFromConfig(cfg, func(o *cloudwatchlogs.Options) {
+					o.BaseEndpoint = endpoint(options, "logs")
+				}),
+				CloudWatch: cloudwatch.NewFromConfig(cfg, func(o *cloudwatch.Options) {
+					o.BaseEndpoint = endpoint(options, "monitoring")
+				}),
+				STS: sts.NewFromConfig(cfg, func(o *sts.Options) {
+					o.BaseEndpoint = endpoint(options, "sts")
+				}),
+				ResourceGroups: resourcegroups.NewFromConfig(cfg, func(o *resourcegroups.Options) {
+					o.BaseEndpoint = endpoint(options, "resource-groups")
+				}),
+				Credentials: credentials,
+			})
+		}
+	}
+	return clients, nil
+}
+
+// loadOptions returns the AWS config loading options for a profile and region
+func loadOptions(options Options, profile, region string) []func(*config.LoadOptions) error {
+	opts := []func(*config.LoadOptions) error{
+		config.WithRegion(region),
+	}
+	if profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(profile))
+	}
+	opts = append(opts, mfaLoadOptions(options))
+
+	// The daemon counts and times its AWS API calls for /metrics
+	if options.Command == "daemon-run" {
+		opts = append(opts, config.WithAPIOptions([]func(*middleware.Stack) error{metricsMiddleware}))
+	}
+	if options.Tracing.Exporter != "" {
+		opts = append(opts, config.WithAPIOptions([]func(*middleware.Stack) error{tracingMiddleware}))
+	}
+
+	if options.Retry != (RetryConfig{}) {
+		opts = append(opts, config.WithRetryer(func() aws.Retryer {
+			return newRetryer(options.Retry)
+		}))
+	}
+	return opts
+}
+
+// endpoint returns the configured endpoint for a service as expected by the
+// SDK client options, nil meaning the default endpoint resolution
+func endpoint(options Options, service string) *string {
+	if url := options.serviceEndpoint(service); url != "" {
+		return aws.String(url)
+	}
+	return nil
+}
+
+// newRetryer builds the SDK retryer from the retry configuration, keeping the
+// SDK defaults for anything left unset
+func newRetryer(retryConfig RetryConfig) aws.Retryer {
+	standard := func(o *retry.StandardOptions) {
+		if retryConfig.MaxAttempts > 0 {
+			o.MaxAttempts = retryConfig.MaxAttempts
+		}
+		if retryConfig.MaxBackoff > 0 {
+			o.MaxBackoff = retryConfig.MaxBackoff
+		}
+	}
+
+	if retryConfig.Mode == string(aws.RetryModeAdaptive) {
+		return retry.NewAdaptiveMode(func(o *retry.AdaptiveModeOptions) {
+			o.StandardOptions = append(o.StandardOptions, standard)
+		})
+	}
+	return retry.NewStandard(standard)
+}
+
+// validateRetryConfig checks the configured retry mode is one the SDK knows
+func validateRetryConfig(retryConfig RetryConfig) error {
+	if retryConfig.Mode == "" {
+		return nil
+	}
+	if _, err := aws.ParseRetryMode(retryConfig.Mode); err != nil {
+		return fmt.Errorf("invalid retry.mode %q, valid modes are: standard, adaptive", retryConfig.Mode)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package ec2ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+)
+
+// cloudWatchLogsHistory is how far back tailing CloudWatch Logs starts
+const cloudWatchLogsHistory = 5 * time.Minute
+
+// cloudWatchLogsPollInterval is how often new log events are fetched
+const cloudWatchLogsPollInterval = 2 * time.Second
+
+// CloudWatchLogsConfig configures where the logs of instances are found in
+// CloudWatch Logs
+type CloudWatchLogsConfig struct {
+	LogGroup string // template naming the log group of an instance
+	Stream   string // template of the log stream name prefix of an instance
+	Filter   string // CloudWatch Logs filter pattern
+}
+
+// runCloudWatchLogs live-tails the CloudWatch Logs of the selected instances,
+// with each line prefixed by the instance name, without connecting to them
+func (e *Ec2ssh) runCloudWatchLogs(instances []*Instance) error {
+	if e.logGroup == nil {
+		return errors.New("set cloudwatch_logs.log_group to the log group template of the instances, e.g. /app/{{ .Tags.Service }}")
+	}
+
+	labels := make([]string, len(instances))
+	width := 0
+	for i, instance := range instances {
+		labels[i] = instance.InstanceId
+		if name := instance.Tags["Name"]; name != "" {
+			labels[i] = name
+		}
+		if len(labels[i]) > width {
+			width = len(labels[i])
+		}
+	}
+
+	outputLock := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	for i, instance := range instances {
+		if instance.clients == nil {
+			return fmt.Errorf("no client available for %s", instance.InstanceId)
+		}
+		group, err := TemplateForInstance(instance, e.logGroup)
+		if err != nil {
+			return fmt.Errorf("invalid log group for %s: %w", instance.InstanceId, err)
+		}
+		if group == "" {
+			return fmt.Errorf("no log group for %s", instance.InstanceId)
+		}
+		stream, err := TemplateForInstance(instance, e.logStream)
+		if err != nil {
+			return fmt.Errorf("invalid log stream for %s: %w", instance.InstanceId, err)
+		}
+		prefix := fmt.Sprintf("[%-*s] ", width, labels[i])
+
+		wg.Add(1)
+		go func(instance *Instance) {
+			defer wg.Done()
+			err := tailLogGroup(instance.clients.CloudWatchLogs, group, stream, e.options.CloudWatchLogs.Filter, func(message string) {
+				outputLock.Lock()
+				defer outputLock.Unlock()
+				for _, line := range strings.Split(strings.TrimRight(message, "\r\n"), "\n") {
+					fmt.Println(prefix + strings.TrimRight(line, "\r"))
+				}
+			})
+			if err != nil {
+				outputLock.Lock()
+				fmt.Printf("%sError: %v\n", prefix, err)
+				outputLock.Unlock()
+			}
+		}(instance)
+	}
+	wg.Wait()
+	return nil
+}
+
+// tailLogGroup polls the events of the log streams starting with a prefix
+// from a log group, printing the new ones until an error occurs. Events seen
+// at the latest timestamp are remembered, as the next poll starts there.
+func tailLogGroup(client *cloudwatchlogs.Client, group, stream, filter string, print func(string)) error {
+	start := time.Now().Add(-cloudWatchLogsHistory).UnixMilli()
+	seen := make(map[string]bool)
+	for {
+		input := &cloudwatchlogs.FilterLogEventsInput{
+			LogGroupName: aws.String(group),
+			StartTime:    aws.Int64(start),
+		}
+		if stream != "" {
+			input.LogStreamNamePrefix = aws.String(stream)
+		}
+		if filter != "" {
+			input.FilterPattern = aws.String(filter)
+		}
+
+		latest := start
+		var latestIds []string
+		paginator := cloudwatchlogs.NewFilterLogEventsPaginator(client, input)
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(context.TODO())
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", group, err)
+			}
+			for _, event := range page.Events {
+				id := aws.ToString(event.EventId)
+				timestamp := aws.ToInt64(event.Timestamp)
+				if seen[id] {
+					continue
+				}
+				print(aws.ToString(event.Message))
+				if timestamp > latest {
+					latest = timestamp
+					latestIds = nil
+				}
+				if timestamp == latest {
+					latestIds = append(latestIds, id)
+				}
+			}
+		}
+
+		if latest > start {
+			seen = make(map[string]bool)
+		}
+		for _, id := range latestIds {
+			seen[id] = true
+		}
+		start = latest
+		time.Sleep(cloudWatchLogsPollInterval)
+	}
+}
@@ -0,0 +1,163 @@
+package ec2ssh
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const bashCompletion = `#!/bin/bash
+
+# Bash completion for ec2-ssh
+_ec2_ssh_completion() {
+    local cur="${COMP_WORDS[COMP_CWORD]}"
+    local prev="${COMP_WORDS[COMP_CWORD-1]}"
+    
+    case "$prev" in
+        --region)
+            COMPREPLY=($(compgen -W "$(ec2-ssh --completion-list regions 2>/dev/null)" -- "$cur"))
+            return
+            ;;
+        --filters)
+            compopt -o nospace 2>/dev/null
+            COMPREPLY=($(compgen -W "$(ec2-ssh --completion-list filters 2>/dev/null)" -- "$cur"))
+            return
+            ;;
+        --ssm-document)
+            COMPREPLY=($(compgen -W "$(ec2-ssh --completion-list documents "${COMP_WORDS[1]}" 2>/dev/null)" -- "$cur"))
+            return
+            ;;
+    esac
+
+    # Saved searches after "ec2-ssh search"
+    if [[ ${COMP_CWORD} -eq 2 && "$prev" == "search" ]]; then
+        COMPREPLY=($(compgen -W "$(ec2-ssh --completion-list searches 2>/dev/null)" -- "$cur"))
+        return
+    fi
+
+    # If we're completing the first argument (profile or command)
+    if [[ ${COMP_CWORD} -eq 1 ]]; then
+        local profiles
+        profiles="$(ec2-ssh --completion-list 2>/dev/null) $(ec2-ssh --completion-list commands 2>/dev/null)"
+        COMPREPLY=($(compgen -W "$profiles" -- "$cur"))
+    fi
+}
+
+# Register completion for ec2-ssh
+complete -F _ec2_ssh_completion ec2-ssh
+
+# If you want to use 's' as an alias, uncomment this line:
+# complete -F _ec2_ssh_completion s
+`
+
+const zshCompletion = `#compdef ec2-ssh
+
+# Zsh completion for ec2-ssh
+_ec2_ssh_list() {
+  local kind=$1
+  shift
+  local -a items
+  items=(${(f)"$(ec2-ssh --completion-list $kind ${words[2]} 2>/dev/null)"})
+  compadd "$@" -a items
+}
+
+_arguments \
+  "--region[AWS region]:region:{_ec2_ssh_list regions}" \
+  "--filters[EC2 filter]:filter:{_ec2_ssh_list filters -S ''}" \
+  "--ssm-document[SSM session document]:document:{_ec2_ssh_list documents}" \
+  "1:profile or command:{_ec2_ssh_list profiles; _ec2_ssh_list commands}" \
+  "2:search:{[[ \$words[2] == search ]] && _ec2_ssh_list searches}" \
+  "*::arg:_default"
+`
+
+const fishCompletion = `# Fish completion for ec2-ssh
+complete -c ec2-ssh -f -n "test (count (commandline -opc)) -eq 1" -a "(ec2-ssh --completion-list 2>/dev/null)"
+complete -c ec2-ssh -f -n "test (count (commandline -opc)) -eq 1" -a "(ec2-ssh --completion-list commands 2>/dev/null)"
+complete -c ec2-ssh -f -n "__fish_seen_subcommand_from search; and test (count (commandline -opc)) -eq 2" -a "(ec2-ssh --completion-list searches 2>/dev/null)"
+complete -c ec2-ssh -l region -x -a "(ec2-ssh --completion-list regions 2>/dev/null)"
+complete -c ec2-ssh -l filters -x -a "(ec2-ssh --completion-list filters 2>/dev/null)"
+complete -c ec2-ssh -l ssm-document -x -a "(ec2-ssh --completion-list documents (commandline -opc)[2] 2>/dev/null)"
+`
+
+// runCompletionCommand handles "ec2-ssh completion [install] [bash|zsh|fish]":
+// it prints the completion script for a shell, or installs it where the shell
+// picks it up automatically
+func runCompletionCommand(args []string) error {
+	install := len(args) > 0 && args[0] == "install"
+	if install {
+		args = args[1:]
+	}
+
+	shell := filepath.Base(os.Getenv("SHELL"))
+	if len(args) > 0 {
+		shell = args[0]
+	}
+
+	script, err := completionScript(shell)
+	if err != nil {
+		return err
+	}
+
+	if !install {
+		fmt.Print(script)
+		return nil
+	}
+
+	path, hint := completionPath(shell)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create completion directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(script), 0o644); err != nil {
+		return fmt.Errorf("failed to write completion script: %w", err)
+	}
+
+	fmt.Printf("Installed %s completion to %s\n", shell, path)
+	if hint != "" {
+		fmt.Println(hint)
+	}
+	return nil
+}
+
+// completionScript returns the completion script for a shell
+func completionScript(shell string) (string, error) {
+	switch shell {
+	case "bash":
+		return bashCompletion, nil
+	case "zsh":
+		return zshCompletion, nil
+	case "fish":
+		return fishCompletion, nil
+	default:
+		return "", fmt.Errorf("unsupported shell %q, supported shells are: bash, zsh, fish", shell)
+	}
+}
+
+// completionPath returns where a shell loads completion scripts from, along
+// with a hint when the location may need extra setup
+func completionPath(shell string) (path string, hint string) {
+	home := os.Getenv("HOME")
+
+	switch shell {
+	case "bash":
+		dataHome := os.Getenv("XDG_DATA_HOME")
+		if dataHome == "" {
+			dataHome = filepath.Join(home, ".local", "share")
+		}
+		return filepath.Join(dataHome, "bash-completion", "completions", "ec2-ssh"),
+			"Completions are loaded by the bash-completion package, restart your shell to use them."
+	case "zsh":
+		// Homebrew's site-functions directory is already in fpath
+		if prefix := os.Getenv("HOMEBREW_PREFIX"); prefix != "" {
+			return filepath.Join(prefix, "share", "zsh", "site-functions", "_ec2-ssh"),
+				"Restart your shell (or run: compinit) to use them."
+		}
+		return filepath.Join(home, ".zfunc", "_ec2-ssh"),
+			"Make sure ~/.zfunc is in your fpath, e.g. add to ~/.zshrc before compinit:\n  fpath+=~/.zfunc"
+	default:
+		configHome := os.Getenv("XDG_CONFIG_HOME")
+		if configHome == "" {
+			configHome = filepath.Join(home, ".config")
+		}
+		return filepath.Join(configHome, "fish", "completions", "ec2-ssh.fish"), ""
+	}
+}
@@ -0,0 +1,140 @@
+package ec2ssh
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// documentsCacheTTL is how long the SSM document names offered for completion
+// are cached
+const documentsCacheTTL = 24 * time.Hour
+
+// awsRegions is the built-in list of regions offered for --region completion
+var awsRegions = []string{
+	"af-south-1", "ap-east-1", "ap-northeast-1", "ap-northeast-2", "ap-northeast-3",
+	"ap-south-1", "ap-south-2", "ap-southeast-1", "ap-southeast-2", "ap-southeast-3",
+	"ap-southeast-4", "ap-southeast-5", "ap-southeast-7", "ca-central-1", "ca-west-1",
+	"cn-north-1", "cn-northwest-1", "eu-central-1", "eu-central-2", "eu-north-1",
+	"eu-south-1", "eu-south-2", "eu-west-1", "eu-west-2", "eu-west-3",
+	"il-central-1", "me-central-1", "me-south-1", "mx-central-1", "sa-east-1",
+	"us-east-1", "us-east-2", "us-gov-east-1", "us-gov-west-1", "us-west-1", "us-west-2",
+}
+
+// instanceFilters are the DescribeInstances filter names offered for
+// --filters completion
+var instanceFilters = []string{
+	"architecture", "availability-zone", "iam-instance-profile.arn", "image-id",
+	"instance-id", "instance-lifecycle", "instance-state-name", "instance-type",
+	"ip-address", "key-name", "network-interface.subnet-id", "owner-id",
+	"placement-group-name", "platform", "platform-details", "private-dns-name",
+	"private-ip-address", "dns-name", "subnet-id", "tag-key", "tag-value",
+	"tag:Name", "tenancy", "vpc-id",
+}
+
+// printCompletionList prints the candidates of a completion kind, one per
+// line: profiles (the default), commands, regions, filters, documents or
+// searches. Documents are listed with the profile given as second argument.
+func printCompletionList(args []string) {
+	kind := "profiles"
+	if len(args) > 0 {
+		kind = args[0]
+	}
+
+	var items []string
+	switch kind {
+	case "regions":
+		items = awsRegions
+	case "filters":
+		for _, filter := range instanceFilters {
+			items = append(items, filter+"=")
+		}
+	case "documents":
+		profile := ""
+		if len(args) > 1 && !strings.HasPrefix(args[1], "-") {
+			profile = args[1]
+		}
+		items = sessionDocuments(profile)
+	case "commands":
+		readConfig()
+		items = completionCommands(readOnlyConfigured())
+	case "searches":
+		readConfig()
+		if searches, err := savedSearches(); err == nil {
+			items = searchNames(searches)
+		}
+	default:
+		items = getAWSProfiles()
+	}
+
+	for _, item := range items {
+		fmt.Println(item)
+	}
+}
+
+type documentsCache struct {
+	Fetched   time.Time
+	Documents []string
+}
+
+// sessionDocuments returns the names of the SSM session documents available
+// to a profile, from a daily cache. Errors just mean no candidates.
+func sessionDocuments(profile string) []string {
+	caches := make(map[string]documentsCache)
+	path := filepath.Join(cacheDir(), "ssm-documents.json")
+	if data, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(data, &caches)
+	}
+	if cache, ok := caches[profile]; ok && time.Since(cache.Fetched) < documentsCacheTTL {
+		return cache.Documents
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := []func(*config.LoadOptions) error{}
+	if profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(profile))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	var documents []string
+	paginator := ssm.NewListDocumentsPaginator(ssm.NewFromConfig(cfg), &ssm.ListDocumentsInput{
+		Filters: []ssmtypes.DocumentKeyValuesFilter{
+			{Key: aws.String("DocumentType"), Values: []string{"Session"}},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil
+		}
+		for _, document := range page.DocumentIdentifiers {
+			documents = append(documents, aws.ToString(document.Name))
+		}
+	}
+	sort.Strings(documents)
+
+	caches[profile] = documentsCache{Fetched: time.Now(), Documents: documents}
+	if data, err := json.Marshal(caches); err == nil {
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		os.WriteFile(path, data, 0o644)
+	}
+	return documents
+}
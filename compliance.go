@@ -0,0 +1,150 @@
+package ec2ssh
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// complianceBatch is the number of instances described at once, the most
+// DescribeInstancePatchStates and the InstanceIds filter accept
+const complianceBatch = 50
+
+// Compliance holds the SSM agent and patch compliance status of an instance
+type Compliance struct {
+	Managed      bool // registered with SSM
+	PingStatus   string
+	AgentVersion string
+	AgentLatest  bool
+
+	Scanned       bool // has a patch state
+	Missing       int
+	Failed        int
+	PendingReboot int
+	Critical      int // missing or failed critical patches
+	LastScan      time.Time
+}
+
+// Compliant reports whether the last patch scan found nothing missing,
+// failed or waiting for a reboot
+func (c *Compliance) Compliant() bool {
+	return c.Scanned && c.Missing == 0 && c.Failed == 0 && c.PendingReboot == 0
+}
+
+// Compliance fetches the SSM agent and patch compliance status of the
+// instance, e.g. {{ with .Compliance }}{{ .Missing }} missing{{ end }} in the
+// preview template. It's already known when --non-compliant-only is used.
+func (i *Instance) Compliance() (*Compliance, error) {
+	if i.compliance != nil {
+		return i.compliance, nil
+	}
+	if i.clients == nil {
+		return nil, fmt.Errorf("no client available to describe %s", i.InstanceId)
+	}
+	compliance, err := describeCompliance(context.TODO(), i.clients.SSM, []string{i.InstanceId})
+	if err != nil {
+		return nil, err
+	}
+	i.compliance = compliance[i.InstanceId]
+	return i.compliance, nil
+}
+
+// nonCompliantOnly keeps the instances with missing, failed or pending
+// patches, and the ones without a patch scan or not managed by SSM at all,
+// which can't be told compliant
+func (e *Ec2ssh) nonCompliantOnly(instances []Instance) []Instance {
+	byClients := make(map[*awsClients][]string)
+	for _, instance := range instances {
+		if instance.clients != nil {
+			byClients[instance.clients] = append(byClients[instance.clients], instance.InstanceId)
+		}
+	}
+
+	known := make(map[*awsClients]map[string]*Compliance)
+	for c, ids := range byClients {
+		compliance, err := describeCompliance(context.TODO(), c.SSM, ids)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to get the patch compliance in %s: %v\n", c.Region, err)
+			continue
+		}
+		known[c] = compliance
+	}
+
+	filtered := instances[:0]
+	for _, instance := range instances {
+		status, ok := known[instance.clients][instance.InstanceId]
+		if !ok {
+			continue // unknown, its region failed
+		}
+		instance.compliance = status
+		if !status.Compliant() {
+			filtered = append(filtered, instance)
+		}
+	}
+	return filtered
+}
+
+// describeCompliance returns the compliance of the given instances, by
+// instance id, with DescribeInstanceInformation and
+// DescribeInstancePatchStates
+func describeCompliance(ctx context.Context, client *ssm.Client, ids []string) (map[string]*Compliance, error) {
+	compliance := make(map[string]*Compliance, len(ids))
+	for _, id := range ids {
+		compliance[id] = &Compliance{}
+	}
+
+	for start := 0; start < len(ids); start += complianceBatch {
+		batch := ids[start:min(start+complianceBatch, len(ids))]
+
+		information := ssm.NewDescribeInstanceInformationPaginator(client, &ssm.DescribeInstanceInformationInput{
+			Filters: []ssmtypes.InstanceInformationStringFilter{{
+				Key:    aws.String(string(ssmtypes.InstanceInformationFilterKeyInstanceIds)),
+				Values: batch,
+			}},
+		})
+		for information.HasMorePages() {
+			page, err := information.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to describe the SSM managed instances: %w", err)
+			}
+			for _, info := range page.InstanceInformationList {
+				c, ok := compliance[aws.ToString(info.InstanceId)]
+				if !ok {
+					continue
+				}
+				c.Managed = true
+				c.PingStatus = string(info.PingStatus)
+				c.AgentVersion = aws.ToString(info.AgentVersion)
+				c.AgentLatest = aws.ToBool(info.IsLatestVersion)
+			}
+		}
+
+		states := ssm.NewDescribeInstancePatchStatesPaginator(client, &ssm.DescribeInstancePatchStatesInput{
+			InstanceIds: batch,
+		})
+		for states.HasMorePages() {
+			page, err := states.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to describe the patch states: %w", err)
+			}
+			for _, state := range page.InstancePatchStates {
+				c, ok := compliance[aws.ToString(state.InstanceId)]
+				if !ok {
+					continue
+				}
+				c.Scanned = true
+				c.Missing = int(state.MissingCount)
+				c.Failed = int(state.FailedCount)
+				c.PendingReboot = int(aws.ToInt32(state.InstalledPendingRebootCount))
+				c.Critical = int(aws.ToInt32(state.CriticalNonCompliantCount))
+				c.LastScan = aws.ToTime(state.OperationEndTime)
+			}
+		}
+	}
+	return compliance, nil
+}
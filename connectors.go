@@ -0,0 +1,92 @@
+package ec2ssh
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Connector is a custom connection method, for organizations reaching their
+// instances through something else than ssh or SSM, e.g. Teleport, Boundary
+// or Tailscale SSH. Registered connectors reuse the discovery, the finder and
+// the connection planning, and only build the command lines.
+type Connector interface {
+	// Command returns the command line opening an interactive session on
+	// the planned instance, running plan.Command instead of a login shell
+	// when set
+	Command(plan *ConnectionPlan) []string
+	// RemoteCommand returns the command line running a command on the
+	// planned instance without a terminal, its output going to stdout
+	RemoteCommand(plan *ConnectionPlan, command string) []string
+}
+
+var (
+	connectorsMu sync.RWMutex
+	connectors   = make(map[string]Connector)
+)
+
+// RegisterConnector makes a connection method available under a name, for
+// the ec2ssh:connect tag and --connect. It's meant to be called from the
+// main package of a custom build, before New, and panics if the name is
+// taken or is one of the built-in methods.
+func RegisterConnector(name string, connector Connector) {
+	connectorsMu.Lock()
+	defer connectorsMu.Unlock()
+
+	if name == "" || name == MethodSSH || name == MethodSSM {
+		panic(fmt.Sprintf("ec2ssh: invalid connector name %q", name))
+	}
+	if connector == nil {
+		panic("ec2ssh: RegisterConnector connector is nil")
+	}
+	if _, ok := connectors[name]; ok {
+		panic("ec2ssh: RegisterConnector called twice for connector " + name)
+	}
+	connectors[name] = connector
+}
+
+// connectorFor returns the registered connector of a connection method
+func connectorFor(method string) (Connector, bool) {
+	connectorsMu.RLock()
+	defer connectorsMu.RUnlock()
+	connector, ok := connectors[method]
+	return connector, ok
+}
+
+// connectionMethods returns the built-in and registered connection methods
+func connectionMethods() []string {
+	connectorsMu.RLock()
+	defer connectorsMu.RUnlock()
+	var methods []string
+	for name := range connectors {
+		methods = append(methods, name)
+	}
+	sort.Strings(methods)
+	return append([]string{MethodSSH, MethodSSM}, methods...)
+}
+
+// knownMethod reports whether a connection method is built in or registered
+func knownMethod(method string) bool {
+	_, ok := connectorFor(method)
+	return ok || method == MethodSSH || method == MethodSSM
+}
+
+// validateConnect checks the --connect method exists
+func validateConnect(method string) error {
+	if method == "" || knownMethod(method) {
+		return nil
+	}
+	return fmt.Errorf("unknown connection method %q, valid methods are: %s", method, strings.Join(connectionMethods(), ", "))
+}
+
+// requireBuiltinMethods refuses features built on ssh or SSM sessions for
+// instances planned with a custom connector
+func requireBuiltinMethods(plans []*ConnectionPlan, feature string) error {
+	for _, plan := range plans {
+		if _, ok := connectorFor(plan.Method); ok {
+			return fmt.Errorf("%s isn't supported with the %s connector of %s", feature, plan.Method, plan.Instance.InstanceId)
+		}
+	}
+	return nil
+}
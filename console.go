@@ -0,0 +1,89 @@
+package ec2ssh
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+// printConsoleOutput prints the latest serial console output of the selected
+// instances, which is usually the first thing to look at when neither SSH nor
+// SSM can reach them
+func printConsoleOutput(instances []*Instance) error {
+	for _, instance := range instances {
+		if instance.clients == nil {
+			return fmt.Errorf("no client available for %s", instance.InstanceId)
+		}
+
+		output, err := instance.clients.EC2.GetConsoleOutput(context.TODO(), &ec2.GetConsoleOutputInput{
+			InstanceId: aws.String(instance.InstanceId),
+			Latest:     aws.Bool(true),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get the console output of %s: %w", instance.InstanceId, err)
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(aws.ToString(output.Output))
+		if err != nil {
+			return fmt.Errorf("invalid console output for %s: %w", instance.InstanceId, err)
+		}
+
+		if len(instances) > 1 {
+			fmt.Printf("==> %s <==\n", instance.InstanceId)
+		}
+		if len(decoded) == 0 {
+			fmt.Println("(no console output available yet)")
+		}
+		os.Stdout.Write(decoded)
+	}
+	return nil
+}
+
+// saveScreenshots saves a screenshot of the console of the selected instances
+// to the current directory, and opens them with the desktop's image viewer
+func saveScreenshots(instances []*Instance) error {
+	for _, instance := range instances {
+		if instance.clients == nil {
+			return fmt.Errorf("no client available for %s", instance.InstanceId)
+		}
+
+		output, err := instance.clients.EC2.GetConsoleScreenshot(context.TODO(), &ec2.GetConsoleScreenshotInput{
+			InstanceId: aws.String(instance.InstanceId),
+			WakeUp:     aws.Bool(true),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get a screenshot of %s: %w", instance.InstanceId, err)
+		}
+
+		image, err := base64.StdEncoding.DecodeString(aws.ToString(output.ImageData))
+		if err != nil {
+			return fmt.Errorf("invalid screenshot for %s: %w", instance.InstanceId, err)
+		}
+
+		path := instance.InstanceId + "-screenshot.jpg"
+		if err := os.WriteFile(path, image, 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("Saved %s\n", path)
+		openFile(path)
+	}
+	return nil
+}
+
+// openFile opens a file with the desktop's default application, if any
+func openFile(path string) {
+	opener := "xdg-open"
+	if runtime.GOOS == "darwin" {
+		opener = "open"
+	}
+	if _, err := exec.LookPath(opener); err != nil {
+		return
+	}
+	exec.Command(opener, path).Start()
+}
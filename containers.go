@@ -0,0 +1,63 @@
+package ec2ssh
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	finder "github.com/ktr0731/go-fuzzyfinder"
+)
+
+type ContainersConfig struct {
+	CLI string `mapstructure:"cli"` // docker, or a compatible CLI such as nerdctl
+}
+
+// container is a line of "docker ps --format '{{json .}}'"
+type container struct {
+	ID     string
+	Names  string
+	Image  string
+	Status string
+}
+
+// containerShell starts bash in the container when available, sh otherwise
+const containerShell = "sh -c 'command -v bash >/dev/null && exec bash || exec sh'"
+
+// pickContainer lists the containers running on the planned instance and
+// turns the plan into an exec into the one picked
+func (e *Ec2ssh) pickContainer(plan *ConnectionPlan) error {
+	cli := e.options.Containers.CLI
+	output, err := e.runRemote(plan, cli+" ps --format '{{json .}}'")
+	if err != nil {
+		return fmt.Errorf("failed to list containers on %s: %w", plan.Instance.InstanceId, err)
+	}
+
+	var containers []container
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		var c container
+		if err := json.Unmarshal([]byte(line), &c); err != nil {
+			return fmt.Errorf("unexpected %s ps output: %w", cli, err)
+		}
+		containers = append(containers, c)
+	}
+	if len(containers) == 0 {
+		return fmt.Errorf("no running containers on %s", plan.Instance.InstanceId)
+	}
+
+	idx, err := finder.Find(containers, func(i int) string {
+		return fmt.Sprintf("%s (%s) %s", containers[i].Names, containers[i].Image, containers[i].Status)
+	}, finder.WithPromptString("container> "))
+	if err != nil {
+		if errors.Is(err, finder.ErrAbort) {
+			e.exit(1)
+		}
+		return err
+	}
+
+	plan.Command = fmt.Sprintf("%s exec -it %s %s", cli, shellQuote(containers[idx].ID), containerShell)
+	return nil
+}
@@ -0,0 +1,82 @@
+package ec2ssh
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ContextConfig is a named set of profiles, regions and filters used by bare
+// invocations once selected with "ec2-ssh use"
+type ContextConfig struct {
+	Profiles []string `mapstructure:"profiles"`
+	Regions  []string `mapstructure:"regions"`
+	Filters  []string `mapstructure:"filters"`
+}
+
+// contextPath returns the file holding the name of the current context
+func contextPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".config", "ec2-ssh", "current-context")
+}
+
+// currentContext returns the name of the current context, empty if none
+func currentContext() string {
+	data, err := os.ReadFile(contextPath())
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// runUseCommand lists the configured contexts, or switches to the given one;
+// "-" clears the current context
+func runUseCommand(args []string, contexts map[string]ContextConfig) error {
+	if len(args) == 0 {
+		if len(contexts) == 0 {
+			fmt.Println("No contexts configured, add [contexts.<name>] sections to the config file")
+			return nil
+		}
+
+		names := make([]string, 0, len(contexts))
+		for name := range contexts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		current := currentContext()
+		for _, name := range names {
+			marker := " "
+			if name == current {
+				marker = "*"
+			}
+			fmt.Printf("%s %-16s profiles=%s regions=%s filters=%s\n", marker, name,
+				strings.Join(contexts[name].Profiles, ","),
+				strings.Join(contexts[name].Regions, ","),
+				strings.Join(contexts[name].Filters, ","))
+		}
+		return nil
+	}
+
+	name := args[0]
+	if name == "-" {
+		if err := os.Remove(contextPath()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		fmt.Println("Cleared the current context")
+		return nil
+	}
+
+	if _, ok := contexts[name]; !ok {
+		return fmt.Errorf("unknown context %q, run 'ec2-ssh use' to list them", name)
+	}
+	if err := os.MkdirAll(filepath.Dir(contextPath()), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(contextPath(), []byte(name+"\n"), 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("Switched to context %q\n", name)
+	return nil
+}
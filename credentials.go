@@ -0,0 +1,81 @@
+package ec2ssh
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// defaultCredentialsWork reports whether the default credential chain
+// resolves to credentials AWS accepts, which retrieving them alone doesn't
+// tell for expired SSO sessions or assumed roles
+func defaultCredentialsWork(options Options) bool {
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		return false
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	_, err = sts.NewFromConfig(cfg, func(o *sts.Options) {
+		o.BaseEndpoint = endpoint(options, "sts")
+	}).GetCallerIdentity(context.TODO(), &sts.GetCallerIdentityInput{})
+	return err == nil
+}
+
+// probeCredentials checks the credentials of every profile with
+// sts:GetCallerIdentity before the instances are listed, so expired SSO
+// sessions are logged into once per profile up front rather than failing
+// every region of the fan-out. Org mode accounts are checked as they're
+// listed.
+func (e *Ec2ssh) probeCredentials() error {
+	if e.options.Org.Enabled {
+		return nil
+	}
+
+	// The regions of a profile share its credentials, one probe is enough
+	var probed []*awsClients
+	seen := make(map[string]bool)
+	for _, c := range e.clients {
+		if !seen[c.Profile] {
+			seen[c.Profile] = true
+			probed = append(probed, c)
+		}
+	}
+
+	errs := make([]error, len(probed))
+	wg := &sync.WaitGroup{}
+	for i, c := range probed {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = c.STS.GetCallerIdentity(context.TODO(), &sts.GetCallerIdentityInput{})
+		}()
+	}
+	wg.Wait()
+
+	// SSO logins are interactive, so failures are handled one at a time
+	for i, c := range probed {
+		err := errs[i]
+		if err != nil && e.handleSSOError(err, c.Profile) {
+			_, err = c.STS.GetCallerIdentity(context.TODO(), &sts.GetCallerIdentityInput{})
+		}
+		if err != nil {
+			return fmt.Errorf("the credentials of %s don't work: %w", profileName(c.Profile), err)
+		}
+	}
+	return nil
+}
+
+// profileName names a profile in messages, the empty one being the default
+// credentials
+func profileName(profile string) string {
+	if profile == "" {
+		return "the default credentials"
+	}
+	return fmt.Sprintf("profile %q", profile)
+}
@@ -0,0 +1,86 @@
+package ec2ssh
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/processcreds"
+	"github.com/spf13/viper"
+)
+
+// anyProfile is the credential_helpers key used for the profiles without
+// their own helper
+const anyProfile = "*"
+
+// readCredentialHelpers reads the credential helper command of each profile
+func readCredentialHelpers() map[string]string {
+	return viper.GetStringMapString("credential_helpers")
+}
+
+// credentialHelper returns the command printing the credentials of a profile,
+// in the credential_process JSON format, empty when the shared config chain
+// is used. {profile} is replaced by the profile name.
+func credentialHelper(options Options, profile string) string {
+	command, ok := options.CredentialHelpers[strings.ToLower(profile)]
+	if !ok && profile != "" {
+		command = options.CredentialHelpers[anyProfile]
+	}
+	return strings.ReplaceAll(command, "{profile}", profile)
+}
+
+// helperCredentials returns the credentials provider running a credential
+// helper, such as "aws-vault exec prod --json" or "granted credential-process
+// --profile prod", caching its credentials until they expire
+func helperCredentials(command string) aws.CredentialsProvider {
+	return aws.NewCredentialsCache(processcreds.NewProvider(command))
+}
+
+// cliProfile returns the profile the aws CLI should use for an instance,
+// none when its credentials come from a helper or an assumed role and are
+// passed in the environment instead
+func cliProfile(instance *Instance) string {
+	if instance.clients != nil && instance.clients.Credentials != nil {
+		return ""
+	}
+	return instance.Profile
+}
+
+// exportSessionCredentials passes the credentials of a member account in org
+// mode, or of a profile using a credential helper, to the aws CLI started for
+// SSM sessions through the environment. The environment is shared by every
+// connection, so SSM sessions can only use one of them at a time.
+func exportSessionCredentials(plans []*ConnectionPlan) error {
+	var credentials aws.CredentialsProvider
+	var source string
+	for _, plan := range plans {
+		c := plan.Instance.clients
+		if c == nil || c.Credentials == nil || plan.Method != MethodSSM {
+			continue
+		}
+		name := c.Account
+		if name == "" {
+			name = c.Profile
+		}
+		if credentials != nil && name != source {
+			return fmt.Errorf("SSM sessions can only use the credentials of one account or profile at a time, %s and %s were selected", source, name)
+		}
+		credentials = c.Credentials
+		source = name
+	}
+	if credentials == nil {
+		return nil
+	}
+
+	creds, err := credentials.Retrieve(context.TODO())
+	if err != nil {
+		return fmt.Errorf("failed to get the credentials of %s: %w", source, err)
+	}
+	os.Unsetenv("AWS_PROFILE")
+	os.Setenv("AWS_ACCESS_KEY_ID", creds.AccessKeyID)
+	os.Setenv("AWS_SECRET_ACCESS_KEY", creds.SecretAccessKey)
+	os.Setenv("AWS_SESSION_TOKEN", creds.SessionToken)
+	return nil
+}
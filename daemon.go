@@ -0,0 +1,509 @@
+package ec2ssh
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultDaemonRefresh is how often the daemon lists instances again
+	defaultDaemonRefresh = 5 * time.Minute
+
+	// daemonIdle is how long the daemon keeps refreshing a list nobody asked
+	// for
+	daemonIdle = time.Hour
+
+	// daemonDialTimeout bounds how long the CLI waits for the daemon socket
+	daemonDialTimeout = 200 * time.Millisecond
+)
+
+// DaemonConfig configures "ec2-ssh daemon"
+type DaemonConfig struct {
+	Refresh time.Duration
+	HTTP    string // loopback address of the HTTP API, disabled when empty
+}
+
+// daemonSocketPath returns the unix socket the daemon listens on
+func daemonSocketPath() string {
+	return filepath.Join(cacheDir(), "daemon.sock")
+}
+
+// ListQuery holds the options a listing depends on, the daemon keeps one
+// warm list per query
+type ListQuery struct {
+	Profiles         []string
+	Regions          []string
+	ProfileRegions   map[string][]string
+	RegionOrder      RegionOrderConfig
+	Filters          []string
+	Membership       MembershipFilters
+	ResourceGroup    string
+	Org              OrgConfig
+	Discovery        string
+	ResourceExplorer ResourceExplorerConfig
+	MaxInstances     int
+	ShowDuplicates   bool
+	SearchFields     []string
+	Command          string // only set for the commands changing the listing
+}
+
+// newListQuery returns the listing query of the options
+func newListQuery(o Options) ListQuery {
+	var command string
+	if o.Command == "triage" {
+		// Only lists the unhealthy targets
+		command = o.Command
+	}
+	return ListQuery{
+		Profiles:         o.Profiles,
+		Regions:          o.Regions,
+		ProfileRegions:   o.ProfileRegions,
+		RegionOrder:      o.RegionOrder,
+		Filters:          o.Filters,
+		Membership:       o.Membership,
+		ResourceGroup:    o.ResourceGroup,
+		Org:              o.Org,
+		Discovery:        o.Discovery,
+		ResourceExplorer: o.ResourceExplorer,
+		MaxInstances:     o.MaxInstances,
+		ShowDuplicates:   o.ShowDuplicates,
+		SearchFields:     o.SearchFields,
+		Command:          command,
+	}
+}
+
+// key identifies the query
+func (q ListQuery) key() string {
+	data, _ := json.Marshal(q)
+	return string(data)
+}
+
+// apply returns the options with the listing options of the query
+func (q ListQuery) apply(o Options) Options {
+	o.Profiles = q.Profiles
+	o.Regions = q.Regions
+	o.ProfileRegions = q.ProfileRegions
+	o.RegionOrder = q.RegionOrder
+	o.Filters = q.Filters
+	o.Membership = q.Membership
+	o.ResourceGroup = q.ResourceGroup
+	o.Org = q.Org
+	o.Discovery = q.Discovery
+	o.ResourceExplorer = q.ResourceExplorer
+	o.MaxInstances = q.MaxInstances
+	o.ShowDuplicates = q.ShowDuplicates
+	o.SearchFields = q.SearchFields
+	if q.Command != "" {
+		o.Command = q.Command
+	}
+	return o
+}
+
+// ListReply is the instance list of a query
+type ListReply struct {
+	Instances []Instance
+	Fetched   time.Time
+}
+
+// DaemonStatus describes the running daemon and its warm lists
+type DaemonStatus struct {
+	Pid     int
+	Started time.Time
+	Lists   []DaemonListStatus
+}
+
+// DaemonListStatus describes a warm list
+type DaemonListStatus struct {
+	Profiles  []string
+	Regions   []string
+	Filters   []string
+	Instances int
+	Fetched   time.Time
+	Error     string
+}
+
+// daemonList is a list kept warm by the daemon. The clients are kept along
+// with it so their credentials, SSO ones included, stay cached in memory.
+type daemonList struct {
+	query     ListQuery
+	ec2ssh    *Ec2ssh
+	instances []Instance
+	fetched   time.Time
+	lastUsed  time.Time
+	err       error
+	ready     chan struct{} // closed once the first listing ended
+}
+
+// Daemon serves instance lists over a unix socket, refreshing them in the
+// background
+type Daemon struct {
+	options  Options
+	accounts *AccountAliases
+	started  time.Time
+	listener net.Listener
+
+	mu    sync.Mutex
+	lists map[string]*daemonList
+}
+
+// List returns the instance list of a query, listing the instances the first
+// time the query is seen
+func (d *Daemon) List(query ListQuery, reply *ListReply) error {
+	list, err := d.list(query)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	reply.Instances = list.instances
+	reply.Fetched = list.fetched
+	return nil
+}
+
+// list returns the warm list of a query, listing the instances the first
+// time the query is seen
+func (d *Daemon) list(query ListQuery) (*daemonList, error) {
+	key := query.key()
+
+	d.mu.Lock()
+	list, ok := d.lists[key]
+	if !ok {
+		list = &daemonList{query: query, ready: make(chan struct{})}
+		d.lists[key] = list
+	}
+	list.lastUsed = time.Now()
+	d.mu.Unlock()
+
+	if ok {
+		daemonMetrics.inc(daemonMetrics.cacheLookups, "hit")
+		// Requested again while its first listing is still running
+		<-list.ready
+	} else {
+		daemonMetrics.inc(daemonMetrics.cacheLookups, "miss")
+		d.refresh(list)
+		close(list.ready)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if list.instances == nil && list.err != nil {
+		// Nothing to serve, let the CLI list and handle the error itself
+		if d.lists[key] == list {
+			delete(d.lists, key)
+		}
+		return nil, list.err
+	}
+	return list, nil
+}
+
+// Status describes the daemon and its warm lists
+func (d *Daemon) Status(_ int, reply *DaemonStatus) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	reply.Pid = os.Getpid()
+	reply.Started = d.started
+	for _, list := range d.lists {
+		status := DaemonListStatus{
+			Profiles:  list.query.Profiles,
+			Regions:   list.query.Regions,
+			Filters:   list.query.Filters,
+			Instances: len(list.instances),
+			Fetched:   list.fetched,
+		}
+		if list.err != nil {
+			status.Error = list.err.Error()
+		}
+		reply.Lists = append(reply.Lists, status)
+	}
+	sort.Slice(reply.Lists, func(i, j int) bool {
+		return strings.Join(reply.Lists[i].Profiles, ",") < strings.Join(reply.Lists[j].Profiles, ",")
+	})
+	return nil
+}
+
+// Stop shuts the daemon down once the reply is sent
+func (d *Daemon) Stop(_ int, _ *int) error {
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		d.listener.Close()
+	}()
+	return nil
+}
+
+// refresh lists the instances of a warm list again, keeping the previous
+// instances when listing fails
+func (d *Daemon) refresh(list *daemonList) {
+	d.mu.Lock()
+	e := list.ec2ssh
+	d.mu.Unlock()
+
+	if e == nil {
+		options := list.query.apply(d.options)
+		// The clients count their API calls for /metrics as the daemon's
+		clientOptions := options
+		clientOptions.Command = d.options.Command
+		clients, err := newClients(clientOptions)
+		if err != nil {
+			d.mu.Lock()
+			list.err = err
+			d.mu.Unlock()
+			return
+		}
+		e = &Ec2ssh{options: options, clients: clients, accounts: d.accounts}
+	}
+
+	start := time.Now()
+	instances, _, err := e.fetchAll()
+	daemonMetrics.observe(daemonMetrics.listLatency, time.Since(start))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	list.ec2ssh = e
+	list.err = err
+	if err == nil {
+		list.instances = instances
+		list.fetched = time.Now()
+	}
+}
+
+// refreshLoop keeps the lists warm, dropping the ones nobody asked for in a
+// while
+func (d *Daemon) refreshLoop() {
+	for range time.Tick(d.options.Daemon.Refresh) {
+		d.mu.Lock()
+		var lists []*daemonList
+		for key, list := range d.lists {
+			select {
+			case <-list.ready:
+			default:
+				// Still being listed for its first request
+				continue
+			}
+			if time.Since(list.lastUsed) > daemonIdle {
+				delete(d.lists, key)
+				continue
+			}
+			lists = append(lists, list)
+		}
+		d.mu.Unlock()
+
+		for _, list := range lists {
+			d.refresh(list)
+		}
+	}
+}
+
+// runDaemon serves instance lists on the daemon socket until stopped
+func (e *Ec2ssh) runDaemon() error {
+	if e.options.Daemon.Refresh <= 0 {
+		e.options.Daemon.Refresh = defaultDaemonRefresh
+	}
+	// The daemon always lists, the on-disk cache is still written
+	e.options.Cache.Refresh = true
+
+	path := daemonSocketPath()
+	if daemonRunning() {
+		return fmt.Errorf("the daemon is already running")
+	}
+	os.Remove(path)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	defer os.Remove(path)
+	os.Chmod(path, 0o600)
+
+	d := &Daemon{
+		options:  e.options,
+		accounts: e.accounts,
+		started:  time.Now(),
+		listener: listener,
+		lists:    make(map[string]*daemonList),
+	}
+	server := rpc.NewServer()
+	if err := server.Register(d); err != nil {
+		return err
+	}
+
+	// Survive the terminal it was started from, and clean the socket up on
+	// termination
+	signal.Ignore(syscall.SIGHUP)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		listener.Close()
+	}()
+
+	go d.refreshLoop()
+
+	if e.options.Daemon.HTTP != "" {
+		if err := d.serveHTTP(e.options.Daemon.HTTP); err != nil {
+			return err
+		}
+		defer os.Remove(daemonTokenPath())
+	}
+
+	fmt.Printf("Listening on %s\n", path)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return nil
+		}
+		go server.ServeConn(conn)
+	}
+}
+
+// dialDaemon connects to the running daemon
+func dialDaemon() (*rpc.Client, error) {
+	conn, err := net.DialTimeout("unix", daemonSocketPath(), daemonDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return rpc.NewClient(conn), nil
+}
+
+// daemonRunning reports whether a daemon answers on the socket
+func daemonRunning() bool {
+	client, err := dialDaemon()
+	if err != nil {
+		return false
+	}
+	client.Close()
+	return true
+}
+
+// daemonInstances asks the running daemon for the instance list, if any.
+// The instances get the clients of this process, so connecting to them
+// doesn't depend on the daemon.
+func (e *Ec2ssh) daemonInstances() ([]Instance, bool) {
+	if e.options.Cache.Refresh {
+		return nil, false
+	}
+	client, err := dialDaemon()
+	if err != nil {
+		return nil, false
+	}
+	defer client.Close()
+
+	var reply ListReply
+	if err := client.Call("Daemon.List", newListQuery(e.options), &reply); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: the daemon failed to list instances, listing them directly: %v\n", err)
+		return nil, false
+	}
+
+	for i := range reply.Instances {
+		instance := &reply.Instances[i]
+		instance.detail = &instanceDetail{}
+		for _, c := range e.clients {
+			if c.Profile == instance.Profile && c.Region == instance.Region &&
+				(c.Account == "" || c.Account == instance.OwnerId) {
+				instance.clients = c
+				break
+			}
+		}
+	}
+	return reply.Instances, true
+}
+
+// runDaemonCommand handles "ec2-ssh daemon start|stop|status". "daemon run",
+// serving in the foreground, is handled by Run as it needs the options.
+func runDaemonCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("Usage: ec2-ssh daemon start|stop|status|run")
+	}
+
+	switch args[0] {
+	case "start":
+		if daemonRunning() {
+			fmt.Println("The daemon is already running")
+			return nil
+		}
+		executable, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		logPath := filepath.Join(cacheDir(), "daemon.log")
+		if err := os.MkdirAll(filepath.Dir(logPath), 0o755); err != nil {
+			return err
+		}
+		log, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		defer log.Close()
+
+		cmd := exec.Command(executable, append([]string{"daemon", "run"}, args[1:]...)...)
+		cmd.Stdout = log
+		cmd.Stderr = log
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("failed to start the daemon: %w", err)
+		}
+
+		// Wait for the socket so the next run already uses it
+		for i := 0; i < 50 && !daemonRunning(); i++ {
+			time.Sleep(100 * time.Millisecond)
+		}
+		if !daemonRunning() {
+			return fmt.Errorf("the daemon didn't start, see %s", logPath)
+		}
+		fmt.Printf("Started the daemon (pid %d), logging to %s\n", cmd.Process.Pid, logPath)
+		return nil
+	case "stop":
+		client, err := dialDaemon()
+		if err != nil {
+			fmt.Println("The daemon isn't running")
+			return nil
+		}
+		defer client.Close()
+		if err := client.Call("Daemon.Stop", 0, new(int)); err != nil {
+			return err
+		}
+		fmt.Println("Stopped the daemon")
+		return nil
+	case "status":
+		client, err := dialDaemon()
+		if err != nil {
+			fmt.Println("The daemon isn't running")
+			return nil
+		}
+		defer client.Close()
+		var status DaemonStatus
+		if err := client.Call("Daemon.Status", 0, &status); err != nil {
+			return err
+		}
+		fmt.Printf("Running (pid %d) since %s\n", status.Pid, status.Started.Format(time.RFC3339))
+		for _, list := range status.Lists {
+			profiles := strings.Join(list.Profiles, ",")
+			if profiles == "" {
+				profiles = "default"
+			}
+			line := fmt.Sprintf("  %s %s %s: %d instances, fetched %s ago", profiles,
+				strings.Join(list.Regions, ","), strings.Join(list.Filters, ","),
+				list.Instances, time.Since(list.Fetched).Round(time.Second))
+			if list.Error != "" {
+				line += " (last refresh failed: " + list.Error + ")"
+			}
+			fmt.Println(line)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown daemon command %q, valid commands are: start, stop, status, run", args[0])
+	}
+}
@@ -0,0 +1,279 @@
+package ec2ssh
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// daemonTokenPath returns the file holding the bearer token of the HTTP API,
+// only readable by the daemon's user like its socket
+func daemonTokenPath() string {
+	return filepath.Join(cacheDir(), "daemon.token")
+}
+
+// apiInstance is an instance as served by the HTTP API
+type apiInstance struct {
+	Instance
+	TargetID   string
+	ConsoleURL string
+}
+
+// apiPlan is a connection plan as served by the HTTP API, with the command
+// to run to connect
+type apiPlan struct {
+	InstanceId string
+	TargetID   string
+	Method     string
+	Host       string
+	User       string
+	Port       string
+	Command    []string
+	Reasons    []string
+}
+
+// serveHTTP serves the daemon's instance lists and connection plans as JSON
+// on a loopback address, for editors, launchers and other tools:
+//
+//	GET /instances?profile=prod,shared&region=eu-west-1&filter=tag:Env=prod
+//	GET /plan?profile=prod&instance=i-0123456789abcdef0
+//	GET /status
+//	GET /metrics (Prometheus)
+//
+// Other local users can reach a loopback port, so requests must carry the
+// bearer token written to daemonTokenPath for the daemon's user.
+func (d *Daemon) serveHTTP(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid daemon.http address %q: %w", addr, err)
+	}
+	if !isLoopback(host) {
+		return fmt.Errorf("daemon.http must be a loopback address, e.g. 127.0.0.1:7744, got %q", addr)
+	}
+	token, err := writeDaemonToken()
+	if err != nil {
+		return fmt.Errorf("failed to write the daemon.http token: %w", err)
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/instances", d.handleInstances)
+	mux.HandleFunc("/plan", d.handlePlan)
+	mux.HandleFunc("/status", d.handleStatus)
+	mux.HandleFunc("/metrics", d.handleMetrics)
+
+	server := &http.Server{
+		Handler:           loopbackOnly(withToken(token, mux)),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go server.Serve(listener)
+	fmt.Printf("Serving the HTTP API on http://%s, with the token in %s\n", addr, daemonTokenPath())
+	return nil
+}
+
+// writeDaemonToken writes a new random bearer token for the HTTP API
+func writeDaemonToken() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(data)
+
+	path := daemonTokenPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	// Removed first so an existing file with looser permissions isn't reused
+	os.Remove(path)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if _, err := file.WriteString(token + "\n"); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// withToken rejects requests without the bearer token of the API
+func withToken(token string, next http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isLoopback reports whether a host name is a loopback address
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// loopbackOnly rejects requests for other host names, so web pages can't
+// reach the API through DNS rebinding
+func loopbackOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if !isLoopback(host) {
+			http.Error(w, "forbidden host", http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// httpQuery builds the listing query of a request from its profile, region
+// and filter parameters, the regions of the profiles being detected like on
+// the command line
+func (d *Daemon) httpQuery(r *http.Request) ListQuery {
+	params := r.URL.Query()
+	options := d.options
+
+	options.Profiles = splitParam(params["profile"])
+	options.Filters = params["filter"]
+	options.ProfileRegions = make(map[string][]string)
+	if regions := splitParam(params["region"]); len(regions) > 0 {
+		options.Regions = regions
+	} else {
+		for _, profile := range options.Profiles {
+			if region := getRegionFromProfile(profile); region != "" {
+				options.ProfileRegions[profile] = []string{region}
+			}
+		}
+	}
+	return newListQuery(options)
+}
+
+// splitParam splits comma separated query parameters
+func splitParam(values []string) []string {
+	var split []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item != "" {
+				split = append(split, item)
+			}
+		}
+	}
+	return split
+}
+
+// handleInstances serves the instance list of a query
+func (d *Daemon) handleInstances(w http.ResponseWriter, r *http.Request) {
+	list, err := d.list(d.httpQuery(r))
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	d.mu.Lock()
+	instances := make([]apiInstance, len(list.instances))
+	for i := range list.instances {
+		instances[i] = apiInstance{
+			Instance:   list.instances[i],
+			TargetID:   list.instances[i].TargetID(),
+			ConsoleURL: list.instances[i].ConsoleURL(),
+		}
+	}
+	d.mu.Unlock()
+	writeJSON(w, instances)
+}
+
+// handlePlan serves how to connect to an instance of a query
+func (d *Daemon) handlePlan(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("instance")
+	if id == "" {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("missing instance parameter"))
+		return
+	}
+
+	list, err := d.list(d.httpQuery(r))
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	d.mu.Lock()
+	var instance *Instance
+	for i := range list.instances {
+		if list.instances[i].InstanceId == id || list.instances[i].TargetID() == id {
+			found := list.instances[i]
+			instance = &found
+			break
+		}
+	}
+	e := list.ec2ssh
+	d.mu.Unlock()
+
+	if instance == nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("instance %s not found", id))
+		return
+	}
+	// Guarded instances need a confirmation the API can't ask for, so the
+	// planner refuses them
+	plan := e.PlanConnection(instance)
+	if plan.refused != nil {
+		writeJSONError(w, http.StatusForbidden, plan.refused)
+		return
+	}
+	if !plan.Valid() {
+		writeJSONError(w, http.StatusUnprocessableEntity, fmt.Errorf("no connection details available for %s", id))
+		return
+	}
+	writeJSON(w, apiPlan{
+		InstanceId: instance.InstanceId,
+		TargetID:   instance.TargetID(),
+		Method:     plan.Method,
+		Host:       plan.Host,
+		User:       plan.User,
+		Port:       plan.Port,
+		Command:    e.command(plan),
+		Reasons:    plan.Reasons,
+	})
+}
+
+// handleStatus serves the daemon status
+func (d *Daemon) handleStatus(w http.ResponseWriter, r *http.Request) {
+	var status DaemonStatus
+	d.Status(0, &status)
+	writeJSON(w, status)
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeJSONError writes an error as a JSON response
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,158 @@
+package ec2ssh
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// instanceChange is a field of an instance that changed between two
+// snapshots
+type instanceChange struct {
+	Field    string
+	Old, New string
+}
+
+// runDiffCommand prints the instances added, removed and changed between two
+// snapshots, e.g. taken before and after a deployment
+func runDiffCommand(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("Usage: ec2-ssh diff <old-snapshot.json> <new-snapshot.json>")
+	}
+	old, err := readSnapshot(args[0])
+	if err != nil {
+		return err
+	}
+	updated, err := readSnapshot(args[1])
+	if err != nil {
+		return err
+	}
+
+	var added, removed, changed int
+	for _, entry := range diffSnapshots(old, updated) {
+		switch entry.Kind {
+		case '+':
+			fmt.Printf("+ %s\n", diffLabel(entry.Instance))
+			added++
+		case '-':
+			fmt.Printf("- %s\n", diffLabel(entry.Instance))
+			removed++
+		case '~':
+			parts := make([]string, len(entry.Changes))
+			for i, change := range entry.Changes {
+				parts[i] = fmt.Sprintf("%s %s → %s", change.Field, orNone(change.Old), orNone(change.New))
+			}
+			fmt.Printf("~ %s %s: %s\n", entry.Instance.InstanceId, entry.Instance.Tags["Name"], strings.Join(parts, ", "))
+			changed++
+		}
+	}
+
+	fmt.Printf("%d added, %d removed, %d changed between %s and %s\n", added, removed, changed,
+		old.Taken.Local().Format("2006-01-02 15:04"), updated.Taken.Local().Format("2006-01-02 15:04"))
+	return nil
+}
+
+// diffEntry is an instance added (+), removed (-) or changed (~) between two
+// snapshots
+type diffEntry struct {
+	Kind     byte
+	Instance *Instance
+	Changes  []instanceChange
+}
+
+// diffSnapshots compares two snapshots, matching their instances by target
+// id so the same instance id in two accounts or regions isn't mixed up. The
+// instances added or changed come first, then the removed ones, each sorted
+// by name and id.
+func diffSnapshots(old, updated *Snapshot) []diffEntry {
+	before := make(map[string]*Instance, len(old.Instances))
+	for i := range old.Instances {
+		before[old.Instances[i].TargetID()] = &old.Instances[i]
+	}
+	after := make(map[string]*Instance, len(updated.Instances))
+	for i := range updated.Instances {
+		after[updated.Instances[i].TargetID()] = &updated.Instances[i]
+	}
+
+	var entries []diffEntry
+	for _, instance := range sortedInstances(updated.Instances) {
+		previous, ok := before[instance.TargetID()]
+		if !ok {
+			entries = append(entries, diffEntry{Kind: '+', Instance: instance})
+			continue
+		}
+		if changes := instanceChanges(previous, instance); len(changes) > 0 {
+			entries = append(entries, diffEntry{Kind: '~', Instance: instance, Changes: changes})
+		}
+	}
+	for _, instance := range sortedInstances(old.Instances) {
+		if _, ok := after[instance.TargetID()]; !ok {
+			entries = append(entries, diffEntry{Kind: '-', Instance: instance})
+		}
+	}
+	return entries
+}
+
+// sortedInstances returns a snapshot's instances sorted by name and id
+func sortedInstances(instances []Instance) []*Instance {
+	sorted := make([]*Instance, len(instances))
+	for i := range instances {
+		sorted[i] = &instances[i]
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Tags["Name"] != sorted[j].Tags["Name"] {
+			return sorted[i].Tags["Name"] < sorted[j].Tags["Name"]
+		}
+		return sorted[i].InstanceId < sorted[j].InstanceId
+	})
+	return sorted
+}
+
+// diffLabel describes an added or removed instance
+func diffLabel(instance *Instance) string {
+	return fmt.Sprintf("%s %s (%s, %s, %s)", instance.InstanceId, instance.Tags["Name"],
+		orNone(instance.State.Name), orNone(instance.PrivateIpAddress), instance.InstanceType)
+}
+
+// instanceChanges returns the fields of an instance that changed between
+// two snapshots: its state, addresses, type, image and tags
+func instanceChanges(old, updated *Instance) []instanceChange {
+	var changes []instanceChange
+	fields := []struct {
+		name     string
+		old, new string
+	}{
+		{"state", old.State.Name, updated.State.Name},
+		{"private IP", old.PrivateIpAddress, updated.PrivateIpAddress},
+		{"public IP", old.PublicIpAddress, updated.PublicIpAddress},
+		{"type", old.InstanceType, updated.InstanceType},
+		{"image", old.ImageId, updated.ImageId},
+	}
+	for _, field := range fields {
+		if field.old != field.new {
+			changes = append(changes, instanceChange{field.name, field.old, field.new})
+		}
+	}
+
+	keys := make(map[string]bool)
+	for key := range old.Tags {
+		keys[key] = true
+	}
+	for key := range updated.Tags {
+		keys[key] = true
+	}
+	for _, key := range sortedKeys(keys) {
+		if old.Tags[key] != updated.Tags[key] {
+			changes = append(changes, instanceChange{"tag " + key, old.Tags[key], updated.Tags[key]})
+		}
+	}
+	return changes
+}
+
+// orNone shows empty values as (none)
+func orNone(value string) string {
+	if value == "" {
+		return "(none)"
+	}
+	return value
+}
@@ -0,0 +1,51 @@
+package ec2ssh
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	instance := func(region, id, name, state string) Instance {
+		return Instance{
+			InstanceId: id,
+			OwnerId:    "111111111111",
+			Region:     region,
+			State:      InstanceState{Name: state},
+			Tags:       map[string]string{"Name": name},
+		}
+	}
+
+	old := &Snapshot{Instances: []Instance{
+		instance("us-east-1", "i-1", "web-1", "running"),
+		instance("us-east-1", "i-2", "web-2", "running"),
+		instance("us-east-1", "i-3", "web-3", "running"),
+	}}
+	updated := &Snapshot{Instances: []Instance{
+		instance("us-east-1", "i-2", "web-2", "stopped"),
+		instance("us-east-1", "i-3", "web-3", "running"),
+		// Same id in another region, a different instance
+		instance("eu-west-1", "i-1", "api-1", "running"),
+	}}
+	updated.Instances[1].Tags["version"] = "2"
+
+	type entry struct {
+		Kind    string
+		Target  string
+		Changes []instanceChange
+	}
+	want := []entry{
+		{"+", "111111111111/eu-west-1/i-1", nil},
+		{"~", "111111111111/us-east-1/i-2", []instanceChange{{"state", "running", "stopped"}}},
+		{"~", "111111111111/us-east-1/i-3", []instanceChange{{"tag version", "", "2"}}},
+		{"-", "111111111111/us-east-1/i-1", nil},
+	}
+
+	var got []entry
+	for _, e := range diffSnapshots(old, updated) {
+		got = append(got, entry{string(e.Kind), e.Instance.TargetID(), e.Changes})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffSnapshots() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,145 @@
+package ec2ssh
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	re "github.com/aws/aws-sdk-go-v2/service/resourceexplorer2"
+)
+
+// DiscoveryResourceExplorer finds instances through a single AWS Resource
+// Explorer search instead of listing every profile and region
+const DiscoveryResourceExplorer = "resource-explorer"
+
+// resourceExplorerLimit is the number of results a Resource Explorer search
+// returns at most
+const resourceExplorerLimit = 1000
+
+// ResourceExplorerConfig configures the Resource Explorer discovery backend
+type ResourceExplorerConfig struct {
+	View  string // view ARN, the default view of the region otherwise
+	Query string // extra search terms, e.g. "tag:env=prod"
+}
+
+// discoveredInstances holds the ids of the instances found by the discovery
+// backend, by account and region
+type discoveredInstances map[string][]string
+
+// forClients returns the ids of the instances found in the region of the
+// clients, and in their account when it's known
+func (d discoveredInstances) forClients(c *awsClients) []string {
+	var ids []string
+	for key, found := range d {
+		account, region, _ := strings.Cut(key, "/")
+		if region != c.Region || (c.Account != "" && account != c.Account) {
+			continue
+		}
+		ids = append(ids, found...)
+	}
+	return ids
+}
+
+// discoveryClient returns the clients Resource Explorer is searched with:
+// the first profile's, or in org mode the ones of the account owning the
+// configured view, the management account's otherwise, as the aggregator
+// index is usually there rather than in an arbitrary member account
+func (e *Ec2ssh) discoveryClient() (*awsClients, error) {
+	if !e.options.Org.Enabled {
+		return e.clients[0], nil
+	}
+
+	var account string
+	if parts := strings.Split(e.options.ResourceExplorer.View, ":"); len(parts) > 4 {
+		account = parts[4]
+	}
+	for _, c := range e.clients {
+		if account != "" && c.Account == account {
+			return c, nil
+		}
+		// Only the management account is reached without assuming a role
+		if account == "" && c.Credentials == nil {
+			return c, nil
+		}
+	}
+	if account != "" {
+		return nil, fmt.Errorf("the account %s of resource_explorer.view isn't part of the organization listing", account)
+	}
+	return nil, fmt.Errorf("the management account is excluded from the organization listing, set resource_explorer.view to search another account's view")
+}
+
+// discoverInstances searches the instances of every account and region
+// indexed by Resource Explorer, through the view of the first profile's
+// region or the configured one. Resource Explorer only knows about ids and
+// tags, the instances found are then described in their own region.
+func (e *Ec2ssh) discoverInstances(ctx context.Context) (discoveredInstances, error) {
+	c, err := e.discoveryClient()
+	if err != nil {
+		return nil, err
+	}
+	config := e.options.ResourceExplorer
+
+	input := &re.SearchInput{
+		QueryString: aws.String(strings.TrimSpace("resourcetype:ec2:instance " + config.Query)),
+	}
+	region := c.Region
+	if config.View != "" {
+		input.ViewArn = aws.String(config.View)
+		// Views are regional, they can only be searched in their region
+		if parts := strings.Split(config.View, ":"); len(parts) > 3 {
+			region = parts[3]
+		}
+	}
+
+	found := make(discoveredInstances)
+	count := 0
+	paginator := re.NewSearchPaginator(c.ResourceExplorer, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx, func(o *re.Options) {
+			o.Region = region
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to search Resource Explorer: %w", err)
+		}
+		for _, resource := range page.Resources {
+			// arn:aws:ec2:<region>:<account>:instance/<instance id>
+			arn := aws.ToString(resource.Arn)
+			id := arn[strings.LastIndex(arn, "/")+1:]
+			key := aws.ToString(resource.OwningAccountId) + "/" + aws.ToString(resource.Region)
+			found[key] = append(found[key], id)
+			count++
+		}
+	}
+
+	if count >= resourceExplorerLimit {
+		return nil, fmt.Errorf("Resource Explorer returned its maximum of %d results, narrow the search down with resource_explorer.query", resourceExplorerLimit)
+	}
+	return found, nil
+}
+
+// discover runs the configured discovery backend, nil meaning every profile
+// and region is listed. The listing falls back to that when the backend
+// fails.
+func (e *Ec2ssh) discover() discoveredInstances {
+	if e.options.Discovery != DiscoveryResourceExplorer {
+		return nil
+	}
+
+	found, err := e.discoverInstances(context.TODO())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v, listing every region instead\n", err)
+		return nil
+	}
+	return found
+}
+
+// validateDiscovery checks the configured discovery backend is known
+func validateDiscovery(discovery string) error {
+	switch discovery {
+	case "", "describe-instances", DiscoveryResourceExplorer:
+		return nil
+	}
+	return fmt.Errorf("invalid discovery %q, valid backends are: describe-instances, %s", discovery, DiscoveryResourceExplorer)
+}
@@ -0,0 +1,151 @@
+package ec2ssh
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// installHints tells how to install the external tools ec2-ssh relies on,
+// per platform
+var installHints = map[string]map[string]string{
+	"aws": {
+		"darwin": "brew install awscli",
+		"linux":  "see https://docs.aws.amazon.com/cli/latest/userguide/getting-started-install.html",
+	},
+	"session-manager-plugin": {
+		"darwin": "brew install --cask session-manager-plugin",
+		"linux":  "see https://docs.aws.amazon.com/systems-manager/latest/userguide/session-manager-working-with-install-plugin.html",
+	},
+	"ssh": {
+		"darwin": "ssh ships with macOS",
+		"linux":  "install the openssh-client package",
+	},
+	"ssh-keygen": {
+		"darwin": "ssh-keygen ships with macOS",
+		"linux":  "install the openssh-client package",
+	},
+	"xpanes": {
+		"darwin": "brew install xpanes",
+		"linux":  "see https://github.com/greymd/tmux-xpanes#installation",
+	},
+	"sshuttle": {
+		"darwin": "brew install sshuttle",
+		"linux":  "install the sshuttle package",
+	},
+	"tmux": {
+		"darwin": "brew install tmux",
+		"linux":  "install the tmux package",
+	},
+}
+
+// installHint returns the install instructions for a tool on this platform
+func installHint(tool string) string {
+	hints := installHints[tool]
+	if hint, ok := hints[runtime.GOOS]; ok {
+		return hint
+	}
+	return hints["linux"]
+}
+
+// requireTool returns an actionable error if a tool isn't in PATH
+func requireTool(tool string) error {
+	if _, err := exec.LookPath(tool); err != nil {
+		return fmt.Errorf("%s not found, install with: %s (run 'ec2-ssh doctor' to check all dependencies)", tool, installHint(tool))
+	}
+	return nil
+}
+
+type doctorCheck struct {
+	name     string
+	required bool
+	run      func() (detail string, err error)
+}
+
+// runDoctor checks the runtime dependencies and configuration files, and
+// returns false if a required check failed
+func runDoctor() bool {
+	checks := []doctorCheck{
+		{"aws", true, toolVersion("aws", "--version")},
+		{"session-manager-plugin", false, toolVersion("session-manager-plugin", "--version")},
+		{"ssh", true, toolVersion("ssh", "-V")},
+		{"ssh-agent keys", false, checkAgentKeys},
+		{"xpanes", false, toolVersion("xpanes", "--version")},
+		{"tmux", false, toolVersion("tmux", "-V")},
+		{"sshuttle", false, toolVersion("sshuttle", "--version")},
+		{"aws config", true, checkAWSConfig},
+		{"ec2-ssh config", false, checkConfigFile},
+	}
+
+	healthy := true
+	for _, check := range checks {
+		detail, err := check.run()
+		switch {
+		case err == nil:
+			fmt.Printf("✓ %-24s %s\n", check.name, detail)
+		case check.required:
+			healthy = false
+			fmt.Printf("✗ %-24s %v\n", check.name, err)
+		default:
+			fmt.Printf("! %-24s %v\n", check.name, err)
+		}
+	}
+	return healthy
+}
+
+// toolVersion returns a check running a tool's version command
+func toolVersion(tool string, args ...string) func() (string, error) {
+	return func() (string, error) {
+		if err := requireTool(tool); err != nil {
+			return "", fmt.Errorf("not found, install with: %s", installHint(tool))
+		}
+		output, err := exec.Command(tool, args...).CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("%s %s failed: %v", tool, strings.Join(args, " "), err)
+		}
+		lines := strings.SplitN(strings.TrimSpace(string(output)), "\n", 2)
+		return lines[0], nil
+	}
+}
+
+// checkAgentKeys checks an SSH agent is running and holds keys
+func checkAgentKeys() (string, error) {
+	if os.Getenv("SSH_AUTH_SOCK") == "" {
+		return "", fmt.Errorf("no SSH agent running (SSH_AUTH_SOCK is not set)")
+	}
+	output, err := exec.Command("ssh-add", "-l").Output()
+	if err != nil {
+		return "", fmt.Errorf("no keys loaded in the SSH agent, add one with: ssh-add")
+	}
+	keys := strings.Count(strings.TrimSpace(string(output)), "\n") + 1
+	return fmt.Sprintf("%d key(s) loaded", keys), nil
+}
+
+// checkAWSConfig checks the AWS config file exists and defines profiles
+func checkAWSConfig() (string, error) {
+	configPath := filepath.Join(os.Getenv("HOME"), ".aws", "config")
+	if _, err := os.Stat(configPath); err != nil {
+		return "", fmt.Errorf("%s not found, set up a profile with: aws configure sso", configPath)
+	}
+	return fmt.Sprintf("%s (%d profiles)", configPath, len(getAWSProfiles())), nil
+}
+
+// checkConfigFile checks the ec2-ssh config file, if any, parses
+func checkConfigFile() (string, error) {
+	v := viper.New()
+	v.SetConfigName("config")
+	v.SetConfigType("toml")
+	v.AddConfigPath("$HOME/.config/ec2-ssh")
+	if err := v.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+			return "no config file, using defaults", nil
+		}
+		return "", fmt.Errorf("invalid config file: %v", err)
+	}
+	return v.ConfigFileUsed(), nil
+}
@@ -12,14 +12,29 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 )
 
-func (e *Ec2ssh) ListInstances(ec2Client *ec2.Client) ([]types.Instance, error) {
-	instances := make([]types.Instance, 0)
+// describeFilterValues is the number of values DescribeInstances accepts in a
+// filter at most
+const describeFilterValues = 200
+
+func (e *Ec2ssh) ListInstances(ctx context.Context, ec2Client *ec2.Client, extraFilters ...types.Filter) ([]Instance, error) {
+	instances := make([]Instance, 0)
 	filters := make([]types.Filter, 0, 0)
 
-	filters = append(filters, types.Filter{
-		Name:   aws.String("instance-state-name"),
-		Values: []string{"pending", "running", "shutting-down"},
-	})
+	// Instances that can be connected to are listed by default, a state
+	// filter given by the user (e.g. to include stopped instances) replaces
+	// that default
+	stateFiltered := false
+	for _, filter := range e.options.Filters {
+		if strings.HasPrefix(filter, "instance-state-name=") {
+			stateFiltered = true
+		}
+	}
+	if !stateFiltered {
+		filters = append(filters, types.Filter{
+			Name:   aws.String("instance-state-name"),
+			Values: []string{"pending", "running", "shutting-down"},
+		})
+	}
 
 	for _, filter := range e.options.Filters {
 		split := strings.SplitN(filter, "=", 2)
@@ -27,99 +42,136 @@ func (e *Ec2ssh) ListInstances(ec2Client *ec2.Client) ([]types.Instance, error)
 			return nil, fmt.Errorf("Filters can only contain one '='. Filter \"%s\" has %d", filter, len(split))
 		}
 
+		// The flag splits on commas, several values of a filter are given
+		// as name=v1|v2 and match any of them
 		filters = append(filters, types.Filter{
 			Name:   aws.String(split[0]),
-			Values: []string{split[1]},
+			Values: strings.Split(split[1], "|"),
 		})
 	}
-	params := &ec2.DescribeInstancesInput{}
+	filters = append(filters, extraFilters...)
 
-	if len(filters) > 0 {
-		params.Filters = filters
-	}
+	for _, batch := range filterBatches(filters) {
+		params := &ec2.DescribeInstancesInput{}
 
-	paginator := ec2.NewDescribeInstancesPaginator(ec2Client, params)
-	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(context.TODO())
-		if err != nil {
-			return nil, err
+		if len(batch) > 0 {
+			params.Filters = batch
 		}
 
-		for _, r := range page.Reservations {
-			for _, i := range r.Instances {
-				instances = append(instances, i)
+		paginator := ec2.NewDescribeInstancesPaginator(ec2Client, params)
+		for paginator.HasMorePages() {
+			// Stop early rather than loading a huge fleet into the finder,
+			// once every client together found more than the cap
+			if e.options.MaxInstances > 0 && e.listed.Load() > int64(e.options.MaxInstances) {
+				e.truncated.Store(true)
+				break
+			}
+
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, r := range page.Reservations {
+				for _, i := range r.Instances {
+					instances = append(instances, newInstance(&i, aws.ToString(r.OwnerId), ec2Client.Options().Region))
+				}
+				e.listed.Add(int64(len(r.Instances)))
 			}
 		}
 	}
 
+	if e.wantsSearchField("ami-name") {
+		resolveImageNames(ec2Client, instances)
+	}
+
 	return instances, nil
 }
 
-func (e *Ec2ssh) GetConnectionDetails(instance *types.Instance) string {
-	// Check if this instance should use SSM
-	if e.shouldUseSSM(instance) {
-		return "ssm:" + *instance.InstanceId
+// filterBatches splits the filters with more values than DescribeInstances
+// accepts, e.g. long instance id lists, into filter sets described in turn
+func filterBatches(filters []types.Filter) [][]types.Filter {
+	for i, filter := range filters {
+		if len(filter.Values) <= describeFilterValues {
+			continue
+		}
+		var batches [][]types.Filter
+		for start := 0; start < len(filter.Values); start += describeFilterValues {
+			end := start + describeFilterValues
+			if end > len(filter.Values) {
+				end = len(filter.Values)
+			}
+			batch := append([]types.Filter{}, filters...)
+			batch[i].Values = filter.Values[start:end]
+			batches = append(batches, filterBatches(batch)...)
+		}
+		return batches
 	}
-	
-	if e.options.UsePrivateIp {
-		if instance.PrivateIpAddress != nil && *instance.PrivateIpAddress != "" {
-			return *instance.PrivateIpAddress
+	return [][]types.Filter{filters}
+}
+
+// dedupeInstances drops instances already listed through another profile,
+// keeping the first occurrence of each account and instance id
+func dedupeInstances(instances []Instance) []Instance {
+	seen := make(map[string]bool)
+	deduped := make([]Instance, 0, len(instances))
+	for _, instance := range instances {
+		if seen[instance.TargetID()] {
+			continue
 		}
-		return ""
+		seen[instance.TargetID()] = true
+		deduped = append(deduped, instance)
+	}
+	return deduped
+}
+
+// GetConnectionDetails returns the host to SSH to, or "<method>:<instance id>"
+// for instances reached through SSM or a custom connector
+func (e *Ec2ssh) GetConnectionDetails(instance *Instance) string {
+	plan := e.PlanConnection(instance)
+	if _, ok := connectorFor(plan.Method); ok || plan.Method == MethodSSM {
+		return plan.Method + ":" + instance.InstanceId
+	}
+	return plan.Host
+}
+
+// sshHost returns the address to SSH to according to the addressing options
+func (e *Ec2ssh) sshHost(instance *Instance) string {
+	if e.options.UsePrivateIp {
+		return instance.PrivateIpAddress
 	}
 	
 	// Try public DNS first
-	if instance.PublicDnsName != nil && *instance.PublicDnsName != "" {
-		return *instance.PublicDnsName
+	if instance.PublicDnsName != "" {
+		return instance.PublicDnsName
 	}
 	
 	// Fall back to public IP
-	if instance.PublicIpAddress != nil && *instance.PublicIpAddress != "" {
-		return *instance.PublicIpAddress
+	if instance.PublicIpAddress != "" {
+		return instance.PublicIpAddress
 	}
 	
 	// Don't fall back to private IP when explicitly not requested
 	return ""
 }
 
-func (e *Ec2ssh) shouldUseSSM(instance *types.Instance) bool {
+func (e *Ec2ssh) shouldUseSSM(instance *Instance) bool {
 	if e.options.SSM.TagKey == "" {
 		return false
 	}
 	
-	for _, tag := range instance.Tags {
-		if tag.Key != nil && *tag.Key == e.options.SSM.TagKey {
-			// If no specific value is required, any value matches
-			if e.options.SSM.TagValue == "" {
-				return true
-			}
-			// Otherwise, check for exact match
-			if tag.Value != nil && *tag.Value == e.options.SSM.TagValue {
-				return true
-			}
-		}
+	value, ok := instance.Tags[e.options.SSM.TagKey]
+	if !ok {
+		return false
 	}
-	return false
+	// If no specific value is required, any value matches, otherwise check
+	// for exact match
+	return e.options.SSM.TagValue == "" || value == e.options.SSM.TagValue
 }
 
-func TemplateForInstance(i *types.Instance, t *template.Template) (output string, err error) {
-	tags := make(map[string]string)
-
-	for _, t := range i.Tags {
-		tags[*t.Key] = *t.Value
-	}
-
+func TemplateForInstance(i *Instance, t *template.Template) (output string, err error) {
 	buffer := new(bytes.Buffer)
-	err = t.Execute(
-		buffer,
-		struct {
-			Tags map[string]string
-			*types.Instance
-		}{
-			tags,
-			i,
-		},
-	)
+	err = t.Execute(buffer, i)
 
 	output = buffer.String()
 	return
@@ -9,16 +9,15 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"text/template"
+	"time"
 
-	"github.com/Masterminds/sprig"
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
-	"github.com/aws/aws-sdk-go-v2/service/ssm"
 	finder "github.com/ktr0731/go-fuzzyfinder"
 )
 
@@ -27,207 +26,561 @@ type Ec2ssh struct {
 	options         Options
 	listTemplate    *template.Template
 	previewTemplate *template.Template
-	ec2Clients      []*ec2.Client
-	ssmClients      []*ssm.Client
+	shellProfile    *template.Template
+	logGroup        *template.Template
+	logStream       *template.Template
+	fleetCommand    *template.Template
+	clients         []*awsClients
+	accounts        *AccountAliases
+	httpClient      httpDoer
+	cleanups        []func()
+	cleanupsLock    sync.Mutex
+	traceCtx        context.Context
+	traceSpan       *span
+
+	// guardrailConfirmed holds the target ids of the guarded instances the
+	// user confirmed acting on
+	guardrailConfirmed map[string]bool
+
+	// listed counts the instances listed by every client of a listing, for
+	// --max-instances to cap the listing as a whole, and truncated is set
+	// when a client stopped with instances left
+	listed    atomic.Int64
+	truncated atomic.Bool
 }
 
 func New() (*Ec2ssh, error) {
 	options := ParseOptions()
 
-	// Check if we have a profile or valid default credentials
-	if options.Profile == "" {
-		// Try to load default config and test credentials
-		cfg, err := config.LoadDefaultConfig(context.TODO())
-		if err != nil {
+	// Before any request, as the proxy settings are only read once
+	if err := applyProxyEnvironment(options.HTTP); err != nil {
+		return nil, err
+	}
+
+	// Check if we have a profile or valid default credentials, otherwise let
+	// the user pick one of the configured profiles. The daemon gets its
+	// profiles from the queries it serves, and snapshots are browsed
+	// offline.
+	if len(options.Profiles) == 0 && !(options.Org.Enabled && options.Org.Profile != "") &&
+		options.Command != "daemon-run" && options.FromSnapshot == "" && !defaultCredentialsWork(options) {
+		profiles := getAWSProfiles()
+		if len(profiles) == 0 {
 			return nil, fmt.Errorf("no AWS profile specified and no default credentials found.\n\nUsage:\n  ec2-ssh <profile>  # Use a specific profile\n\nAvailable profiles: %s", 
-				formatProfiles(getAWSProfiles()))
+				formatProfiles(profiles))
 		}
-		
-		// Test if credentials actually work by trying to get caller identity
-		_, err = cfg.Credentials.Retrieve(context.TODO())
+
+		idx, err := finder.Find(profiles, func(i int) string {
+			return profiles[i]
+		}, finder.WithPromptString("profile> "))
 		if err != nil {
-			return nil, fmt.Errorf("no AWS profile specified and default credentials are invalid.\n\nUsage:\n  ec2-ssh <profile>  # Use a specific profile\n\nAvailable profiles: %s", 
-				formatProfiles(getAWSProfiles()))
+			if errors.Is(err, finder.ErrAbort) {
+				os.Exit(1)
+			}
+			return nil, err
 		}
-	}
 
-	clients := make([]*ec2.Client, 0)
-	ssmClients := make([]*ssm.Client, 0)
-	for _, region := range options.Regions {
-		var cfg aws.Config
-		var err error
-		
-		if options.Profile != "" {
-			cfg, err = config.LoadDefaultConfig(context.TODO(), 
-				config.WithRegion(region),
-				config.WithSharedConfigProfile(options.Profile))
-		} else {
-			cfg, err = config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
-		}
-		
-		if err != nil {
-			return nil, fmt.Errorf("failed to load AWS config: %w", err)
+		profile := profiles[idx]
+		options.Profiles = []string{profile}
+		if len(options.Regions) == 1 && options.Regions[0] == "us-east-1" {
+			if detectedRegion := getRegionFromProfile(profile); detectedRegion != "" {
+				options.ProfileRegions[profile] = []string{detectedRegion}
+			}
 		}
-		client := ec2.NewFromConfig(cfg)
-		clients = append(clients, client)
-		
-		ssmClient := ssm.NewFromConfig(cfg)
-		ssmClients = append(ssmClients, ssmClient)
 	}
 
-	tmpl, err := template.New("Instance").Funcs(sprig.TxtFuncMap()).Parse(options.Template)
+	if err := validateRetryConfig(options.Retry); err != nil {
+		return nil, err
+	}
+	if err := validateDiscovery(options.Discovery); err != nil {
+		return nil, err
+	}
+
+	clients, err := newClients(options)
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := sharedHTTPClient(options.HTTP)
+	if err != nil {
+		return nil, err
+	}
+	checkForUpdate(options.UpdateCheck, httpClient)
+
+	accounts := NewAccountAliases(options.AccountAliases)
+
+	if err := validateShell(options.Shell); err != nil {
+		return nil, err
+	}
+	funcs := templateFuncs(options, accounts)
+
+	if err := validateSearchFields(options.SearchFields); err != nil {
+		return nil, err
+	}
+	if err := validatePickBy(options.PickBy); err != nil {
+		return nil, err
+	}
+	if err := validateOutput(options.Output); err != nil {
+		return nil, err
+	}
+	if err := validateTracing(options.Tracing); err != nil {
+		return nil, err
+	}
+	if err := validateHostsFormat(options.Hosts.Format); err != nil {
+		return nil, err
+	}
+	if err := validateGuardrails(options.Guardrails); err != nil {
+		return nil, err
+	}
+	if err := validateConnect(options.Connect); err != nil {
+		return nil, err
+	}
+	if err := validatePlugins(options.Plugins); err != nil {
+		return nil, err
+	}
+	if err := validateFindings(options.Findings); err != nil {
+		return nil, err
+	}
+	if err := validateFallback(options.Fallback); err != nil {
+		return nil, err
+	}
+	if err := validateSSHKey(options.SSHKey); err != nil {
+		return nil, err
+	}
+	if err := validateBastions(options.Bastions); err != nil {
+		return nil, err
+	}
+	if err := validateMultiplex(options.Multiplex); err != nil {
+		return nil, err
+	}
+	if err := validateWebhook(options.Webhook); err != nil {
+		return nil, err
+	}
+	if err := validateReadOnly(options); err != nil {
+		return nil, err
+	}
+	enableTracing(options.Tracing, httpClient)
+
+	tmpl, err := template.New("Instance").Funcs(funcs).Parse(options.Template)
 	if err != nil {
 		panic(err)
 	}
 
-	previewTemplate, err := template.New("Preview").Funcs(sprig.TxtFuncMap()).Parse(options.PreviewTemplate)
+	previewTemplate, err := template.New("Preview").Funcs(funcs).Parse(options.PreviewTemplate)
 	if err != nil {
 		panic(err)
 	}
 
-	return &Ec2ssh{
+	var shellProfile *template.Template
+	if options.SSM.ShellProfile != "" {
+		shellProfile, err = template.New("ShellProfile").Funcs(funcs).Parse(options.SSM.ShellProfile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ssm.shell_profile: %w", err)
+		}
+	}
+	var logGroup, logStream *template.Template
+	if options.CloudWatchLogs.LogGroup != "" {
+		logGroup, err = template.New("LogGroup").Funcs(funcs).Parse(options.CloudWatchLogs.LogGroup)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cloudwatch_logs.log_group: %w", err)
+		}
+		logStream, err = template.New("LogStream").Funcs(funcs).Parse(options.CloudWatchLogs.Stream)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cloudwatch_logs.stream: %w", err)
+		}
+	}
+	var fleetCommand *template.Template
+	if options.Fleet.OutputDir != "" && options.Fleet.Command == "" {
+		return nil, fmt.Errorf("--output-dir needs a --command to run")
+	}
+	if options.Fleet.Command != "" {
+		// A missing tag fails rather than running the command with
+		// "<no value>" in it
+		fleetCommand, err = template.New("Command").Funcs(funcs).Option("missingkey=error").Parse(options.Fleet.Command)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --command: %w", err)
+		}
+	}
+	if err := teleport.configure(options.Teleport, funcs); err != nil {
+		return nil, err
+	}
+	tailscale.configure(options.Tailscale)
+
+	e := &Ec2ssh{
 		fzfInput:        new(bytes.Buffer),
 		options:         options,
 		listTemplate:    tmpl,
 		previewTemplate: previewTemplate,
-		ec2Clients:      clients,
-		ssmClients:      ssmClients,
-	}, nil
+		shellProfile:    shellProfile,
+		logGroup:        logGroup,
+		logStream:       logStream,
+		fleetCommand:    fleetCommand,
+		clients:         clients,
+		accounts:        accounts,
+		httpClient:      httpClient,
+	}
+	if options.Command != "daemon-run" && options.FromSnapshot == "" {
+		if err := e.probeCredentials(); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
 }
 
 func (e *Ec2ssh) Run() {
-	instances := make([]types.Instance, 0)
-	instancesLock := &sync.Mutex{}
-	var lastError error
+	if e.options.Command == "daemon-run" {
+		if err := e.runDaemon(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	wg := &sync.WaitGroup{}
-	for _, client := range e.ec2Clients {
-		wg.Add(1)
-		go func(c *ec2.Client) {
-			defer wg.Done()
-			retrivedInstances, err := e.ListInstances(c)
-			if err != nil {
-				instancesLock.Lock()
-				lastError = err
-				instancesLock.Unlock()
-				return
-			}
+	if e.options.Command == "cache-warm" {
+		if err := e.warmCache(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
-			instancesLock.Lock()
-			instances = append(instances, retrivedInstances...)
-			instancesLock.Unlock()
-		}(client)
+	if e.options.Command == "triage" && !e.options.Membership.Enabled() {
+		arn, err := e.pickTargetGroup()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		e.options.Membership.TargetGroups = []string{arn}
 	}
 
-	wg.Wait()
+	e.traceCtx, e.traceSpan = startSpan(context.Background(), "ec2-ssh", "ec2ssh.command", e.options.Command)
+	defer e.endTrace(nil)
 
-	// Handle SSO authentication errors
-	if lastError != nil {
-		if e.handleSSOError(lastError) {
-			// Retry after SSO login
-			e.Run()
-			return
+	instances := e.hideGuarded(e.listAll())
+	if e.options.NonCompliant {
+		instances = e.nonCompliantOnly(instances)
+	}
+	if e.options.Findings.Enabled {
+		e.lookupFindings(instances)
+	}
+	if e.options.Query != "" {
+		instances = e.filterByQuery(instances)
+	}
+
+	if e.options.Snapshot != "" {
+		if err := e.saveSnapshot(e.options.Snapshot, instances); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			e.exit(1)
 		}
-		panic(lastError)
+		return
 	}
 
-	indexes, err := finder.FindMulti(
-		instances,
-		func(i int) string {
-			str, _ := TemplateForInstance(&instances[i], e.listTemplate)
-			return fmt.Sprintf("%s\n", str)
-		},
-		finder.WithPreviewWindow(func(i, w, h int) string {
-			if i == -1 {
-				return ""
-			}
+	if e.options.Command == "hosts-gen" {
+		if err := e.generateHosts(instances); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			e.exit(1)
+		}
+		return
+	}
 
-			str, _ := TemplateForInstance(&instances[i], e.previewTemplate)
+	// Launchers get the list, and come back with --instance to connect
+	if e.options.Output != "" {
+		if err := e.printLauncherItems(instances); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			e.exit(1)
+		}
+		return
+	}
 
-			return str
-		}),
-	)
+	var selected []*Instance
+	if len(e.options.InstanceIds) > 0 {
+		instances = filterInstanceIds(instances, e.options.InstanceIds)
+		if len(instances) == 0 {
+			fmt.Fprintf(os.Stderr, "No instance found with id %s\n", strings.Join(e.options.InstanceIds, ", "))
+			e.exit(1)
+		}
+		for i := range instances {
+			selected = append(selected, &instances[i])
+		}
+	} else {
+		if e.options.PickBy != "" {
+			instances = e.pickByTag(instances)
+		}
+		selected = e.selectInstances(instances)
+	}
+	e.orderSelection(selected)
+	if !e.confirmSelection(selected) {
+		e.exit(1)
+	}
 
-	if err != nil {
-		if errors.Is(err, finder.ErrAbort) {
-			os.Exit(1)
+	if _, ok := guardedActions[e.options.Command]; ok {
+		selected = e.guardSelection(selected, e.options.Command)
+		if len(selected) == 0 {
+			e.exit(1)
 		}
-		panic(err)
 	}
 
-	// Collect all connection details first
-	var connectionDetails []string
-	var ssmConnections []bool
-	for _, idx := range indexes {
-		details := e.GetConnectionDetails(&instances[idx])
-		if details == "" {
-			fmt.Printf("No connection details available for selected instance %s\n", *instances[idx].InstanceId)
-			fmt.Printf("Debug - Public DNS: %v, Public IP: %v, Private IP: %v\n", 
-				getStringPtr(instances[idx].PublicDnsName),
-				getStringPtr(instances[idx].PublicIpAddress),
-				getStringPtr(instances[idx].PrivateIpAddress))
+	switch e.options.Command {
+	case "push-file":
+		if err := e.pushFile(selected, e.options.CommandArgs[0], e.options.CommandArgs[1]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			e.exit(1)
+		}
+		return
+	case "bookmark":
+		if err := bookmarkInstances(selected, e.options.CommandArgs[0]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			e.exit(1)
+		}
+		return
+	case "unbookmark":
+		if err := unbookmarkInstances(selected); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			e.exit(1)
+		}
+		return
+	case "pin":
+		if err := pinInstance(selected); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			e.exit(1)
+		}
+		return
+	case "stop", "terminate":
+		if err := e.runStateAction(selected, e.options.Command); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			e.exit(1)
+		}
+		return
+	case "console-output":
+		if err := printConsoleOutput(selected); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			e.exit(1)
+		}
+		return
+	case "serial":
+		if len(selected) > 1 {
+			fmt.Fprintln(os.Stderr, "serial works with a single instance")
+			e.exit(1)
+		}
+		if err := connectSerialConsole(selected[0], e.options.Session); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			e.exit(1)
+		}
+		return
+	case "screenshot":
+		if err := saveScreenshots(selected); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			e.exit(1)
+		}
+		return
+	case "cloudwatch-logs":
+		if err := e.runCloudWatchLogs(selected); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			e.exit(1)
+		}
+		return
+	}
+
+	// Plan all connections first
+	_, planSpan := startSpan(e.traceContext(), "plan", "ec2ssh.instances", strconv.Itoa(len(selected)))
+	var plans []*ConnectionPlan
+	for _, instance := range selected {
+		if err := e.checkGuardrail(instance, "connect to"); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			continue
+		}
+		if err := e.checkMaintenance(instance); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			continue
+		}
+
+		if instance.State.Name == "stopped" {
+			started, err := e.offerStart(instance)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				continue
+			}
+			if !started {
+				continue
+			}
+		}
+
+		plan := e.PlanConnection(instance)
+		if e.options.PickAddress && plan.Method == MethodSSH {
+			if err := e.pickAddress(plan); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				continue
+			}
+		}
+		if e.options.Verbose {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", instance.InstanceId, plan.Explain())
+		}
+		if plan.refused != nil {
+			fmt.Printf("Error: %v\n", plan.refused)
 			continue
 		}
-		connectionDetails = append(connectionDetails, details)
-		ssmConnections = append(ssmConnections, strings.HasPrefix(details, "ssm:"))
+		if !plan.Valid() {
+			fmt.Printf("No connection details available for selected instance %s\n", instance.InstanceId)
+			continue
+		}
+		plans = append(plans, plan)
 	}
+	planSpan.End(nil)
 
-	if len(connectionDetails) == 0 {
+	if len(plans) == 0 {
 		fmt.Println("No valid connection details found")
-		os.Exit(1)
+		e.exit(1)
+	}
+
+	if err := exportSessionCredentials(plans); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		e.exit(1)
+	}
+
+	if e.options.Multiplex.Reuse {
+		if err := checkControlSockets(plans); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			e.exit(1)
+		}
+	}
+
+	// Keys and certificates are only fetched for connections actually made
+	if !e.options.PrintOnly && e.options.SSHKey.Command != "" {
+		if err := e.loadSecretKeys(plans); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			e.exit(1)
+		}
+		defer e.cleanup()
+	}
+	if !e.options.PrintOnly && e.options.SSHCertificate.Command != "" {
+		if err := e.loadCertificates(plans); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			e.exit(1)
+		}
+	}
+
+	if e.options.Command == "logs" || e.options.Command == "tunnel" {
+		var err error
+		switch e.options.Command {
+		case "logs":
+			err = e.runLogs(plans)
+		case "tunnel":
+			if err = requireBuiltinMethods(plans, "tunnel"); err == nil {
+				err = e.runTunnels(plans)
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			e.exit(1)
+		}
+		return
 	}
 
 	// If print-only flag is set, just print and exit
+	if e.options.PrintOnly && e.fleetCommand != nil {
+		commands, err := e.fleetCommands(plans)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			e.exit(1)
+		}
+		for i, plan := range plans {
+			fmt.Println(shellJoin(e.remoteArgs(plan, commands[i])))
+		}
+		return
+	}
 	if e.options.PrintOnly {
-		for i, details := range connectionDetails {
-			if ssmConnections[i] {
-				instanceId := strings.TrimPrefix(details, "ssm:")
-				if e.options.Profile != "" {
-					fmt.Printf("aws ssm start-session --target %s --profile %s\n", instanceId, e.options.Profile)
-				} else {
-					fmt.Printf("aws ssm start-session --target %s\n", instanceId)
-				}
+		for _, plan := range plans {
+			if _, ok := connectorFor(plan.Method); ok {
+				fmt.Println(shellJoin(e.command(plan)))
+			} else if plan.Method == MethodSSM {
+				fmt.Printf("aws %s\n", shellJoin(e.ssmTargetArgs(plan.Instance.InstanceId, cliProfile(plan.Instance))))
 			} else {
-				fmt.Printf("ssh %s\n", details)
+				fmt.Printf("ssh %s\n", shellJoin(plan.sshArgs()))
 			}
 		}
 		return
 	}
 
+	if e.fleetCommand != nil {
+		if err := e.runFleet(plans); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			e.exit(1)
+		}
+		return
+	}
+
+	if e.options.Socks > 0 {
+		if len(plans) > 1 {
+			fmt.Fprintln(os.Stderr, "--socks works with a single instance")
+			e.exit(1)
+		}
+		if err := requireBuiltinMethods(plans, "--socks"); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			e.exit(1)
+		}
+		if err := e.runSocks(plans[0], e.options.Socks); err != nil {
+			fmt.Printf("SOCKS proxy failed: %v\n", err)
+			e.exit(1)
+		}
+		return
+	}
+
+	if e.options.Sshuttle {
+		if len(plans) > 1 {
+			fmt.Fprintln(os.Stderr, "--sshuttle works with a single instance")
+			e.exit(1)
+		}
+		if err := requireBuiltinMethods(plans, "--sshuttle"); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			e.exit(1)
+		}
+		if err := e.runSshuttle(plans[0]); err != nil {
+			fmt.Printf("sshuttle failed: %v\n", err)
+			e.exit(1)
+		}
+		return
+	}
+
+	if e.options.Container && len(plans) > 1 {
+		fmt.Fprintln(os.Stderr, "--container works with a single instance")
+		e.exit(1)
+	}
+
+	if e.options.AuthorizeMyIp {
+		if err := e.authorizeMyIp(plans); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			e.exit(1)
+		}
+		defer e.cleanup()
+	}
+
 	// Automatically use xpanes for multiple instances
-	if len(connectionDetails) > 1 {
-		fmt.Printf("Connecting to %d instances using xpanes...\n", len(connectionDetails))
+	if len(plans) > 1 {
+		fmt.Printf("Connecting to %d instances using xpanes...\n", len(plans))
 		
 		// Check if xpanes is available
-		if _, err := exec.LookPath("xpanes"); err != nil {
-			fmt.Println("Error: xpanes not found. Install with: brew install xpanes")
+		if err := requireTool("xpanes"); err != nil {
+			fmt.Printf("Error: %v\n", err)
 			fmt.Println("Falling back to single instance connection...")
 			
 			// Fall back to single instance
-			details := connectionDetails[0]
-			isSSM := ssmConnections[0]
-			e.connectToInstance(details, isSSM)
+			e.connectToInstance(plans[0])
 			return
 		}
 		
-		// Use xpanes to connect to all instances
+		e.shareJumpHosts(plans)
+		noticeSecurityKeys(plans)
+
+		// Use xpanes to connect to all instances, each pane titled with its
+		// instance's name and id
 		var args []string
-		for i, details := range connectionDetails {
-			if ssmConnections[i] {
-				instanceId := strings.TrimPrefix(details, "ssm:")
-				var command string
-				if e.options.Profile != "" {
-					command = fmt.Sprintf("aws ssm start-session --target %s --profile %s --document-name AWS-StartInteractiveCommand --parameters 'command=[\"%s\"]'", instanceId, e.options.Profile, e.options.SSM.Command)
-				} else {
-					command = fmt.Sprintf("aws ssm start-session --target %s --document-name AWS-StartInteractiveCommand --parameters 'command=[\"%s\"]'", instanceId, e.options.SSM.Command)
-				}
-				args = append(args, command)
-			} else {
-				args = append(args, fmt.Sprintf("ssh %s", details))
+		for _, plan := range plans {
+			if e.options.Panes.Prompt && plan.Instance.OSFamily() != "windows" {
+				plan.Prompt = paneTitle(plan.Instance)
 			}
+			args = append(args, titlePane(paneTitle(plan.Instance))+"; "+shellJoin(e.watchedCommand(plan, e.command(plan))))
+			recordConnection(plan.Method)
 		}
 		
-		xpanesArgs := []string{"-c", "{}"}
+		xpanesArgs := []string{"-t", "-c", "{}"}
 		xpanesArgs = append(xpanesArgs, args...)
 		
 		cmd := exec.Command("xpanes", xpanesArgs...)
@@ -235,61 +588,357 @@ func (e *Ec2ssh) Run() {
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 		
-		err := cmd.Run()
+		started := time.Now()
+		e.notifySessions(SessionStart, plans, started, nil)
+		err := e.traceCommand(cmd, "connect", "ec2ssh.method", "xpanes", "ec2ssh.instances", strconv.Itoa(len(plans)))
+		e.notifySessions(SessionEnd, plans, started, err)
 		if err != nil {
 			fmt.Printf("xpanes command failed: %v\n", err)
-			os.Exit(1)
+			e.exit(1)
 		}
 	} else {
 		// Single instance mode
-		details := connectionDetails[0]
-		isSSM := ssmConnections[0]
-		e.connectToInstance(details, isSSM)
+		if e.options.Container {
+			if err := e.pickContainer(plans[0]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				e.exit(1)
+			}
+		}
+		e.connectToInstance(plans[0])
 	}
 }
 
-func (e *Ec2ssh) connectToInstance(details string, isSSM bool) {
-	if isSSM {
-		instanceId := strings.TrimPrefix(details, "ssm:")
-		fmt.Printf("Connecting to %s via SSM...\n", instanceId)
-		
-		// Build AWS CLI command with profile if specified
-		args := []string{"ssm", "start-session", "--target", instanceId}
-		if e.options.Profile != "" {
-			args = append(args, "--profile", e.options.Profile)
-		}
-		args = append(args, "--document-name", "AWS-StartInteractiveCommand")
-		args = append(args, "--parameters", fmt.Sprintf("command=[\"%s\"]", e.options.SSM.Command))
-		
-		cmd := exec.Command("aws", args...)
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		
-		err := cmd.Run()
-		if err != nil {
+func (e *Ec2ssh) connectToInstance(plan *ConnectionPlan) {
+	if _, ok := connectorFor(plan.Method); ok {
+		fmt.Printf("Connecting to %s via %s...\n", plan.Instance.InstanceId, plan.Method)
+	} else if plan.Method == MethodSSM {
+		fmt.Printf("Connecting to %s via SSM...\n", plan.Instance.InstanceId)
+
+		if err := requireTool("aws"); err != nil {
 			fmt.Printf("SSM connection failed: %v\n", err)
-			os.Exit(1)
+			e.exit(1)
 		}
 	} else {
-		fmt.Printf("Connecting to %s...\n", details)
-		
-		// Execute SSH command
-		cmd := exec.Command("ssh", details)
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		
-		err := cmd.Run()
+		fmt.Printf("Connecting to %s...\n", plan.Host)
+	}
+
+	recordConnection(plan.Method)
+	command := e.command(plan)
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	stderr := &tailWriter{w: os.Stderr}
+	cmd.Stderr = stderr
+	
+	started := time.Now()
+	e.notifySessions(SessionStart, []*ConnectionPlan{plan}, started, nil)
+	stopWatching := e.watchSession(cmd, plan)
+	err := e.traceCommand(cmd, "connect", "ec2ssh.method", plan.Method, "ec2ssh.target_id", plan.Instance.TargetID())
+	stopWatching()
+	e.notifySessions(SessionEnd, []*ConnectionPlan{plan}, started, err)
+	if err != nil {
+		// Known failures are explained rather than reported by exit status
+		signature := diagnoseFailure(stderr.String())
+		if signature != nil {
+			fmt.Printf("%s connection failed. %s\n", strings.ToUpper(plan.Method), signature.hint)
+		} else {
+			fmt.Printf("%s connection failed: %v\n", strings.ToUpper(plan.Method), err)
+		}
+		e.offerHostKeyRemoval(plan, stderr.String())
+		e.offerFallback(plan, signature, time.Since(started))
+
+		// ssh exits with 255 when the connection itself failed, other codes
+		// come from the remote command
+		var exitErr *exec.ExitError
+		if plan.Method == MethodSSH && errors.As(err, &exitErr) && exitErr.ExitCode() == 255 {
+			e.offerReachabilityAnalysis(plan)
+		}
+		e.exit(1)
+	}
+}
+
+// listAll lists the instances of every profile and region, merged in profile
+// order, through the daemon when one is running
+func (e *Ec2ssh) listAll() []Instance {
+	if e.options.FromSnapshot != "" {
+		instances, err := e.snapshotInstances()
 		if err != nil {
-			fmt.Printf("SSH connection failed: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintln(os.Stderr, err)
+			e.exit(1)
+		}
+		return instances
+	}
+	if instances, ok := e.daemonInstances(); ok {
+		return instances
+	}
+
+	instances, profile, err := e.fetchAll()
+	if err != nil {
+		// Handle SSO authentication errors
+		if e.handleSSOError(err, profile) {
+			// Retry after SSO login
+			return e.listAll()
+		}
+		panic(err)
+	}
+	return instances
+}
+
+// fetchAll lists the instances of every profile and region. On failure, it
+// returns the profile the last error met came from.
+func (e *Ec2ssh) fetchAll() ([]Instance, string, error) {
+	results := make([][]Instance, len(e.clients))
+	e.listed.Store(0)
+	e.truncated.Store(false)
+	errorsLock := &sync.Mutex{}
+	var lastError error
+	var lastErrorProfile string
+
+	// Org mode lists many accounts, only a few of them at once
+	var limit chan struct{}
+	if e.options.Org.Enabled && e.options.Org.Concurrency > 0 {
+		limit = make(chan struct{}, e.options.Org.Concurrency)
+	}
+
+	ctx, listSpan := startSpan(e.traceContext(), "list", "ec2ssh.clients", strconv.Itoa(len(e.clients)))
+	defer func() { listSpan.End(lastError) }()
+
+	discovered := e.discover()
+
+	// Listing many regions and accounts takes a while, which is shown
+	// rather than leaving the terminal silent until the list opens
+	var listed, found atomic.Int64
+	unit := "regions"
+	if e.options.Org.Enabled {
+		unit = "account regions"
+	}
+	listing := startProgress(func() string {
+		return fmt.Sprintf("Listing instances: %d of %d %s listed, %d instances found", listed.Load(), len(e.clients), unit, found.Load())
+	})
+
+	wg := &sync.WaitGroup{}
+	for idx, client := range e.clients {
+		wg.Add(1)
+		go func(idx int, c *awsClients) {
+			defer wg.Done()
+			defer func() {
+				listed.Add(1)
+				found.Add(int64(len(results[idx])))
+			}()
+			if limit != nil {
+				limit <- struct{}{}
+				defer func() { <-limit }()
+			}
+
+			// Only the regions and accounts the discovery backend found
+			// instances in are listed
+			var extraFilters []types.Filter
+			if discovered != nil {
+				ids := discovered.forClients(c)
+				if len(ids) == 0 {
+					return
+				}
+				extraFilters = append(extraFilters, types.Filter{
+					Name:   aws.String("instance-id"),
+					Values: ids,
+				})
+			}
+
+			if len(e.options.InstanceIds) > 0 {
+				var ids []string
+				for _, target := range e.options.InstanceIds {
+					ids = append(ids, instanceIdOf(target))
+				}
+				extraFilters = append(extraFilters, types.Filter{
+					Name:   aws.String("instance-id"),
+					Values: ids,
+				})
+			}
+
+			// Membership filters are resolved per region to instance ids
+			var targetHealth map[string]string
+			if e.options.Membership.Enabled() {
+				ids, health, err := e.memberInstanceIds(context.TODO(), c)
+				if err == nil && len(ids) == 0 {
+					return
+				}
+				if err != nil {
+					errorsLock.Lock()
+					lastError = err
+					lastErrorProfile = c.Profile
+					errorsLock.Unlock()
+					return
+				}
+				targetHealth = health
+				extraFilters = append(extraFilters, types.Filter{
+					Name:   aws.String("instance-id"),
+					Values: ids,
+				})
+			}
+
+			// Resource groups are regional, regions without the group
+			// have nothing to list
+			if e.options.ResourceGroup != "" {
+				filters, found, err := e.resourceGroupFilters(context.TODO(), c)
+				if err == nil && !found {
+					return
+				}
+				if err != nil {
+					errorsLock.Lock()
+					lastError = err
+					lastErrorProfile = c.Profile
+					errorsLock.Unlock()
+					return
+				}
+				extraFilters = append(extraFilters, filters...)
+			}
+
+			clientCtx, clientSpan := startSpan(ctx, "list "+c.Region,
+				"aws.profile", c.Profile, "cloud.region", c.Region, "cloud.account.id", c.Account)
+			retrivedInstances, cached := e.cachedInstances(c)
+			clientSpan.SetAttribute("ec2ssh.cached", strconv.FormatBool(cached))
+			if !cached {
+				var err error
+				retrivedInstances, err = e.ListInstances(clientCtx, c.EC2, extraFilters...)
+				clientSpan.SetAttribute("ec2ssh.instances", strconv.Itoa(len(retrivedInstances)))
+				clientSpan.End(err)
+				if err != nil && c.Account != "" {
+					// An account the role can't be assumed in shouldn't hide
+					// the rest of the organization
+					listing.Warnf("skipping account %s (%s) in %s: %v", c.AccountName, c.Account, c.Region, err)
+					return
+				}
+				if err != nil {
+					errorsLock.Lock()
+					lastError = err
+					lastErrorProfile = c.Profile
+					errorsLock.Unlock()
+					return
+				}
+				e.storeInstances(c, retrivedInstances)
+			} else {
+				clientSpan.SetAttribute("ec2ssh.instances", strconv.Itoa(len(retrivedInstances)))
+				clientSpan.End(nil)
+			}
+			for i := range retrivedInstances {
+				retrivedInstances[i].TargetHealth = targetHealth[retrivedInstances[i].InstanceId]
+			}
+
+			for i := range retrivedInstances {
+				retrivedInstances[i].Profile = c.Profile
+				retrivedInstances[i].clients = c
+			}
+
+			// Resolve account aliases before rendering so templates can use them
+			if c.AccountName != "" {
+				e.accounts.Learn(c.Account, c.AccountName)
+			}
+			for _, account := range ownerIds(retrivedInstances) {
+				e.accounts.Resolve(context.TODO(), c.IAM, account)
+			}
+
+			results[idx] = retrivedInstances
+		}(idx, client)
+	}
+
+	wg.Wait()
+	listing.Stop()
+
+	if lastError != nil {
+		return nil, lastErrorProfile, lastError
+	}
+
+	// Results are merged in profile order, which gives earlier profiles
+	// precedence when the same instance is listed more than once
+	instances := make([]Instance, 0)
+	for _, result := range results {
+		instances = append(instances, result...)
+	}
+	if !e.options.ShowDuplicates {
+		instances = dedupeInstances(instances)
+	}
+
+	if e.options.MaxInstances > 0 && (len(instances) > e.options.MaxInstances || e.truncated.Load()) {
+		if len(instances) > e.options.MaxInstances {
+			instances = instances[:e.options.MaxInstances]
+		}
+		fmt.Fprintf(os.Stderr, "Warning: listing stopped at %d instances (--max-instances), narrow it down with --filters\n", e.options.MaxInstances)
+	}
+
+	return instances, "", nil
+}
+
+// selectInstances lets the user pick instances in the fuzzy finder
+func (e *Ec2ssh) selectInstances(instances []Instance) []*Instance {
+	accounts := ownerIds(instances)
+	prompt := "> "
+	if len(accounts) == 1 {
+		prompt = e.accounts.Lookup(accounts[0]) + "> "
+	}
+	if e.options.Context != "" {
+		prompt = "[" + e.options.Context + "] " + prompt
+	}
+
+	bookmarks, err := loadBookmarks()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	bookmarksFirst(instances, bookmarks)
+	e.prefetchPreviews(instances)
+
+	// The list only holds compact instances; previews are rendered in the
+	// background so templates using .Detail fetch the full description of
+	// the highlighted instance without blocking the finder
+	previews := newPreviewCache(func(i int) string {
+		str, err := TemplateForInstance(&instances[i], e.previewTemplate)
+		if err != nil {
+			str += fmt.Sprintf("\n\nTemplate error: %v", err)
+		}
+		if health := instances[i].TargetHealth; health != "" {
+			str = "Target health: " + health + "\n" + str
+		}
+		str = findingsSummary(instances[i].findings) + str
+		if bookmark, ok := bookmarks[instances[i].TargetID()]; ok {
+			str = "★ " + bookmark.Note + "\n" + str
+		}
+		return str
+	})
+
+	lines := e.renderList(instances, bookmarks)
+	indexes, err := finder.FindMulti(
+		instances,
+		func(i int) string {
+			return lines[i]
+		},
+		finder.WithPreviewWindow(func(i, w, h int) string {
+			if i == -1 {
+				return ""
+			}
+
+			return previews.Get(i, previewWait)
+		}),
+		finder.WithPromptString(prompt),
+	)
+
+	if err != nil {
+		if errors.Is(err, finder.ErrAbort) {
+			e.exit(1)
 		}
+		panic(err)
+	}
+
+	// FindMulti returns the indexes in the order they were picked, which is
+	// the order instances are connected to and their panes laid out in
+	selected := make([]*Instance, len(indexes))
+	for i, idx := range indexes {
+		selected[i] = &instances[idx]
 	}
+	return selected
+
 }
 
 // handleSSOError detects SSO authentication errors and automatically runs aws sso login
-func (e *Ec2ssh) handleSSOError(err error) bool {
+func (e *Ec2ssh) handleSSOError(err error, profile string) bool {
 	errStr := err.Error()
 	
 	// Check if this is an SSO authentication error
@@ -297,12 +946,12 @@ func (e *Ec2ssh) handleSSOError(err error) bool {
 		strings.Contains(errStr, "cached SSO token") ||
 		strings.Contains(errStr, "sso/cache") {
 		
-		fmt.Printf("SSO session expired. Running 'aws sso login' for profile '%s'...\n", e.options.Profile)
+		fmt.Printf("SSO session expired. Running 'aws sso login' for profile '%s'...\n", profile)
 		
 		// Get SSO session name from the profile
-		ssoSession := e.getSSOSessionFromProfile(e.options.Profile)
+		ssoSession := e.getSSOSessionFromProfile(profile)
 		if ssoSession == "" {
-			fmt.Printf("Could not determine SSO session for profile '%s'. Please run 'aws sso login --profile %s' manually.\n", e.options.Profile, e.options.Profile)
+			fmt.Printf("Could not determine SSO session for profile '%s'. Please run 'aws sso login --profile %s' manually.\n", profile, profile)
 			return false
 		}
 		
@@ -370,10 +1019,15 @@ func (e *Ec2ssh) getSSOSessionFromProfile(profile string) string {
 	return ""
 }
 
-// getStringPtr safely gets string value from pointer
-func getStringPtr(s *string) string {
-	if s == nil {
-		return "<nil>"
+// ownerIds returns the distinct account IDs owning the given instances
+func ownerIds(instances []Instance) []string {
+	seen := make(map[string]bool)
+	var ids []string
+	for _, instance := range instances {
+		if instance.OwnerId != "" && !seen[instance.OwnerId] {
+			seen[instance.OwnerId] = true
+			ids = append(ids, instance.OwnerId)
+		}
 	}
-	return *s
+	return ids
 }
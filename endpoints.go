@@ -0,0 +1,61 @@
+package ec2ssh
+
+import (
+	"fmt"
+	"strings"
+)
+
+type EndpointsConfig struct {
+	EC2 string `mapstructure:"ec2"`
+	SSM string `mapstructure:"ssm"`
+	IAM string `mapstructure:"iam"`
+}
+
+// serviceEndpoint returns the endpoint URL configured for a service, falling
+// back to the global --endpoint-url. An empty string means the SDK default.
+func (o Options) serviceEndpoint(service string) string {
+	var endpoint string
+	switch service {
+	case "ec2":
+		endpoint = o.Endpoints.EC2
+	case "ssm":
+		endpoint = o.Endpoints.SSM
+	case "iam":
+		endpoint = o.Endpoints.IAM
+	}
+	if endpoint == "" {
+		endpoint = o.EndpointUrl
+	}
+	return endpoint
+}
+
+// partition returns the AWS partition a region belongs to
+func partition(region string) string {
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return "aws-cn"
+	case strings.HasPrefix(region, "us-gov-"):
+		return "aws-us-gov"
+	default:
+		return "aws"
+	}
+}
+
+// consoleHost returns the AWS console host name for a region's partition
+func consoleHost(region string) string {
+	switch partition(region) {
+	case "aws-cn":
+		return "console.amazonaws.cn"
+	case "aws-us-gov":
+		return "console.amazonaws-us-gov.com"
+	default:
+		return "console.aws.amazon.com"
+	}
+}
+
+// ConsoleURL returns the link to the instance in the EC2 console of its
+// partition
+func (i *Instance) ConsoleURL() string {
+	return fmt.Sprintf("https://%s/ec2/home?region=%s#InstanceDetails:instanceId=%s",
+		consoleHost(i.Region), i.Region, i.InstanceId)
+}
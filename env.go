@@ -0,0 +1,77 @@
+package ec2ssh
+
+import (
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// EnvConfig lists the environment variables set in remote sessions, as
+// NAME=value entries, lists keeping the case of the names which viper maps
+// wouldn't
+type EnvConfig struct {
+	Global    []string            // for every session
+	ByProfile map[string][]string // for sessions through a profile, on top
+}
+
+// readEnvConfig reads the env and env_by_profile settings
+func readEnvConfig() EnvConfig {
+	config := EnvConfig{
+		Global:    viper.GetStringSlice("env"),
+		ByProfile: make(map[string][]string),
+	}
+	for profile := range viper.GetStringMap("env_by_profile") {
+		config.ByProfile[profile] = viper.GetStringSlice("env_by_profile." + profile)
+	}
+	return config
+}
+
+// sessionEnv returns the variables to set in a session on an instance, the
+// profile ones overriding the global ones, with local environment variables
+// expanded in the values (e.g. EC2SSH_OPERATOR=$USER)
+func (e *Ec2ssh) sessionEnv(instance *Instance) []string {
+	entries := append([]string{}, e.options.Env.Global...)
+	entries = append(entries, e.options.Env.ByProfile[strings.ToLower(instance.Profile)]...)
+
+	var env []string
+	index := make(map[string]int)
+	for _, entry := range entries {
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || name == "" {
+			continue
+		}
+		entry = name + "=" + os.ExpandEnv(value)
+		if i, seen := index[name]; seen {
+			env[i] = entry
+			continue
+		}
+		index[name] = len(env)
+		env = append(env, entry)
+	}
+	return env
+}
+
+// setEnvOption returns the ssh SetEnv option setting the variables. ssh only
+// keeps the first SetEnv given, so they all go in one, and sshd only accepts
+// the names allowed by its AcceptEnv.
+func setEnvOption(env []string) string {
+	quoted := make([]string, len(env))
+	for i, entry := range env {
+		name, value, _ := strings.Cut(entry, "=")
+		if strings.ContainsAny(value, " \t\"") {
+			value = `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+		}
+		quoted[i] = name + "=" + value
+	}
+	return "SetEnv=" + strings.Join(quoted, " ")
+}
+
+// withEnv prefixes a shell command with env setting the variables, for SSM
+// sessions which don't forward any
+func withEnv(env []string, command string) string {
+	if len(env) == 0 {
+		return command
+	}
+	return "env " + shellJoin(env) + " " + command
+}
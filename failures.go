@@ -0,0 +1,181 @@
+package ec2ssh
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fallback modes, for retrying failed connections with the other method
+const (
+	FallbackAsk  = "ask"
+	FallbackAuto = "auto"
+	FallbackOff  = "off"
+)
+
+// fallbackConnectTimeout bounds how long ssh tries to connect in auto
+// fallback mode, instead of the minutes a dropped SYN can take
+const fallbackConnectTimeout = 10 * time.Second
+
+// FallbackConfig configures how failed connections are retried with the
+// other built-in method
+type FallbackConfig struct {
+	Mode   string        // ask (default), auto or off
+	Within time.Duration // ssh failures this quick are retried via SSM without asking in auto mode
+}
+
+// stderrTail is how much of the stderr of connections is kept to diagnose
+// their failure
+const stderrTail = 16 * 1024
+
+// tailWriter writes through to another writer, keeping the last bytes
+// written
+type tailWriter struct {
+	w    io.Writer
+	lock sync.Mutex
+	tail []byte
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.lock.Lock()
+	t.tail = append(t.tail, p...)
+	if len(t.tail) > stderrTail {
+		t.tail = t.tail[len(t.tail)-stderrTail:]
+	}
+	t.lock.Unlock()
+	return t.w.Write(p)
+}
+
+// String returns the last bytes written
+func (t *tailWriter) String() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return string(t.tail)
+}
+
+// failureSignature recognizes a common connection failure from the stderr of
+// ssh or of the Session Manager plugin
+type failureSignature struct {
+	patterns []string
+	hint     string
+	fallback string // method worth retrying with, when the instance supports it
+
+	// unreachable failures happen before any session, so retrying them
+	// via SSM in auto mode can't run anything twice
+	unreachable bool
+}
+
+var failureSignatures = []failureSignature{
+	{
+		patterns: []string{"REMOTE HOST IDENTIFICATION HAS CHANGED"},
+		hint:     "The host key doesn't match the one in known_hosts, usually because the address now belongs to a new instance. Remove the stale entry with ssh-keygen -R <host> if the instance was replaced.",
+		fallback: MethodSSM,
+	},
+	{
+		patterns: []string{"Permission denied (publickey", "Too many authentication failures"},
+		hint:     "The instance refused the key. Check the user (ec2ssh:user tag or ~/.ssh/config), that the key pair is loaded in ssh-agent, or push a key with EC2 Instance Connect.",
+		fallback: MethodSSM,
+	},
+	{
+		patterns:    []string{"Connection timed out", "Operation timed out", "No route to host"},
+		hint:        "Nothing answered on the SSH port. Check the security groups, network ACLs and routes to the instance, or whether --use-private-ip or a bastion in [[bastions]] is needed.",
+		fallback:    MethodSSM,
+		unreachable: true,
+	},
+	{
+		patterns:    []string{"Connection refused"},
+		hint:        "The instance is reachable but nothing listens on the SSH port. sshd may be down, still booting, or listening on another port (ec2ssh:port tag).",
+		fallback:    MethodSSM,
+		unreachable: true,
+	},
+	{
+		patterns: []string{"TargetNotConnected"},
+		hint:     "The SSM agent of the instance isn't connected. Check that it runs, that the instance profile allows SSM (AmazonSSMManagedInstanceCore), and that it reaches the SSM endpoints through a NAT or VPC endpoints.",
+		fallback: MethodSSH,
+	},
+	{
+		patterns: []string{"AccessDeniedException", "AccessDenied"},
+		hint:     "Your credentials aren't allowed to start a session on this instance. ssm:StartSession must allow the instance and the session document, and may be restricted by tags or a permissions boundary.",
+		fallback: MethodSSH,
+	},
+	{
+		patterns: []string{"SessionManagerPlugin is not found"},
+		hint:     "The Session Manager plugin for the AWS CLI is missing, install it from https://docs.aws.amazon.com/systems-manager/latest/userguide/session-manager-working-with-install-plugin.html",
+	},
+}
+
+// diagnoseFailure returns the signature found in the stderr of a failed
+// connection, if any
+func diagnoseFailure(stderr string) *failureSignature {
+	for i, signature := range failureSignatures {
+		for _, pattern := range signature.patterns {
+			if strings.Contains(stderr, pattern) {
+				return &failureSignatures[i]
+			}
+		}
+	}
+	return nil
+}
+
+// validateFallback checks the fallback mode
+func validateFallback(config FallbackConfig) error {
+	switch config.Mode {
+	case "", FallbackAsk, FallbackAuto, FallbackOff:
+		return nil
+	}
+	return fmt.Errorf("invalid fallback.mode %q, expected %s, %s or %s", config.Mode, FallbackAsk, FallbackAuto, FallbackOff)
+}
+
+// offerFallback offers to retry a failed connection with the other built-in
+// method when the failure calls for it and the instance supports it. In auto
+// mode, ssh connections that failed quickly without reaching the instance are
+// retried via SSM right away. Plans that are already a retry aren't retried
+// again.
+func (e *Ec2ssh) offerFallback(plan *ConnectionPlan, signature *failureSignature, elapsed time.Duration) {
+	if e.options.Fallback.Mode == FallbackOff || plan.fallback {
+		return
+	}
+	if signature == nil || signature.fallback == "" || signature.fallback == plan.Method {
+		return
+	}
+	if !e.supportsMethod(plan, signature.fallback) {
+		return
+	}
+
+	automatic := e.options.Fallback.Mode == FallbackAuto && signature.unreachable && elapsed < e.options.Fallback.Within
+	if automatic {
+		fmt.Printf("Retrying via %s...\n", strings.ToUpper(signature.fallback))
+	} else if !confirm(fmt.Sprintf("Retry via %s?", strings.ToUpper(signature.fallback))) {
+		return
+	}
+	e.connectToInstance(e.fallbackPlan(plan, signature.fallback))
+	e.exit(0)
+}
+
+// supportsMethod reports whether an instance planned with ssh or SSM can be
+// reached with the other one: SSM needs the agent online, ssh an address
+func (e *Ec2ssh) supportsMethod(plan *ConnectionPlan, method string) bool {
+	if _, custom := connectorFor(plan.Method); custom {
+		return false
+	}
+	switch method {
+	case MethodSSM:
+		compliance, err := plan.Instance.Compliance()
+		return err == nil && compliance != nil && compliance.PingStatus == "Online"
+	case MethodSSH:
+		return e.sshHost(plan.Instance) != ""
+	}
+	return false
+}
+
+// fallbackPlan plans the connection to the instance of a failed plan again,
+// with another built-in method, keeping the command or prompt it was given
+func (e *Ec2ssh) fallbackPlan(plan *ConnectionPlan, method string) *ConnectionPlan {
+	fallback := e.planConnection(plan.Instance, method)
+	fallback.Command = plan.Command
+	fallback.Prompt = plan.Prompt
+	fallback.fallback = true
+	return fallback
+}
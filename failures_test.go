@@ -0,0 +1,38 @@
+package ec2ssh
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFallbackPlan(t *testing.T) {
+	e := &Ec2ssh{options: Options{
+		Bastions: []BastionConfig{{Name: "prod", Host: "bastion.example.com", VpcIds: []string{"vpc-prod"}}},
+	}}
+	instance := &Instance{
+		InstanceId:       "i-0123456789abcdef0",
+		PrivateIpAddress: "10.0.0.1",
+		VpcId:            "vpc-prod",
+		Tags:             map[string]string{"ec2ssh:connect": "ssm"},
+	}
+
+	plan := e.PlanConnection(instance)
+	plan.Prompt = "web-1"
+	if plan.Method != MethodSSM {
+		t.Fatalf("Method = %q, want %q", plan.Method, MethodSSM)
+	}
+
+	fallback := e.fallbackPlan(plan, MethodSSH)
+	if fallback.Method != MethodSSH || !fallback.fallback {
+		t.Errorf("Method = %q, fallback = %v, want a %s retry", fallback.Method, fallback.fallback, MethodSSH)
+	}
+	if fallback.Host != "10.0.0.1" {
+		t.Errorf("Host = %q, want the private IP", fallback.Host)
+	}
+	if !slices.Contains(fallback.Options, "ProxyJump=bastion.example.com") {
+		t.Errorf("Options = %q, want the bastion's ProxyJump", fallback.Options)
+	}
+	if fallback.Prompt != plan.Prompt {
+		t.Errorf("Prompt = %q, want %q", fallback.Prompt, plan.Prompt)
+	}
+}
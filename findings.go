@@ -0,0 +1,214 @@
+package ec2ssh
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/guardduty"
+	gdtypes "github.com/aws/aws-sdk-go-v2/service/guardduty/types"
+	"github.com/aws/aws-sdk-go-v2/service/inspector2"
+	inspectortypes "github.com/aws/aws-sdk-go-v2/service/inspector2/types"
+)
+
+const (
+	// guardDutyHighSeverity is the lowest GuardDuty severity rated high
+	guardDutyHighSeverity = 7
+	// guardDutyBatch is the number of findings GetFindings takes at once
+	guardDutyBatch = 50
+	// inspectorBatch is the number of values a filter of Inspector takes
+	inspectorBatch = 10
+)
+
+// findingsMarker is shown before instances with open findings
+const findingsMarker = "⚠ "
+
+// FindingsConfig configures the lookup of open high-severity findings
+type FindingsConfig struct {
+	Enabled bool
+	Sources []string // guardduty and inspector
+}
+
+// Finding is an open high or critical severity finding about an instance
+type Finding struct {
+	Source   string // GuardDuty or Inspector
+	Severity string
+	Title    string
+}
+
+// Findings returns the open high-severity findings of the instance, e.g.
+// {{ range .Findings }}{{ .Title }}{{ end }}. They're only looked up with
+// --findings.
+func (i *Instance) Findings() []Finding {
+	return i.findings
+}
+
+// validateFindings checks the finding sources
+func validateFindings(config FindingsConfig) error {
+	for _, source := range config.Sources {
+		if source != "guardduty" && source != "inspector" {
+			return fmt.Errorf("invalid findings source %q, expected guardduty or inspector", source)
+		}
+	}
+	return nil
+}
+
+// lookupFindings attaches the open high-severity findings of GuardDuty and
+// Inspector to the instances, region by region. Services that aren't enabled
+// or allowed are skipped with a warning.
+func (e *Ec2ssh) lookupFindings(instances []Instance) {
+	byClients := make(map[*awsClients][]string)
+	for _, instance := range instances {
+		if instance.clients != nil {
+			byClients[instance.clients] = append(byClients[instance.clients], instance.InstanceId)
+		}
+	}
+
+	found := make(map[*awsClients]map[string][]Finding)
+	for c, ids := range byClients {
+		found[c] = make(map[string][]Finding)
+		for _, source := range e.options.Findings.Sources {
+			var err error
+			switch source {
+			case "guardduty":
+				err = guardDutyFindings(context.TODO(), c.GuardDuty, found[c])
+			case "inspector":
+				err = inspectorFindings(context.TODO(), c.Inspector, ids, found[c])
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to look up the %s findings in %s: %v\n", source, c.Region, err)
+			}
+		}
+	}
+
+	for i := range instances {
+		findings := found[instances[i].clients][instances[i].InstanceId]
+		sort.SliceStable(findings, func(a, b int) bool {
+			return findings[a].Severity == "CRITICAL" && findings[b].Severity != "CRITICAL"
+		})
+		instances[i].findings = findings
+	}
+}
+
+// guardDutyFindings adds the open findings of severity high and above about
+// instances, listed for the whole region since there are usually few
+func guardDutyFindings(ctx context.Context, client *guardduty.Client, found map[string][]Finding) error {
+	detectors, err := client.ListDetectors(ctx, &guardduty.ListDetectorsInput{})
+	if err != nil {
+		return err
+	}
+
+	for _, detector := range detectors.DetectorIds {
+		var ids []string
+		paginator := guardduty.NewListFindingsPaginator(client, &guardduty.ListFindingsInput{
+			DetectorId: aws.String(detector),
+			FindingCriteria: &gdtypes.FindingCriteria{
+				Criterion: map[string]gdtypes.Condition{
+					"severity":              {GreaterThanOrEqual: aws.Int64(guardDutyHighSeverity)},
+					"service.archived":      {Equals: []string{"false"}},
+					"resource.resourceType": {Equals: []string{"Instance"}},
+				},
+			},
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, page.FindingIds...)
+		}
+
+		for start := 0; start < len(ids); start += guardDutyBatch {
+			output, err := client.GetFindings(ctx, &guardduty.GetFindingsInput{
+				DetectorId: aws.String(detector),
+				FindingIds: ids[start:min(start+guardDutyBatch, len(ids))],
+			})
+			if err != nil {
+				return err
+			}
+			for _, finding := range output.Findings {
+				if finding.Resource == nil || finding.Resource.InstanceDetails == nil {
+					continue
+				}
+				severity := "HIGH"
+				if aws.ToFloat64(finding.Severity) >= 9 {
+					severity = "CRITICAL"
+				}
+				id := aws.ToString(finding.Resource.InstanceDetails.InstanceId)
+				found[id] = append(found[id], Finding{
+					Source:   "GuardDuty",
+					Severity: severity,
+					Title:    aws.ToString(finding.Title),
+				})
+			}
+		}
+	}
+	return nil
+}
+
+// inspectorFindings adds the active high and critical findings of Inspector
+// about the given instances
+func inspectorFindings(ctx context.Context, client *inspector2.Client, ids []string, found map[string][]Finding) error {
+	for start := 0; start < len(ids); start += inspectorBatch {
+		var resources []inspectortypes.StringFilter
+		for _, id := range ids[start:min(start+inspectorBatch, len(ids))] {
+			resources = append(resources, inspectortypes.StringFilter{
+				Comparison: inspectortypes.StringComparisonEquals,
+				Value:      aws.String(id),
+			})
+		}
+
+		paginator := inspector2.NewListFindingsPaginator(client, &inspector2.ListFindingsInput{
+			FilterCriteria: &inspectortypes.FilterCriteria{
+				ResourceId: resources,
+				Severity: []inspectortypes.StringFilter{
+					{Comparison: inspectortypes.StringComparisonEquals, Value: aws.String(string(inspectortypes.SeverityHigh))},
+					{Comparison: inspectortypes.StringComparisonEquals, Value: aws.String(string(inspectortypes.SeverityCritical))},
+				},
+				FindingStatus: []inspectortypes.StringFilter{
+					{Comparison: inspectortypes.StringComparisonEquals, Value: aws.String(string(inspectortypes.FindingStatusActive))},
+				},
+			},
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return err
+			}
+			for _, finding := range page.Findings {
+				for _, resource := range finding.Resources {
+					id := aws.ToString(resource.Id)
+					found[id] = append(found[id], Finding{
+						Source:   "Inspector",
+						Severity: string(finding.Severity),
+						Title:    aws.ToString(finding.Title),
+					})
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// findingsBadge marks the instances with open findings in the list
+func findingsBadge(instance *Instance) string {
+	if len(instance.findings) > 0 {
+		return findingsMarker
+	}
+	return ""
+}
+
+// findingsSummary describes the findings of an instance for the preview
+func findingsSummary(findings []Finding) string {
+	if len(findings) == 0 {
+		return ""
+	}
+	lines := []string{fmt.Sprintf("%s%d open high-severity findings", findingsMarker, len(findings))}
+	for _, finding := range findings {
+		lines = append(lines, fmt.Sprintf("  [%s %s] %s", finding.Source, strings.ToLower(finding.Severity), finding.Title))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
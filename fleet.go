@@ -0,0 +1,347 @@
+package ec2ssh
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultFleetConcurrency is how many instances a fleet command runs on at
+// once by default
+const defaultFleetConcurrency = 10
+
+// FleetConfig configures running a command on the selected instances instead
+// of opening shells on them
+type FleetConfig struct {
+	Command     string // template rendered for each instance, e.g. "grep {{ .Tags.Service }} /var/log/syslog"
+	Concurrency int    // instances the command runs on at once
+	OutputDir   string // directory the output of each instance and a summary are written to
+	Canary      int    // instances the command runs on first, the others needing a confirmation
+	MaxFailures int    // failures after which the command isn't started anywhere else, -1 never stops
+}
+
+// FleetSummary records a fleet command run, written as summary.json with
+// --output-dir, e.g. as change-record evidence
+type FleetSummary struct {
+	Command   string        `json:"command"`
+	Started   time.Time     `json:"started"`
+	Finished  time.Time     `json:"finished"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Skipped   int           `json:"skipped"`
+	Results   []FleetResult `json:"results"`
+}
+
+// FleetResult is the outcome of a fleet command on one instance
+type FleetResult struct {
+	InstanceId string    `json:"instance_id"`
+	Name       string    `json:"name,omitempty"`
+	Profile    string    `json:"profile,omitempty"`
+	Region     string    `json:"region"`
+	Method     string    `json:"method"`
+	Command    string    `json:"command"`
+	Started    time.Time `json:"started"`
+	Finished   time.Time `json:"finished"`
+	ExitCode   *int      `json:"exit_code,omitempty"` // unknown for SSM instances
+	Error      string    `json:"error,omitempty"`
+	Log        string    `json:"log,omitempty"`
+	Skipped    bool      `json:"skipped,omitempty"` // not run, after the canary or too many failures
+}
+
+// fleetCommands renders the fleet command for each plan, so a command
+// varying by tag, availability zone or instance id is checked on every
+// instance before it runs anywhere
+func (e *Ec2ssh) fleetCommands(plans []*ConnectionPlan) ([]string, error) {
+	commands := make([]string, len(plans))
+	for i, plan := range plans {
+		command, err := TemplateForInstance(plan.Instance, e.fleetCommand)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render --command for %s: %w", plan.Instance.InstanceId, err)
+		}
+		if strings.TrimSpace(command) == "" {
+			return nil, fmt.Errorf("--command renders to nothing for %s", plan.Instance.InstanceId)
+		}
+		commands[i] = command
+	}
+	return commands, nil
+}
+
+// runFleet runs the fleet command on the planned instances, a few at a
+// time, and merges their output prefixed with each instance's name, or
+// writes it to a file per instance with --output-dir. With --canary, it
+// runs on the first instances alone, and on the others once it succeeded
+// there and the user confirmed. It's started on no more instances once it
+// failed on more than --max-failures. It fails when the command failed on
+// any instance or was skipped on some.
+func (e *Ec2ssh) runFleet(plans []*ConnectionPlan) error {
+	commands, err := e.fleetCommands(plans)
+	if err != nil {
+		return err
+	}
+	if dir := e.options.Fleet.OutputDir; dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	labels := planLabels(plans)
+	concurrency := e.options.Fleet.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultFleetConcurrency
+	}
+
+	summary := FleetSummary{
+		Command: e.options.Fleet.Command,
+		Started: time.Now(),
+		Results: make([]FleetResult, len(plans)),
+	}
+	// ssh doesn't prompt for security key touches with its output piped,
+	// connections then wait for them one at a time so each touch goes to
+	// the instance announced
+	touches := noticeSecurityKeys(plans)
+	if touches != nil && concurrency > 1 {
+		concurrency = 1
+		fmt.Fprintln(os.Stderr, "Running the command on one instance at a time")
+	}
+
+	var started, running, succeeded, failures atomic.Int64
+	run := func(from, to int) {
+		status := startProgress(func() string {
+			return fmt.Sprintf("%d running, %d succeeded, %d failed, %d waiting",
+				running.Load(), succeeded.Load(), failures.Load(), int64(len(plans))-started.Load())
+		})
+		defer status.Stop()
+
+		slots := make(chan struct{}, concurrency)
+		wg := &sync.WaitGroup{}
+		for i := from; i < to; i++ {
+			slots <- struct{}{}
+			if limit := e.options.Fleet.MaxFailures; limit >= 0 && failures.Load() > int64(limit) {
+				<-slots
+				break
+			}
+			started.Add(1)
+			running.Add(1)
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				summary.Results[i] = e.runFleetInstance(plans[i], commands[i], labels[i], touches != nil && touches[i], status)
+				running.Add(-1)
+				if summary.Results[i].Error != "" {
+					failures.Add(1)
+				} else {
+					succeeded.Add(1)
+				}
+			}(i)
+		}
+		wg.Wait()
+	}
+
+	canary := e.options.Fleet.Canary
+	if canary > 0 && canary < len(plans) {
+		run(0, canary)
+		switch {
+		case failures.Load() > 0:
+			fmt.Fprintf(os.Stderr, "Command failed on the canary, not running it on the other %d instances\n", len(plans)-canary)
+		case confirm(fmt.Sprintf("Command succeeded on the %d canary instances, continue with the other %d?", canary, len(plans)-canary)):
+			run(canary, len(plans))
+		}
+	} else {
+		run(0, len(plans))
+	}
+	summary.Finished = time.Now()
+
+	var failed []string
+	for i, result := range summary.Results {
+		if result.Started.IsZero() {
+			summary.Results[i] = FleetResult{
+				InstanceId: plans[i].Instance.InstanceId,
+				Name:       plans[i].Instance.Tags["Name"],
+				Profile:    plans[i].Instance.Profile,
+				Region:     plans[i].Instance.Region,
+				Method:     plans[i].Method,
+				Command:    commands[i],
+				Skipped:    true,
+			}
+			summary.Skipped++
+		} else if result.Error != "" {
+			failed = append(failed, fmt.Sprintf("%s (%s)", strings.TrimSpace(labels[i]), result.Error))
+		}
+	}
+	summary.Failed = len(failed)
+	summary.Succeeded = len(plans) - len(failed) - summary.Skipped
+	if dir := e.options.Fleet.OutputDir; dir != "" {
+		if err := writeFleetSummary(filepath.Join(dir, "summary.json"), summary); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Command succeeded on %d of %d instances\n", summary.Succeeded, len(plans))
+	if e.options.Fleet.MaxFailures >= 0 && len(failed) > e.options.Fleet.MaxFailures && summary.Skipped > 0 {
+		fmt.Fprintf(os.Stderr, "Stopped after %d failures (--max-failures %d)\n", len(failed), e.options.Fleet.MaxFailures)
+	}
+	switch {
+	case len(failed) > 0:
+		return fmt.Errorf("command failed on %s", strings.Join(failed, ", "))
+	case summary.Skipped > 0:
+		return fmt.Errorf("command skipped on %d instances", summary.Skipped)
+	}
+	return nil
+}
+
+// runFleetInstance runs the fleet command on one instance. Its output is
+// printed prefixed with the instance's label above the status line, or
+// written to its log file with --output-dir, only its outcome being printed
+// then. touch announces the security key touch its connection waits for.
+func (e *Ec2ssh) runFleetInstance(plan *ConnectionPlan, command, label string, touch bool, status *progress) FleetResult {
+	instance := plan.Instance
+	result := FleetResult{
+		InstanceId: instance.InstanceId,
+		Name:       instance.Tags["Name"],
+		Profile:    instance.Profile,
+		Region:     instance.Region,
+		Method:     plan.Method,
+		Command:    command,
+		Started:    time.Now(),
+	}
+	prefix := "[" + label + "] "
+	print := func(line string) {
+		status.Println(prefix + line)
+	}
+	if touch {
+		print("touch your security key if it blinks")
+	}
+
+	var err error
+	if dir := e.options.Fleet.OutputDir; dir != "" {
+		result.Log = filepath.Join(dir, fleetLogName(instance))
+		var file *os.File
+		file, err = os.Create(result.Log)
+		if err == nil {
+			err = e.runFleetCommand(plan, command, func(line string) {
+				fmt.Fprintln(file, line)
+			})
+			file.Close()
+		}
+	} else {
+		err = e.runFleetCommand(plan, command, print)
+	}
+	result.Finished = time.Now()
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		code := 0
+		result.ExitCode = &code
+	case errors.As(err, &exitErr):
+		code := exitErr.ExitCode()
+		result.ExitCode = &code
+		result.Error = err.Error()
+	default:
+		result.Error = err.Error()
+	}
+
+	if result.Log != "" {
+		if result.Error != "" {
+			print("failed: " + result.Error + ", see " + result.Log)
+		} else {
+			print("done, see " + result.Log)
+		}
+	}
+	return result
+}
+
+// unsafeFileChars are the characters of instance names replaced in log file
+// names
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// fleetLogName returns the name of the file the output of an instance is
+// written to, <name>-<id>.log or <id>.log without a Name tag
+func fleetLogName(instance *Instance) string {
+	if name := unsafeFileChars.ReplaceAllString(instance.Tags["Name"], "_"); name != "" {
+		return name + "-" + instance.InstanceId + ".log"
+	}
+	return instance.InstanceId + ".log"
+}
+
+// writeFleetSummary writes the summary of a fleet command run as JSON
+func writeFleetSummary(path string, summary FleetSummary) error {
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// runFleetCommand runs a command on one instance, passing each line of its
+// output to print. SSM instances run it through Run Command, which reports
+// its exit status, and their output is printed once it's done.
+func (e *Ec2ssh) runFleetCommand(plan *ConnectionPlan, command string, print func(string)) error {
+	recordConnection(plan.Method)
+	if plan.Method == MethodSSM {
+		output, err := runShellScript(context.TODO(), plan.Instance, []string{withEnv(plan.Env, command)})
+		printLines(strings.NewReader(output), print)
+		return err
+	}
+
+	args := e.remoteArgs(plan, command)
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = writer
+	cmd.Stderr = writer
+	stopWatching := watchProcess(cmd, e.options.Session, false)
+	defer stopWatching()
+	if err := cmd.Start(); err != nil {
+		writer.Close()
+		reader.Close()
+		return err
+	}
+	writer.Close()
+	printLines(reader, print)
+	reader.Close()
+	return cmd.Wait()
+}
+
+// printLines passes each line read to print, without trailing carriage
+// returns
+func printLines(reader io.Reader, print func(string)) {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		print(strings.TrimRight(scanner.Text(), "\r"))
+	}
+}
+
+// planLabels returns the names of the planned instances, or their ids when
+// they have none, padded to the same width to prefix their output
+func planLabels(plans []*ConnectionPlan) []string {
+	labels := make([]string, len(plans))
+	width := 0
+	for i, plan := range plans {
+		labels[i] = plan.Instance.InstanceId
+		if name := plan.Instance.Tags["Name"]; name != "" {
+			labels[i] = name
+		}
+		if len(labels[i]) > width {
+			width = len(labels[i])
+		}
+	}
+	for i := range labels {
+		labels[i] = fmt.Sprintf("%-*s", width, labels[i])
+	}
+	return labels
+}
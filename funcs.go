@@ -0,0 +1,83 @@
+package ec2ssh
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Masterminds/sprig"
+)
+
+type ShellConfig struct {
+	Enabled bool          `mapstructure:"enabled"`
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// validateShell checks the shell function timeout, which bounds every call
+func validateShell(config ShellConfig) error {
+	if config.Timeout <= 0 {
+		return fmt.Errorf("invalid shell.timeout %s, it must be positive", config.Timeout)
+	}
+	return nil
+}
+
+// templateFuncs returns the functions available to list and preview templates
+func templateFuncs(options Options, accounts *AccountAliases) template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	funcs["accountAlias"] = accounts.Lookup
+	funcs["shell"] = shellFunc(options.Shell)
+	funcs["age"] = age
+	funcs["revision"] = revisionFunc(options.Revision)
+	funcs["plugin"] = pluginFunc(options.Plugins)
+	funcs["rightsizing"] = rightSizingFunc(options.RightSizing)
+	return funcs
+}
+
+// age returns how long ago a time was in its largest unit, e.g. 3d or 5h
+func age(t time.Time) string {
+	if t.IsZero() {
+		return "?"
+	}
+	d := time.Since(t)
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+}
+
+// shellFunc returns the "shell" template function, which runs a local command
+// with the given arguments appended and returns its trimmed output, e.g.
+// {{ shell "dig +short -x" .PrivateIpAddress }}. It has to be explicitly
+// enabled in the config since templates may come from shared config files.
+func shellFunc(config ShellConfig) func(command string, args ...string) (string, error) {
+	return func(command string, args ...string) (string, error) {
+		if !config.Enabled {
+			return "", fmt.Errorf("the shell template function is disabled, set shell.enabled = true to use it")
+		}
+
+		fields := strings.Fields(command)
+		if len(fields) == 0 {
+			return "", fmt.Errorf("shell: empty command")
+		}
+		fields = append(fields, args...)
+
+		ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+		defer cancel()
+
+		output, err := exec.CommandContext(ctx, fields[0], fields[1:]...).Output()
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", fmt.Errorf("shell: %q timed out after %s", command, config.Timeout)
+		}
+		if err != nil {
+			return "", fmt.Errorf("shell: %q failed: %w", command, err)
+		}
+		return strings.TrimSpace(string(output)), nil
+	}
+}
@@ -8,8 +8,26 @@ require (
 	github.com/Masterminds/sprig v2.22.0+incompatible
 	github.com/aws/aws-sdk-go-v2 v1.37.0
 	github.com/aws/aws-sdk-go-v2/config v1.29.17
+	github.com/aws/aws-sdk-go-v2/credentials v1.17.70
+	github.com/aws/aws-sdk-go-v2/service/autoscaling v1.55.0
+	github.com/aws/aws-sdk-go-v2/service/cloudtrail v1.50.0
+	github.com/aws/aws-sdk-go-v2/service/cloudwatch v1.46.0
+	github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs v1.54.0
+	github.com/aws/aws-sdk-go-v2/service/codedeploy v1.30.0
 	github.com/aws/aws-sdk-go-v2/service/ec2 v1.232.0
+	github.com/aws/aws-sdk-go-v2/service/ec2instanceconnect v1.29.0
+	github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2 v1.47.0
+	github.com/aws/aws-sdk-go-v2/service/guardduty v1.58.0
+	github.com/aws/aws-sdk-go-v2/service/iam v1.44.0
+	github.com/aws/aws-sdk-go-v2/service/inspector2 v1.38.2
+	github.com/aws/aws-sdk-go-v2/service/organizations v1.40.0
+	github.com/aws/aws-sdk-go-v2/service/resourceexplorer2 v1.18.0
+	github.com/aws/aws-sdk-go-v2/service/resourcegroups v1.30.0
+	github.com/aws/aws-sdk-go-v2/service/ssm v1.61.0
+	github.com/aws/aws-sdk-go-v2/service/sts v1.34.0
+	github.com/aws/smithy-go v1.22.5
 	github.com/ktr0731/go-fuzzyfinder v0.2.1
+	github.com/mattn/go-runewidth v0.0.9
 	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.7.0
 )
@@ -17,25 +35,21 @@ require (
 require (
 	github.com/Masterminds/goutils v1.1.0 // indirect
 	github.com/Masterminds/semver v1.5.0 // indirect
-	github.com/aws/aws-sdk-go-v2/credentials v1.17.70 // indirect
+	github.com/aws/aws-sdk-go-v2/aws/protocol/eventstream v1.7.0 // indirect
 	github.com/aws/aws-sdk-go-v2/feature/ec2/imds v1.16.32 // indirect
 	github.com/aws/aws-sdk-go-v2/internal/configsources v1.4.0 // indirect
 	github.com/aws/aws-sdk-go-v2/internal/endpoints/v2 v2.7.0 // indirect
 	github.com/aws/aws-sdk-go-v2/internal/ini v1.8.3 // indirect
 	github.com/aws/aws-sdk-go-v2/service/internal/accept-encoding v1.12.4 // indirect
 	github.com/aws/aws-sdk-go-v2/service/internal/presigned-url v1.12.17 // indirect
-	github.com/aws/aws-sdk-go-v2/service/ssm v1.61.0 // indirect
 	github.com/aws/aws-sdk-go-v2/service/sso v1.25.5 // indirect
 	github.com/aws/aws-sdk-go-v2/service/ssooidc v1.30.3 // indirect
-	github.com/aws/aws-sdk-go-v2/service/sts v1.34.0 // indirect
-	github.com/aws/smithy-go v1.22.5 // indirect
 	github.com/fsnotify/fsnotify v1.4.7 // indirect
 	github.com/google/uuid v1.1.1 // indirect
 	github.com/hashicorp/hcl v1.0.0 // indirect
 	github.com/huandu/xstrings v1.3.2 // indirect
 	github.com/imdario/mergo v0.3.9 // indirect
 	github.com/magiconair/properties v1.8.1 // indirect
-	github.com/mattn/go-runewidth v0.0.9 // indirect
 	github.com/mitchellh/copystructure v1.0.0 // indirect
 	github.com/mitchellh/mapstructure v1.1.2 // indirect
 	github.com/mitchellh/reflectwalk v1.0.0 // indirect
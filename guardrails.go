@@ -0,0 +1,144 @@
+package ec2ssh
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Guardrail actions
+const (
+	GuardrailHide    = "hide"
+	GuardrailConfirm = "confirm"
+)
+
+// GuardrailConfig protects sensitive instances, matched by tag or by Name
+// tag, from being connected to by mistake
+type GuardrailConfig struct {
+	Tag    string `mapstructure:"tag"`    // Key=Value or Key, the value can be a glob
+	Name   string `mapstructure:"name"`   // glob matched against the Name tag
+	Action string `mapstructure:"action"` // hide or confirm (default)
+	Reason string `mapstructure:"reason"` // shown when asking for confirmation
+}
+
+// matches reports whether the guardrail applies to an instance
+func (g GuardrailConfig) matches(instance *Instance) bool {
+	if g.Tag == "" && g.Name == "" {
+		return false
+	}
+	if g.Tag != "" {
+		key, pattern, hasValue := strings.Cut(g.Tag, "=")
+		value, ok := instance.Tags[key]
+		if !ok {
+			return false
+		}
+		if hasValue {
+			if matched, _ := path.Match(pattern, value); !matched {
+				return false
+			}
+		}
+	}
+	if g.Name != "" {
+		if matched, _ := path.Match(g.Name, instance.Tags["Name"]); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// guardrail returns the first guardrail applying to an instance, if any
+func (e *Ec2ssh) guardrail(instance *Instance) *GuardrailConfig {
+	for i := range e.options.Guardrails {
+		if e.options.Guardrails[i].matches(instance) {
+			return &e.options.Guardrails[i]
+		}
+	}
+	return nil
+}
+
+// hideGuarded removes the instances hidden by a guardrail from the list
+func (e *Ec2ssh) hideGuarded(instances []Instance) []Instance {
+	if len(e.options.Guardrails) == 0 {
+		return instances
+	}
+	visible := make([]Instance, 0, len(instances))
+	for i := range instances {
+		if g := e.guardrail(&instances[i]); g != nil && g.Action == GuardrailHide {
+			continue
+		}
+		visible = append(visible, instances[i])
+	}
+	return visible
+}
+
+// guardedActions describes the instance commands guardrails apply to, in
+// "<action> it" questions and errors
+var guardedActions = map[string]string{
+	"push-file":       "push files to",
+	"pin":             "pin",
+	"stop":            "stop",
+	"terminate":       "terminate",
+	"console-output":  "read the console output of",
+	"serial":          "connect to the serial console of",
+	"screenshot":      "screenshot",
+	"cloudwatch-logs": "tail the logs of",
+}
+
+// checkGuardrail refuses to act on a hidden instance, and asks for
+// confirmation before acting on a guarded one, e.g. "connect to" it. A
+// confirmed instance isn't asked about again, and can then be planned.
+func (e *Ec2ssh) checkGuardrail(instance *Instance, action string) error {
+	g := e.guardrail(instance)
+	if g == nil || e.guardrailConfirmed[instance.TargetID()] {
+		return nil
+	}
+	if g.Action == GuardrailHide {
+		return fmt.Errorf("%s is protected by a guardrail, refusing to %s it", instance.InstanceId, action)
+	}
+
+	question := fmt.Sprintf("%s (%s) is a sensitive instance", instance.InstanceId, instance.Tags["Name"])
+	if g.Reason != "" {
+		question += ": " + g.Reason
+	}
+	if !confirm(fmt.Sprintf("%s. %s it anyway?", question, strings.ToUpper(action[:1])+action[1:])) {
+		return fmt.Errorf("not going to %s %s", action, instance.InstanceId)
+	}
+	if e.guardrailConfirmed == nil {
+		e.guardrailConfirmed = make(map[string]bool)
+	}
+	e.guardrailConfirmed[instance.TargetID()] = true
+	return nil
+}
+
+// guardSelection returns the selected instances an instance command may act
+// on, after their guardrails are checked
+func (e *Ec2ssh) guardSelection(instances []*Instance, command string) []*Instance {
+	action, ok := guardedActions[command]
+	if !ok || len(e.options.Guardrails) == 0 {
+		return instances
+	}
+	allowed := make([]*Instance, 0, len(instances))
+	for _, instance := range instances {
+		if err := e.checkGuardrail(instance, action); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			continue
+		}
+		allowed = append(allowed, instance)
+	}
+	return allowed
+}
+
+// validateGuardrails checks the guardrail actions are known
+func validateGuardrails(guardrails []GuardrailConfig) error {
+	for _, g := range guardrails {
+		switch g.Action {
+		case "", GuardrailHide, GuardrailConfirm:
+		default:
+			return fmt.Errorf("invalid guardrail action %q, valid actions are: %s, %s", g.Action, GuardrailHide, GuardrailConfirm)
+		}
+		if g.Tag == "" && g.Name == "" {
+			return fmt.Errorf("guardrails need a tag or a name to match")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package ec2ssh
+
+import "testing"
+
+func TestGuardrailMatches(t *testing.T) {
+	tags := map[string]string{"Name": "prod-db-1", "env": "production", "team": ""}
+
+	tests := []struct {
+		name      string
+		guardrail GuardrailConfig
+		want      bool
+	}{
+		{"no rule", GuardrailConfig{}, false},
+		{"tag key", GuardrailConfig{Tag: "env"}, true},
+		{"tag key missing", GuardrailConfig{Tag: "pci"}, false},
+		{"tag key with empty value", GuardrailConfig{Tag: "team"}, true},
+		{"tag value", GuardrailConfig{Tag: "env=production"}, true},
+		{"tag value mismatch", GuardrailConfig{Tag: "env=staging"}, false},
+		{"tag value glob", GuardrailConfig{Tag: "env=prod*"}, true},
+		{"name glob", GuardrailConfig{Name: "prod-db-*"}, true},
+		{"name glob mismatch", GuardrailConfig{Name: "prod-web-*"}, false},
+		{"tag and name", GuardrailConfig{Tag: "env=production", Name: "prod-db-*"}, true},
+		{"tag but not name", GuardrailConfig{Tag: "env=production", Name: "prod-web-*"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.guardrail.matches(&Instance{Tags: tags}); got != test.want {
+				t.Errorf("matches() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package ec2ssh
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// offendingKeyPattern finds the known_hosts file holding the key ssh
+// rejected, e.g. "Offending ECDSA key in /home/me/.ssh/known_hosts:12"
+var offendingKeyPattern = regexp.MustCompile(`Offending \S+ key in (.+):(\d+)`)
+
+// foundHostPattern finds the line numbers ssh-keygen -F prints, e.g.
+// "# Host 10.0.0.1 found: line 12"
+var foundHostPattern = regexp.MustCompile(`(?m)^# Host .* found: line (\d+)`)
+
+// recycledWithin is how recently an instance must have been launched for a
+// changed host key to be put down to its address being recycled
+const recycledWithin = 30 * 24 * time.Hour
+
+// offerHostKeyRemoval handles ssh's REMOTE HOST IDENTIFICATION HAS CHANGED
+// error. When the rejected known_hosts entry is the one of the instance's
+// host and the instance was launched recently, its address most likely
+// belonged to a previous instance, and the user is offered to remove the
+// stale entry and reconnect. Otherwise the reason the change is left to the
+// user is printed.
+func (e *Ec2ssh) offerHostKeyRemoval(plan *ConnectionPlan, stderr string) {
+	if plan.Method != MethodSSH || plan.fallback || !strings.Contains(stderr, "REMOTE HOST IDENTIFICATION HAS CHANGED") {
+		return
+	}
+	match := offendingKeyPattern.FindStringSubmatch(stderr)
+	if match == nil {
+		return
+	}
+	knownHosts, line := strings.TrimSpace(match[1]), match[2]
+
+	host := plan.Host
+	if plan.Port != "" && plan.Port != "22" {
+		host = "[" + plan.Host + "]:" + plan.Port
+	}
+	if !knownHostsLines(knownHosts, host)[line] {
+		fmt.Printf("The rejected key (%s:%s) isn't the entry of %s, e.g. it's a bastion's: left alone.\n", knownHosts, line, host)
+		return
+	}
+	launched := plan.Instance.LaunchTime
+	if launched.IsZero() || time.Since(launched) > recycledWithin {
+		fmt.Printf("%s wasn't launched recently, so a new instance doesn't explain the changed key: left alone, check with the instance's owner.\n", plan.Instance.InstanceId)
+		return
+	}
+
+	fmt.Printf("%s was launched %s: its address was likely recycled from a previous instance.\n",
+		plan.Instance.InstanceId, launched.Local().Format("2006-01-02 15:04"))
+	if !confirm(fmt.Sprintf("Remove the stale entry (%s:%s) and reconnect?", knownHosts, line)) {
+		return
+	}
+
+	cmd := exec.Command("ssh-keygen", "-R", host, "-f", knownHosts)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Printf("Failed to remove the known_hosts entry of %s: %v\n", host, err)
+		return
+	}
+
+	retry := *plan
+	retry.fallback = true
+	e.connectToInstance(&retry)
+	e.exit(0)
+}
+
+// knownHostsLines returns the line numbers of the entries of a host in a
+// known_hosts file, hashed or not, as found by ssh-keygen -F
+func knownHostsLines(knownHosts, host string) map[string]bool {
+	lines := map[string]bool{}
+	output, err := exec.Command("ssh-keygen", "-F", host, "-f", knownHosts).Output()
+	if err != nil {
+		return lines
+	}
+	for _, match := range foundHostPattern.FindAllStringSubmatch(string(output), -1) {
+		lines[match[1]] = true
+	}
+	return lines
+}
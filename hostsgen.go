@@ -0,0 +1,136 @@
+package ec2ssh
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Formats of hosts-gen
+const (
+	HostsFormatHosts   = "hosts"
+	HostsFormatDnsmasq = "dnsmasq"
+)
+
+// HostsConfig configures the name to address mappings of hosts-gen
+type HostsConfig struct {
+	Format string // hosts or dnsmasq
+	File   string // file whose managed block is updated, printed when empty
+	Tag    string // tag naming the instances
+	Domain string // suffix appended to the names, e.g. ec2.internal
+}
+
+// invalidHostnameChars are replaced when turning tag values into host names
+var invalidHostnameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// hostName turns a tag value into a host name
+func hostName(value, domain string) string {
+	name := strings.Trim(invalidHostnameChars.ReplaceAllString(strings.ToLower(value), "-"), "-.")
+	if name == "" {
+		return ""
+	}
+	if domain != "" {
+		name += "." + strings.Trim(domain, ".")
+	}
+	return name
+}
+
+// hostsAddress returns the IP address a host name maps to: the private IP
+// with --use-private-ip, the public one otherwise when the instance has one.
+// Unlike sshHost it never returns a DNS name, which the hosts file and
+// dnsmasq host records don't accept.
+func (e *Ec2ssh) hostsAddress(instance *Instance) string {
+	if !e.options.UsePrivateIp && instance.PublicIpAddress != "" {
+		return instance.PublicIpAddress
+	}
+	return instance.PrivateIpAddress
+}
+
+// generateHosts renders the name to address mappings of the instances in a
+// managed block, printed or written in place of the previous block of the
+// same profiles in the configured file
+func (e *Ec2ssh) generateHosts(instances []Instance) error {
+	config := e.options.Hosts
+	marker := "ec2-ssh"
+	if len(e.options.Profiles) > 0 {
+		marker += " " + strings.Join(e.options.Profiles, ",")
+	}
+
+	var block strings.Builder
+	fmt.Fprintf(&block, "# BEGIN %s\n", marker)
+	seen := make(map[string]string)
+	for i := range instances {
+		instance := &instances[i]
+		name := hostName(instance.Tags[config.Tag], config.Domain)
+		address := e.hostsAddress(instance)
+		if name == "" || address == "" {
+			continue
+		}
+		if id, ok := seen[name]; ok {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s, %s is already named %s\n", instance.InstanceId, id, name)
+			continue
+		}
+		seen[name] = instance.InstanceId
+
+		switch config.Format {
+		case HostsFormatDnsmasq:
+			fmt.Fprintf(&block, "host-record=%s,%s\n", name, address)
+		default:
+			fmt.Fprintf(&block, "%s\t%s\n", address, name)
+		}
+	}
+	fmt.Fprintf(&block, "# END %s\n", marker)
+
+	if config.File == "" {
+		fmt.Print(block.String())
+		return nil
+	}
+
+	current, err := os.ReadFile(config.File)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(config.File); err == nil {
+		mode = info.Mode().Perm()
+	}
+	updated := replaceManagedBlock(current, marker, block.String())
+	if bytes.Equal(updated, current) {
+		fmt.Printf("%s is up to date\n", config.File)
+		return nil
+	}
+	// Written in place rather than renamed, /etc/hosts is often a mount
+	if err := os.WriteFile(config.File, updated, mode); err != nil {
+		return err
+	}
+	fmt.Printf("Updated %d hosts in %s\n", len(seen), config.File)
+	return nil
+}
+
+// replaceManagedBlock replaces the block between the markers in a file, or
+// appends it when the file doesn't have one yet
+func replaceManagedBlock(content []byte, marker, block string) []byte {
+	text := string(content)
+	begin := strings.Index(text, "# BEGIN "+marker+"\n")
+	endMarker := "# END " + marker + "\n"
+	if begin >= 0 {
+		if end := strings.Index(text[begin:], endMarker); end >= 0 {
+			return []byte(text[:begin] + block + text[begin+end+len(endMarker):])
+		}
+	}
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return []byte(text + block)
+}
+
+// validateHostsFormat checks the hosts-gen format is known
+func validateHostsFormat(format string) error {
+	switch format {
+	case HostsFormatHosts, HostsFormatDnsmasq:
+		return nil
+	}
+	return fmt.Errorf("invalid hosts format %q, valid formats are: %s, %s", format, HostsFormatHosts, HostsFormatDnsmasq)
+}
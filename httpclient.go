@@ -0,0 +1,88 @@
+package ec2ssh
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+)
+
+type HTTPConfig struct {
+	Proxy    string `mapstructure:"proxy"`
+	NoProxy  string `mapstructure:"no_proxy"`
+	CABundle string `mapstructure:"ca_bundle"`
+}
+
+// applyProxyEnvironment exports the configured proxy settings so both the SDK
+// HTTP client, which honors HTTPS_PROXY/NO_PROXY, and the aws/ssh child
+// processes use them. It must run before any request is made since the Go
+// standard library reads these variables once.
+func applyProxyEnvironment(config HTTPConfig) error {
+	if config.Proxy != "" {
+		if _, err := url.Parse(config.Proxy); err != nil {
+			return fmt.Errorf("invalid http.proxy %q: %w", config.Proxy, err)
+		}
+		os.Setenv("HTTPS_PROXY", config.Proxy)
+		os.Setenv("HTTP_PROXY", config.Proxy)
+	}
+	if config.NoProxy != "" {
+		os.Setenv("NO_PROXY", config.NoProxy)
+	}
+	if config.CABundle != "" {
+		// Picked up by the aws CLI used for SSM sessions
+		os.Setenv("AWS_CA_BUNDLE", config.CABundle)
+	}
+	return nil
+}
+
+// newHTTPClient returns the HTTP client used by the AWS SDK, trusting the
+// configured CA bundle on top of the system roots, e.g. for corporate
+// TLS-intercepting proxies. It returns nil when the SDK default is fine.
+func newHTTPClient(config HTTPConfig) (*awshttp.BuildableClient, error) {
+	if config.CABundle == "" {
+		return nil, nil
+	}
+
+	pem, err := os.ReadFile(config.CABundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", config.CABundle)
+	}
+
+	return awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+		if tr.TLSClientConfig == nil {
+			tr.TLSClientConfig = &tls.Config{}
+		}
+		tr.TLSClientConfig.RootCAs = pool
+	}), nil
+}
+
+// httpDoer sends HTTP requests, like *http.Client
+type httpDoer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// sharedHTTPClient returns the client for the requests the tool makes
+// itself, such as the update check, which like the SDK go through the
+// configured proxy and trust the configured CA bundle
+func sharedHTTPClient(config HTTPConfig) (httpDoer, error) {
+	client, err := newHTTPClient(config)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return http.DefaultClient, nil
+	}
+	return client, nil
+}
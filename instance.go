@@ -0,0 +1,213 @@
+package ec2ssh
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Instance is the compact form of an EC2 instance kept for every listed
+// instance: only the fields needed to render the list and connect are
+// retained, which keeps memory usage low on very large fleets. The full
+// DescribeInstances output is only fetched on demand through Detail.
+//
+// Field names follow types.Instance so templates written against the SDK
+// struct (e.g. {{.PrivateIpAddress}} or {{.State.Name}}) keep working.
+type Instance struct {
+	InstanceId       string
+	InstanceType     string
+	ImageId          string
+	ImageName        string
+	State            InstanceState
+	PrivateIpAddress string
+	PublicIpAddress  string
+	PublicDnsName    string
+	Placement        Placement
+	LaunchTime       time.Time
+	Platform         string
+	PlatformDetails  string
+	VpcId            string
+	SubnetId         string
+	KeyName          string
+	Tags             map[string]string
+
+	NetworkInterfaces []NetworkInterface
+
+	OwnerId string
+	Profile string
+	Region  string
+
+	// TargetHealth is the health of the instance in the target groups it
+	// was listed through, if any
+	TargetHealth string
+
+	clients    *awsClients
+	detail     *instanceDetail
+	compliance *Compliance
+	findings   []Finding
+}
+
+// instanceDetail holds the lazily fetched full instance description and
+// status checks, behind a pointer so copies of an Instance share them
+type instanceDetail struct {
+	once     sync.Once
+	instance *types.Instance
+	err      error
+
+	statusOnce sync.Once
+	status     *types.InstanceStatus
+	statusErr  error
+
+	// The protection and maintenance shown in the preview, fetched once
+	protectionOnce  sync.Once
+	protection      *Protection
+	maintenanceOnce sync.Once
+	maintenance     *Maintenance
+}
+
+// NetworkInterface is the compact form of an ENI attached to an instance
+type NetworkInterface struct {
+	NetworkInterfaceId string
+	DeviceIndex        int32
+	SubnetId           string
+	Description        string
+	PrivateIpAddresses []string // primary address first
+	PublicIpAddress    string
+}
+
+type InstanceState struct {
+	Name string
+}
+
+type Placement struct {
+	AvailabilityZone string
+}
+
+// newInstance converts an SDK instance to its compact form
+func newInstance(i *types.Instance, ownerId, region string) Instance {
+	tags := make(map[string]string, len(i.Tags))
+	for _, tag := range i.Tags {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+
+	instance := Instance{
+		InstanceId:       aws.ToString(i.InstanceId),
+		InstanceType:     string(i.InstanceType),
+		ImageId:          aws.ToString(i.ImageId),
+		PrivateIpAddress: aws.ToString(i.PrivateIpAddress),
+		PublicIpAddress:  aws.ToString(i.PublicIpAddress),
+		PublicDnsName:    aws.ToString(i.PublicDnsName),
+		LaunchTime:       aws.ToTime(i.LaunchTime),
+		Platform:         string(i.Platform),
+		PlatformDetails:  aws.ToString(i.PlatformDetails),
+		VpcId:            aws.ToString(i.VpcId),
+		SubnetId:         aws.ToString(i.SubnetId),
+		KeyName:          aws.ToString(i.KeyName),
+		Tags:             tags,
+		OwnerId:          ownerId,
+		Region:           region,
+		detail:           &instanceDetail{},
+	}
+	if i.State != nil {
+		instance.State.Name = string(i.State.Name)
+	}
+	for _, eni := range i.NetworkInterfaces {
+		instance.NetworkInterfaces = append(instance.NetworkInterfaces, newNetworkInterface(eni))
+	}
+	if i.Placement != nil {
+		instance.Placement.AvailabilityZone = aws.ToString(i.Placement.AvailabilityZone)
+	}
+	return instance
+}
+
+// newNetworkInterface converts an SDK network interface to its compact form
+func newNetworkInterface(eni types.InstanceNetworkInterface) NetworkInterface {
+	ni := NetworkInterface{
+		NetworkInterfaceId: aws.ToString(eni.NetworkInterfaceId),
+		SubnetId:           aws.ToString(eni.SubnetId),
+		Description:        aws.ToString(eni.Description),
+	}
+	if eni.Attachment != nil {
+		ni.DeviceIndex = aws.ToInt32(eni.Attachment.DeviceIndex)
+	}
+	if eni.Association != nil {
+		ni.PublicIpAddress = aws.ToString(eni.Association.PublicIp)
+	}
+	for _, address := range eni.PrivateIpAddresses {
+		if aws.ToBool(address.Primary) {
+			ni.PrivateIpAddresses = append([]string{aws.ToString(address.PrivateIpAddress)}, ni.PrivateIpAddresses...)
+		} else {
+			ni.PrivateIpAddresses = append(ni.PrivateIpAddresses, aws.ToString(address.PrivateIpAddress))
+		}
+	}
+	return ni
+}
+
+// TargetID identifies the instance as account/region/instance-id, unique
+// across the accounts and regions listed where instance ids alone can
+// collide
+func (i *Instance) TargetID() string {
+	return i.OwnerId + "/" + i.Region + "/" + i.InstanceId
+}
+
+// instanceIdOf returns the instance id of a target id, or the id itself
+func instanceIdOf(target string) string {
+	if slash := strings.LastIndex(target, "/"); slash >= 0 {
+		return target[slash+1:]
+	}
+	return target
+}
+
+// PrivateIpAddresses returns every private address of the instance, the
+// primary address of the primary interface first
+func (i *Instance) PrivateIpAddresses() []string {
+	addresses := []string{}
+	if i.PrivateIpAddress != "" {
+		addresses = append(addresses, i.PrivateIpAddress)
+	}
+	for _, eni := range i.NetworkInterfaces {
+		for _, address := range eni.PrivateIpAddresses {
+			if address != i.PrivateIpAddress {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	return addresses
+}
+
+// Detail returns the full DescribeInstances output for the instance, fetching
+// it by id on first use. Templates can use it for fields the compact form
+// doesn't keep, e.g. {{ with .Detail }}{{ .Architecture }}{{ end }}, but
+// should only do so in the preview so it's called for one instance at a time.
+// It's nil for instances without clients, e.g. browsed from a snapshot taken
+// with other profiles, whose preview only shows the snapshot's fields.
+func (i *Instance) Detail() (*types.Instance, error) {
+	d := i.detail
+	d.once.Do(func() {
+		if i.clients == nil {
+			return
+		}
+
+		output, err := i.clients.EC2.DescribeInstances(context.TODO(), &ec2.DescribeInstancesInput{
+			InstanceIds: []string{i.InstanceId},
+		})
+		if err != nil {
+			d.err = err
+			return
+		}
+		for _, r := range output.Reservations {
+			for _, instance := range r.Instances {
+				d.instance = &instance
+				return
+			}
+		}
+		d.err = fmt.Errorf("instance %s not found", i.InstanceId)
+	})
+	return d.instance, d.err
+}
@@ -0,0 +1,139 @@
+package ec2ssh
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// jumpHost is the last hop of the ProxyJump chain of a connection, the one
+// the target is reached from
+type jumpHost struct {
+	spec        string // ProxyJump value, the whole chain
+	destination string // last hop, [user@]host
+	port        string
+	via         string // hops before the last one, if any
+}
+
+// parseJumpHost splits a ProxyJump value into its last hop and the hops
+// leading to it
+func parseJumpHost(spec string) jumpHost {
+	jump := jumpHost{spec: spec}
+	hops := strings.Split(spec, ",")
+	last := strings.TrimPrefix(hops[len(hops)-1], "ssh://")
+	if i := strings.LastIndex(last, ":"); i > strings.LastIndex(last, "]") {
+		last, jump.port = last[:i], last[i+1:]
+	}
+	jump.destination = strings.Trim(last, "[]")
+	jump.via = strings.Join(hops[:len(hops)-1], ",")
+	return jump
+}
+
+// jumpControlPath returns the control socket of the master connection to a
+// jump host
+func jumpControlPath(jump jumpHost) string {
+	sum := sha256.Sum256([]byte("jump\x00" + jump.spec))
+	return filepath.Join(controlDir(), hex.EncodeToString(sum[:8]))
+}
+
+// args returns the ssh arguments reaching the jump host
+func (jump jumpHost) args() []string {
+	var args []string
+	if jump.port != "" {
+		args = append(args, "-p", jump.port)
+	}
+	if jump.via != "" {
+		args = append(args, "-J", jump.via)
+	}
+	return append(args, jump.destination)
+}
+
+// proxyJump returns the ProxyJump ssh resolves for a planned connection from
+// the ssh config, empty when there's none
+func proxyJump(plan *ConnectionPlan) string {
+	output, err := exec.Command("ssh", append([]string{"-G"}, plan.sshArgs()...)...).Output()
+	if err != nil {
+		return ""
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		if key == "proxyjump" && value != "none" {
+			return value
+		}
+	}
+	return ""
+}
+
+// shareJumpHosts opens one master connection to each jump host several ssh
+// plans go through, and routes them through it, so the panes of xpanes
+// authenticate to the bastion, MFA included, once rather than once each.
+// Failing to open one only warns, the plans then jump on their own.
+func (e *Ec2ssh) shareJumpHosts(plans []*ConnectionPlan) {
+	if !e.options.Multiplex.JumpHosts {
+		return
+	}
+
+	grouped := make(map[string][]*ConnectionPlan)
+	var specs []string
+	for _, plan := range plans {
+		if plan.Method != MethodSSH {
+			continue
+		}
+		spec := proxyJump(plan)
+		if spec == "" {
+			continue
+		}
+		if _, ok := grouped[spec]; !ok {
+			specs = append(specs, spec)
+		}
+		grouped[spec] = append(grouped[spec], plan)
+	}
+
+	for _, spec := range specs {
+		if len(grouped[spec]) < 2 {
+			continue
+		}
+		jump := parseJumpHost(spec)
+		path := jumpControlPath(jump)
+		if err := e.openJumpMaster(jump, path); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to open a shared connection to %s, each session jumps on its own: %v\n", jump.destination, err)
+			continue
+		}
+
+		// ProxyCommand given on the command line takes precedence over
+		// the ProxyJump of the config
+		proxy := append([]string{"ssh", "-o", "ControlPath=" + path, "-o", "ControlMaster=no", "-W", "%h:%p"}, jump.args()...)
+		for _, plan := range grouped[spec] {
+			plan.Options = append(plan.Options, "ProxyCommand="+shellJoin(proxy))
+		}
+	}
+}
+
+// openJumpMaster opens a master connection to a jump host in the
+// background, unless one is open already. It runs in the terminal so
+// authentication prompts are answered once, before xpanes starts.
+func (e *Ec2ssh) openJumpMaster(jump jumpHost, path string) error {
+	check := exec.Command("ssh", append([]string{"-o", "ControlPath=" + path, "-O", "check"}, jump.args()...)...)
+	if check.Run() == nil {
+		return nil
+	}
+
+	os.MkdirAll(controlDir(), 0o700)
+	fmt.Printf("Opening a shared connection to %s...\n", jump.destination)
+	args := []string{"-M", "-N", "-f",
+		"-o", "ControlPath=" + path,
+		"-o", controlPersist(e.options.Multiplex.Persist),
+	}
+	cmd := exec.Command("ssh", append(args, jump.args()...)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
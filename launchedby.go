@@ -0,0 +1,206 @@
+package ec2ssh
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudtrail"
+	cttypes "github.com/aws/aws-sdk-go-v2/service/cloudtrail/types"
+)
+
+// launchLookupPages bounds the CloudTrail pages read for an instance, busy
+// instances have many events and RunInstances is the oldest one
+const launchLookupPages = 10
+
+// Launch is who launched an instance and when, from its RunInstances event
+type Launch struct {
+	User string    `json:"user"`
+	Time time.Time `json:"time"`
+}
+
+// launchMissTTL is how long an instance whose launch wasn't found in
+// CloudTrail isn't looked up again, its event may not have been delivered yet
+const launchMissTTL = 24 * time.Hour
+
+// cachedLaunch is a launch cached on disk, or a launch that wasn't found
+type cachedLaunch struct {
+	Launch
+	Missing bool      `json:"missing,omitempty"`
+	Checked time.Time `json:"checked,omitempty"` // when the launch was found missing
+}
+
+// launches caches the launches found in CloudTrail by target id. They never
+// change, so they are kept on disk for good, while launches not found are
+// kept for launchMissTTL. Failed lookups are only remembered for the run.
+var launches struct {
+	sync.Mutex
+	loaded bool
+	cached map[string]cachedLaunch
+	failed map[string]bool
+}
+
+// launchCachePath returns the file caching the launches
+func launchCachePath() string {
+	return filepath.Join(cacheDir(), "launches.json")
+}
+
+// loadLaunches reads the launches cached on disk on first use. The caller
+// holds the launches lock.
+func loadLaunches() {
+	if launches.loaded {
+		return
+	}
+	launches.loaded = true
+	launches.cached = make(map[string]cachedLaunch)
+	launches.failed = make(map[string]bool)
+	if data, err := os.ReadFile(launchCachePath()); err == nil {
+		json.Unmarshal(data, &launches.cached)
+	}
+}
+
+// saveLaunches writes the cached launches to disk. The caller holds the
+// launches lock.
+func saveLaunches() {
+	if data, err := json.MarshalIndent(launches.cached, "", "  "); err == nil {
+		os.MkdirAll(cacheDir(), 0o755)
+		os.WriteFile(launchCachePath(), data, 0o644)
+	}
+}
+
+// cachedLaunchOf returns the cached launch of an instance, and whether it
+// needs to be looked up. The caller holds the launches lock.
+func cachedLaunchOf(key string) (*Launch, bool) {
+	loadLaunches()
+	cached, ok := launches.cached[key]
+	if ok && !cached.Missing {
+		return &cached.Launch, false
+	}
+	stale := !ok || time.Since(cached.Checked) >= launchMissTTL
+	return nil, stale && !launches.failed[key]
+}
+
+// LaunchedBy looks up who launched the instance in the CloudTrail event
+// history, e.g. {{ with .LaunchedBy }}{{ .User }} {{ age .Time }} ago{{ end }}
+// in the preview template. It's nil when the launch is older than the 90 days
+// of history CloudTrail keeps, or when it can't be looked up, e.g. without
+// cloudtrail:LookupEvents, so the rest of the preview still renders.
+func (i *Instance) LaunchedBy() *Launch {
+	key := i.TargetID()
+	launches.Lock()
+	launch, lookup := cachedLaunchOf(key)
+	launches.Unlock()
+	if !lookup || i.clients == nil || i.clients.CloudTrail == nil {
+		return launch
+	}
+
+	event, err := runInstancesEvent(context.TODO(), i.clients.CloudTrail, i.InstanceId)
+	launches.Lock()
+	defer launches.Unlock()
+	if err != nil {
+		launches.failed[key] = true
+		return nil
+	}
+	cached := cachedLaunch{Missing: true, Checked: time.Now()}
+	if event != nil {
+		cached = cachedLaunch{Launch: Launch{User: aws.ToString(event.Username), Time: aws.ToTime(event.EventTime)}}
+	}
+	launches.cached[key] = cached
+	saveLaunches()
+	if cached.Missing {
+		return nil
+	}
+	return &cached.Launch
+}
+
+// prefetchLaunches looks up the launches of instances sharing clients that
+// aren't cached yet, from the RunInstances events of their region rather
+// than the events of each instance: a page of events covers many instances.
+// Instances not found once the whole history was read are cached as missing.
+// Failures are ignored, LaunchedBy then looks the instances up on demand.
+func prefetchLaunches(c *awsClients, instances []*Instance) {
+	if c.CloudTrail == nil {
+		return
+	}
+	wanted := make(map[string]*Instance)
+	launches.Lock()
+	for _, instance := range instances {
+		if _, lookup := cachedLaunchOf(instance.TargetID()); lookup {
+			wanted[instance.InstanceId] = instance
+		}
+	}
+	launches.Unlock()
+	if len(wanted) == 0 {
+		return
+	}
+
+	found := make(map[string]Launch)
+	paginator := cloudtrail.NewLookupEventsPaginator(c.CloudTrail, &cloudtrail.LookupEventsInput{
+		LookupAttributes: []cttypes.LookupAttribute{{
+			AttributeKey:   cttypes.LookupAttributeKeyEventName,
+			AttributeValue: aws.String("RunInstances"),
+		}},
+	})
+	complete := true
+	for page := 0; len(found) < len(wanted); page++ {
+		if !paginator.HasMorePages() {
+			break
+		}
+		if page == launchLookupPages {
+			complete = false
+			break
+		}
+		output, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			complete = false
+			break
+		}
+		for _, event := range output.Events {
+			for _, resource := range event.Resources {
+				id := aws.ToString(resource.ResourceName)
+				if _, ok := wanted[id]; ok && aws.ToString(resource.ResourceType) == "AWS::EC2::Instance" {
+					found[id] = Launch{User: aws.ToString(event.Username), Time: aws.ToTime(event.EventTime)}
+				}
+			}
+		}
+	}
+
+	launches.Lock()
+	defer launches.Unlock()
+	for id, instance := range wanted {
+		if launch, ok := found[id]; ok {
+			launches.cached[instance.TargetID()] = cachedLaunch{Launch: launch}
+		} else if complete {
+			launches.cached[instance.TargetID()] = cachedLaunch{Missing: true, Checked: time.Now()}
+		}
+	}
+	saveLaunches()
+}
+
+// runInstancesEvent returns the RunInstances event of an instance, nil when
+// it isn't in the event history anymore
+func runInstancesEvent(ctx context.Context, client *cloudtrail.Client, instanceId string) (*cttypes.Event, error) {
+	paginator := cloudtrail.NewLookupEventsPaginator(client, &cloudtrail.LookupEventsInput{
+		LookupAttributes: []cttypes.LookupAttribute{{
+			AttributeKey:   cttypes.LookupAttributeKeyResourceName,
+			AttributeValue: aws.String(instanceId),
+		}},
+	})
+	for page := 0; page < launchLookupPages && paginator.HasMorePages(); page++ {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up the events of %s: %w", instanceId, err)
+		}
+		for _, event := range output.Events {
+			if aws.ToString(event.EventName) == "RunInstances" {
+				return &event, nil
+			}
+		}
+	}
+	return nil, nil
+}
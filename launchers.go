@@ -0,0 +1,148 @@
+package ec2ssh
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Launcher output formats of --output
+const (
+	OutputAlfred  = "alfred"
+	OutputRaycast = "raycast"
+)
+
+// alfredItems is the JSON format of Alfred script filters
+type alfredItems struct {
+	Items []alfredItem `json:"items"`
+}
+
+type alfredItem struct {
+	Uid          string            `json:"uid"`
+	Title        string            `json:"title"`
+	Subtitle     string            `json:"subtitle"`
+	Arg          string            `json:"arg"`
+	Autocomplete string            `json:"autocomplete"`
+	Match        string            `json:"match"`
+	Text         map[string]string `json:"text"`
+	Quicklookurl string            `json:"quicklookurl"`
+}
+
+// raycastItem is an instance as consumed by Raycast script commands and
+// extensions
+type raycastItem struct {
+	Id       string `json:"id"`
+	Title    string `json:"title"`
+	Subtitle string `json:"subtitle"`
+	Command  string `json:"command"`
+	URL      string `json:"url"`
+}
+
+// printLauncherItems prints the instances in the format of a launcher, each
+// with the command connecting to it, so launchers can let users pick an
+// instance outside of the terminal and have ec2-ssh connect to it. The Ansible
+// inventory format lists them for automation instead.
+func (e *Ec2ssh) printLauncherItems(instances []Instance) error {
+	executable, err := os.Executable()
+	if err != nil {
+		executable = "ec2-ssh"
+	}
+
+	var output interface{}
+	switch e.options.Output {
+	case OutputAlfred:
+		items := alfredItems{Items: make([]alfredItem, 0, len(instances))}
+		for i := range instances {
+			instance := &instances[i]
+			title := e.launcherTitle(instance)
+			command := e.reconnectCommand(executable, instance)
+			items.Items = append(items.Items, alfredItem{
+				Uid:          instance.TargetID(),
+				Title:        title,
+				Subtitle:     launcherSubtitle(instance),
+				Arg:          command,
+				Autocomplete: title,
+				Match:        title + " " + instance.InstanceId + " " + instance.PrivateIpAddress,
+				Text:         map[string]string{"copy": command},
+				Quicklookurl: instance.ConsoleURL(),
+			})
+		}
+		output = items
+	case OutputRaycast:
+		items := make([]raycastItem, 0, len(instances))
+		for i := range instances {
+			instance := &instances[i]
+			items = append(items, raycastItem{
+				Id:       instance.TargetID(),
+				Title:    e.launcherTitle(instance),
+				Subtitle: launcherSubtitle(instance),
+				Command:  e.reconnectCommand(executable, instance),
+				URL:      instance.ConsoleURL(),
+			})
+		}
+		output = items
+	case OutputAnsibleInventory:
+		output = e.ansibleInventory(instances)
+	}
+
+	data, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// launcherTitle returns the list template rendered for an instance, on a
+// single line
+func (e *Ec2ssh) launcherTitle(instance *Instance) string {
+	title, _ := TemplateForInstance(instance, e.listTemplate)
+	return strings.Join(strings.Fields(title), " ")
+}
+
+// launcherSubtitle describes where an instance is
+func launcherSubtitle(instance *Instance) string {
+	parts := []string{instance.InstanceId, instance.InstanceType, instance.Placement.AvailabilityZone, instance.State.Name}
+	if instance.Profile != "" {
+		parts = append(parts, instance.Profile)
+	}
+	return strings.Join(parts, " · ")
+}
+
+// reconnectCommand returns the ec2-ssh command connecting straight to an
+// instance, without the finder
+func (e *Ec2ssh) reconnectCommand(executable string, instance *Instance) string {
+	args := []string{executable}
+	if instance.Profile != "" {
+		args = append(args, instance.Profile)
+	}
+	args = append(args, "--region", instance.Region, "--instance", instance.TargetID())
+	if e.options.Org.Enabled {
+		args = append(args, "--org")
+	}
+	return shellJoin(args)
+}
+
+// validateOutput checks the --output format is known
+func validateOutput(output string) error {
+	switch output {
+	case "", OutputAlfred, OutputRaycast, OutputAnsibleInventory:
+		return nil
+	}
+	return fmt.Errorf("invalid --output %q, valid formats are: %s, %s, %s", output, OutputAlfred, OutputRaycast, OutputAnsibleInventory)
+}
+
+// filterInstanceIds keeps the instances given with --instance, by instance id
+// or target id, in the order of the ids
+func filterInstanceIds(instances []Instance, ids []string) []Instance {
+	matching := make([]Instance, 0, len(ids))
+	for _, id := range ids {
+		for _, instance := range instances {
+			if instance.InstanceId == id || instance.TargetID() == id {
+				matching = append(matching, instance)
+			}
+		}
+	}
+	return matching
+}
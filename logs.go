@@ -0,0 +1,140 @@
+package ec2ssh
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	finder "github.com/ktr0731/go-fuzzyfinder"
+)
+
+type LogsConfig struct {
+	Source  string `mapstructure:"source"` // journald or docker
+	Lines   int    `mapstructure:"lines"`
+	Command string `mapstructure:"command"` // custom tail command, skips the picker
+}
+
+// logSource knows how to list the log streams of a host and tail one of them
+type logSource struct {
+	list string
+	tail string
+}
+
+var logSources = map[string]logSource{
+	"journald": {
+		list: "systemctl list-units --type=service --state=running --no-legend --plain | awk '{print $1}'",
+		tail: "journalctl -f -n %d -u %s",
+	},
+	"docker": {
+		list: "docker ps --format '{{.Names}}'",
+		tail: "docker logs -f --tail %d %s",
+	},
+}
+
+// runLogs tails logs on the selected instances, picking the unit or container
+// to follow among the ones found on them, and merges the output prefixed with
+// each instance's name
+func (e *Ec2ssh) runLogs(plans []*ConnectionPlan) error {
+	command := e.options.Logs.Command
+	if command == "" {
+		source, ok := logSources[e.options.Logs.Source]
+		if !ok {
+			return fmt.Errorf("invalid logs.source %q, valid sources are: journald, docker", e.options.Logs.Source)
+		}
+
+		stream, err := e.pickLogStream(plans, source)
+		if err != nil {
+			return err
+		}
+		command = fmt.Sprintf(source.tail, e.options.Logs.Lines, shellQuote(stream))
+	}
+
+	labels := planLabels(plans)
+	outputLock := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	for i, plan := range plans {
+		args := e.remoteArgs(plan, command)
+		prefix := "[" + labels[i] + "] "
+
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			return err
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdout = writer
+		cmd.Stderr = writer
+		stopWatching := watchProcess(cmd, e.options.Session, false)
+		defer stopWatching()
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("failed to tail logs on %s: %w", strings.TrimSpace(labels[i]), err)
+		}
+		writer.Close()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scanner := bufio.NewScanner(reader)
+			for scanner.Scan() {
+				outputLock.Lock()
+				fmt.Println(prefix + strings.TrimRight(scanner.Text(), "\r"))
+				outputLock.Unlock()
+			}
+			reader.Close()
+			cmd.Wait()
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// pickLogStream lets the user pick a unit or container among the ones running
+// on any of the instances
+func (e *Ec2ssh) pickLogStream(plans []*ConnectionPlan, source logSource) (string, error) {
+	found := make(map[string]bool)
+	lock := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	for _, plan := range plans {
+		wg.Add(1)
+		go func(plan *ConnectionPlan) {
+			defer wg.Done()
+			output, err := e.runRemote(plan, source.list)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to list log sources on %s: %v\n", plan.Instance.InstanceId, err)
+				return
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			for _, line := range strings.Split(output, "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					found[line] = true
+				}
+			}
+		}(plan)
+	}
+	wg.Wait()
+
+	streams := make([]string, 0, len(found))
+	for stream := range found {
+		streams = append(streams, stream)
+	}
+	sort.Strings(streams)
+	if len(streams) == 0 {
+		return "", fmt.Errorf("no %s found on the selected instances", e.options.Logs.Source)
+	}
+
+	idx, err := finder.Find(streams, func(i int) string {
+		return streams[i]
+	}, finder.WithPromptString("logs> "))
+	if err != nil {
+		if errors.Is(err, finder.ErrAbort) {
+			e.exit(1)
+		}
+		return "", err
+	}
+	return streams[idx], nil
+}
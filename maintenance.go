@@ -0,0 +1,224 @@
+package ec2ssh
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// defaultMaintenanceWarning is how soon a scheduled event or maintenance
+// window must start to warn before connecting
+const defaultMaintenanceWarning = 24 * time.Hour
+
+// Maintenance holds the scheduled EC2 events and SSM maintenance windows of
+// an instance
+type Maintenance struct {
+	Events  []ScheduledEvent
+	Windows []MaintenanceWindow
+}
+
+// ScheduledEvent is an EC2 event scheduled for an instance, e.g. a reboot or
+// a retirement
+type ScheduledEvent struct {
+	Code        string
+	Description string
+	NotBefore   time.Time
+	NotAfter    time.Time
+}
+
+// MaintenanceWindow is an SSM maintenance window targeting an instance
+type MaintenanceWindow struct {
+	Id       string
+	Name     string
+	Next     time.Time // zero when it isn't scheduled again
+	Duration time.Duration
+	Active   bool // an execution is in progress
+}
+
+// Maintenance returns the scheduled events and maintenance windows of the
+// instance, e.g. {{ with .Maintenance }}{{ range .Events }}{{ .Code }}
+// {{ end }}{{ end }} in the preview template. Like checkMaintenance, it
+// tolerates failures: it's nil when they can't be fetched, e.g. without the
+// SSM maintenance window permissions, so the rest of the preview renders.
+// It's fetched once per instance, from the prefetched status checks and the
+// windows shared with the other instances.
+func (i *Instance) Maintenance() *Maintenance {
+	d := i.detail
+	d.maintenanceOnce.Do(func() {
+		d.maintenance, _ = i.maintenance()
+	})
+	return d.maintenance
+}
+
+// maintenance fetches the scheduled events and maintenance windows of the
+// instance
+func (i *Instance) maintenance() (*Maintenance, error) {
+	if i.clients == nil {
+		return nil, fmt.Errorf("no client available to describe %s", i.InstanceId)
+	}
+	status, err := i.instanceStatus()
+	if err != nil {
+		return nil, err
+	}
+	events := scheduledEvents(status)
+	windows, err := maintenanceWindows(context.TODO(), i.clients.SSM, i.InstanceId)
+	if err != nil {
+		return nil, err
+	}
+	return &Maintenance{Events: events, Windows: windows}, nil
+}
+
+// scheduledEvents returns the pending scheduled events in the status of an
+// instance
+func scheduledEvents(status *types.InstanceStatus) []ScheduledEvent {
+	var events []ScheduledEvent
+	for _, event := range status.Events {
+		description := aws.ToString(event.Description)
+		// Past events stay listed for a while, with their outcome first
+		if strings.HasPrefix(description, "[Completed]") || strings.HasPrefix(description, "[Canceled]") {
+			continue
+		}
+		events = append(events, ScheduledEvent{
+			Code:        string(event.Code),
+			Description: description,
+			NotBefore:   aws.ToTime(event.NotBefore),
+			NotAfter:    aws.ToTime(event.NotAfter),
+		})
+	}
+	return events
+}
+
+// windowKey identifies a maintenance window of an account and region
+type windowKey struct {
+	client *ssm.Client
+	id     string
+}
+
+// windowDetails caches the maintenance windows by windowKey for the run, as
+// they're shared by many instances
+var windowDetails sync.Map
+
+// maintenanceWindows returns the maintenance windows targeting an instance,
+// with their next execution and whether one is in progress
+func maintenanceWindows(ctx context.Context, client *ssm.Client, instanceId string) ([]MaintenanceWindow, error) {
+	var windows []MaintenanceWindow
+	paginator := ssm.NewDescribeMaintenanceWindowsForTargetPaginator(client, &ssm.DescribeMaintenanceWindowsForTargetInput{
+		ResourceType: ssmtypes.MaintenanceWindowResourceTypeInstance,
+		Targets:      []ssmtypes.Target{{Key: aws.String("InstanceIds"), Values: []string{instanceId}}},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the maintenance windows of %s: %w", instanceId, err)
+		}
+		for _, identity := range page.WindowIdentities {
+			key := windowKey{client, aws.ToString(identity.WindowId)}
+			if cached, ok := windowDetails.Load(key); ok {
+				if w := cached.(*MaintenanceWindow); w != nil {
+					windows = append(windows, *w)
+				}
+				continue
+			}
+
+			window, err := client.GetMaintenanceWindow(ctx, &ssm.GetMaintenanceWindowInput{WindowId: identity.WindowId})
+			if err != nil {
+				return nil, fmt.Errorf("failed to get the maintenance window %s: %w", aws.ToString(identity.WindowId), err)
+			}
+			if !window.Enabled {
+				windowDetails.Store(key, (*MaintenanceWindow)(nil))
+				continue
+			}
+
+			w := MaintenanceWindow{
+				Id:       aws.ToString(window.WindowId),
+				Name:     aws.ToString(window.Name),
+				Next:     parseWindowTime(aws.ToString(window.NextExecutionTime)),
+				Duration: time.Duration(aws.ToInt32(window.Duration)) * time.Hour,
+			}
+			executions, err := client.DescribeMaintenanceWindowExecutions(ctx, &ssm.DescribeMaintenanceWindowExecutionsInput{
+				WindowId: window.WindowId,
+				Filters: []ssmtypes.MaintenanceWindowFilter{{
+					Key:    aws.String("ExecutedAfter"),
+					Values: []string{time.Now().Add(-w.Duration).UTC().Format(time.RFC3339)},
+				}},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to list the executions of the maintenance window %s: %w", w.Id, err)
+			}
+			for _, execution := range executions.WindowExecutions {
+				if execution.Status == ssmtypes.MaintenanceWindowExecutionStatusInProgress {
+					w.Active = true
+				}
+			}
+			windowDetails.Store(key, &w)
+			windows = append(windows, w)
+		}
+	}
+	return windows, nil
+}
+
+// parseWindowTime parses the next execution time of a maintenance window,
+// given with or without seconds
+func parseWindowTime(value string) time.Time {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04Z07:00"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// imminent describes what is about to happen to an instance within the
+// given time, nothing when it can be connected to in peace
+func (m *Maintenance) imminent(within time.Duration) []string {
+	var warnings []string
+	deadline := time.Now().Add(within)
+	for _, event := range m.Events {
+		if !event.NotBefore.IsZero() && event.NotBefore.Before(deadline) {
+			warnings = append(warnings, fmt.Sprintf("%s scheduled %s (%s)",
+				event.Code, event.NotBefore.Local().Format("2006-01-02 15:04"), event.Description))
+		}
+	}
+	for _, window := range m.Windows {
+		switch {
+		case window.Active:
+			warnings = append(warnings, fmt.Sprintf("maintenance window %s is in progress", window.Name))
+		case !window.Next.IsZero() && window.Next.Before(deadline):
+			warnings = append(warnings, fmt.Sprintf("maintenance window %s starts %s",
+				window.Name, window.Next.Local().Format("2006-01-02 15:04")))
+		}
+	}
+	return warnings
+}
+
+// checkMaintenance warns before connecting to an instance about to be
+// rebooted or retired, or in a maintenance window, and asks to confirm.
+// Failures to look it up only cost the warning.
+func (e *Ec2ssh) checkMaintenance(instance *Instance) error {
+	if e.options.MaintenanceWarning <= 0 {
+		return nil
+	}
+	maintenance, err := instance.maintenance()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to check the maintenance of %s: %v\n", instance.InstanceId, err)
+		return nil
+	}
+	warnings := maintenance.imminent(e.options.MaintenanceWarning)
+	if len(warnings) == 0 {
+		return nil
+	}
+
+	fmt.Printf("%s (%s): %s\n", instance.InstanceId, instance.Tags["Name"], strings.Join(warnings, "; "))
+	if !confirm("Connect anyway?") {
+		return fmt.Errorf("not connecting to %s", instance.InstanceId)
+	}
+	return nil
+}
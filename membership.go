@@ -0,0 +1,202 @@
+package ec2ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	elb "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbtypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	finder "github.com/ktr0731/go-fuzzyfinder"
+)
+
+// MembershipFilters restrict the listing to the instances belonging to any
+// of the given auto scaling groups, target groups or load balancers
+type MembershipFilters struct {
+	AutoScalingGroups []string
+	TargetGroups      []string // ARNs or names
+	LoadBalancers     []string
+}
+
+// Enabled reports whether any membership filter is set
+func (m MembershipFilters) Enabled() bool {
+	return len(m.AutoScalingGroups) > 0 || len(m.TargetGroups) > 0 || len(m.LoadBalancers) > 0
+}
+
+// memberInstanceIds resolves the --asg, --target-group and --behind-lb
+// filters to the ids of the instances belonging to any of them, in the region
+// of the clients. It also returns the health of the instances registered in
+// target groups, e.g. "unhealthy: Target.Timeout", which restricts the
+// listing to unhealthy targets in triage mode.
+func (e *Ec2ssh) memberInstanceIds(ctx context.Context, c *awsClients) ([]string, map[string]string, error) {
+	seen := make(map[string]bool)
+	health := make(map[string]string)
+	var ids []string
+	add := func(id string) {
+		// IP and Lambda targets can't be listed as instances
+		if strings.HasPrefix(id, "i-") && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	if len(e.options.Membership.AutoScalingGroups) > 0 {
+		paginator := autoscaling.NewDescribeAutoScalingGroupsPaginator(c.AutoScaling, &autoscaling.DescribeAutoScalingGroupsInput{
+			AutoScalingGroupNames: e.options.Membership.AutoScalingGroups,
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to describe auto scaling groups: %w", err)
+			}
+			for _, group := range page.AutoScalingGroups {
+				for _, instance := range group.Instances {
+					add(aws.ToString(instance.InstanceId))
+				}
+			}
+		}
+	}
+
+	targetGroups, err := e.targetGroupArns(ctx, c)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, arn := range targetGroups {
+		output, err := c.ELB.DescribeTargetHealth(ctx, &elb.DescribeTargetHealthInput{
+			TargetGroupArn: aws.String(arn),
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to describe the targets of %s: %w", arn, err)
+		}
+		for _, target := range output.TargetHealthDescriptions {
+			if target.Target == nil {
+				continue
+			}
+			id := aws.ToString(target.Target.Id)
+			if target.TargetHealth != nil {
+				if e.options.Command == "triage" && target.TargetHealth.State == elbtypes.TargetHealthStateEnumHealthy {
+					continue
+				}
+				health[id] = targetHealth(target.TargetHealth)
+			}
+			add(id)
+		}
+	}
+	return ids, health, nil
+}
+
+// targetHealth describes the health of a target, with the reason it isn't
+// healthy if any
+func targetHealth(h *elbtypes.TargetHealth) string {
+	description := string(h.State)
+	if h.Reason != "" {
+		description += ": " + string(h.Reason)
+	}
+	if h.Description != nil {
+		description += " - " + aws.ToString(h.Description)
+	}
+	return description
+}
+
+// targetGroupArns returns the ARNs of the target groups given with
+// --target-group, as ARNs or names, and of those behind the load balancers
+// given with --behind-lb
+func (e *Ec2ssh) targetGroupArns(ctx context.Context, c *awsClients) ([]string, error) {
+	var arns, names []string
+	for _, group := range e.options.Membership.TargetGroups {
+		if strings.HasPrefix(group, "arn:") {
+			// Target group ARNs only exist in their own region
+			if parts := strings.Split(group, ":"); len(parts) > 3 && parts[3] != c.Region {
+				continue
+			}
+			arns = append(arns, group)
+		} else {
+			names = append(names, group)
+		}
+	}
+
+	// Names are described one at a time, as a name missing from the region
+	// fails the whole call: it just has no members here, like resource groups
+	for _, name := range names {
+		output, err := c.ELB.DescribeTargetGroups(ctx, &elb.DescribeTargetGroupsInput{Names: []string{name}})
+		var notFound *elbtypes.TargetGroupNotFoundException
+		if errors.As(err, &notFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe target group %s: %w", name, err)
+		}
+		for _, group := range output.TargetGroups {
+			arns = append(arns, aws.ToString(group.TargetGroupArn))
+		}
+	}
+
+	for _, name := range e.options.Membership.LoadBalancers {
+		output, err := c.ELB.DescribeLoadBalancers(ctx, &elb.DescribeLoadBalancersInput{
+			Names: []string{name},
+		})
+		var notFound *elbtypes.LoadBalancerNotFoundException
+		if errors.As(err, &notFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe load balancer %s: %w", name, err)
+		}
+		for _, lb := range output.LoadBalancers {
+			groups, err := c.ELB.DescribeTargetGroups(ctx, &elb.DescribeTargetGroupsInput{
+				LoadBalancerArn: lb.LoadBalancerArn,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to describe the target groups of %s: %w", aws.ToString(lb.LoadBalancerName), err)
+			}
+			for _, group := range groups.TargetGroups {
+				arns = append(arns, aws.ToString(group.TargetGroupArn))
+			}
+		}
+	}
+	return arns, nil
+}
+
+// pickTargetGroup lets the user pick one of the target groups of every
+// profile and region, for triage when none was given on the command line
+func (e *Ec2ssh) pickTargetGroup() (string, error) {
+	type targetGroup struct {
+		name, arn, region string
+	}
+
+	var groups []targetGroup
+	for _, c := range e.clients {
+		paginator := elb.NewDescribeTargetGroupsPaginator(c.ELB, &elb.DescribeTargetGroupsInput{})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(context.TODO())
+			if err != nil {
+				return "", fmt.Errorf("failed to list target groups in %s: %w", c.Region, err)
+			}
+			for _, group := range page.TargetGroups {
+				groups = append(groups, targetGroup{
+					name:   aws.ToString(group.TargetGroupName),
+					arn:    aws.ToString(group.TargetGroupArn),
+					region: c.Region,
+				})
+			}
+		}
+	}
+	if len(groups) == 0 {
+		return "", fmt.Errorf("no target groups found")
+	}
+
+	idx, err := finder.Find(groups, func(i int) string {
+		return fmt.Sprintf("%s (%s)", groups[i].name, groups[i].region)
+	}, finder.WithPromptString("target group> "))
+	if err != nil {
+		if errors.Is(err, finder.ErrAbort) {
+			os.Exit(1)
+		}
+		return "", err
+	}
+	return groups[idx].arn, nil
+}
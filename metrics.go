@@ -0,0 +1,206 @@
+package ec2ssh
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the latency histograms
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// counterVec is a counter with labels, in the Prometheus sense
+type counterVec struct {
+	name, help string
+	labels     []string
+	values     map[string]float64 // by label values joined with \x00
+}
+
+// histogramVec is a histogram with labels, in the Prometheus sense
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+	counts     map[string][]uint64 // cumulative counts per bucket, then +Inf
+	sums       map[string]float64
+}
+
+// metrics are the daemon metrics served on /metrics
+type metrics struct {
+	mu sync.Mutex
+
+	apiCalls     *counterVec
+	apiLatency   *histogramVec
+	cacheLookups *counterVec
+	connections  *counterVec
+	listLatency  *histogramVec
+}
+
+// daemonMetrics collects the metrics of the daemon process
+var daemonMetrics = &metrics{
+	apiCalls: &counterVec{
+		name:   "ec2ssh_aws_api_calls_total",
+		help:   "AWS API call attempts, retries included.",
+		labels: []string{"service", "operation", "outcome"},
+	},
+	apiLatency: &histogramVec{
+		name:    "ec2ssh_aws_api_call_duration_seconds",
+		help:    "Latency of AWS API call attempts.",
+		labels:  []string{"service", "operation"},
+		buckets: latencyBuckets,
+	},
+	cacheLookups: &counterVec{
+		name:   "ec2ssh_cache_lookups_total",
+		help:   "Instance list lookups served from a warm list (hit) or listed (miss).",
+		labels: []string{"result"},
+	},
+	connections: &counterVec{
+		name:   "ec2ssh_connection_attempts_total",
+		help:   "Connection attempts made by ec2-ssh runs, by method.",
+		labels: []string{"method"},
+	},
+	listLatency: &histogramVec{
+		name:    "ec2ssh_list_duration_seconds",
+		help:    "Time taken to list the instances of a query.",
+		buckets: latencyBuckets,
+	},
+}
+
+// inc adds one to a counter
+func (m *metrics) inc(c *counterVec, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c.values == nil {
+		c.values = make(map[string]float64)
+	}
+	c.values[strings.Join(labels, "\x00")]++
+}
+
+// observe records a duration in a histogram
+func (m *metrics) observe(h *histogramVec, d time.Duration, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if h.counts == nil {
+		h.counts = make(map[string][]uint64)
+		h.sums = make(map[string]float64)
+	}
+	key := strings.Join(labels, "\x00")
+	counts, ok := h.counts[key]
+	if !ok {
+		counts = make([]uint64, len(h.buckets)+1)
+		h.counts[key] = counts
+	}
+	seconds := d.Seconds()
+	for i, bound := range h.buckets {
+		if seconds <= bound {
+			counts[i]++
+		}
+	}
+	counts[len(h.buckets)]++
+	h.sums[key] += seconds
+}
+
+// write writes the metrics in the Prometheus text exposition format
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, c := range []*counterVec{m.apiCalls, m.cacheLookups, m.connections} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+		for _, key := range sortedKeys(c.values) {
+			fmt.Fprintf(w, "%s%s %g\n", c.name, labelPairs(c.labels, key, ""), c.values[key])
+		}
+	}
+	for _, h := range []*histogramVec{m.apiLatency, m.listLatency} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+		for _, key := range sortedKeys(h.sums) {
+			counts := h.counts[key]
+			for i, bound := range h.buckets {
+				fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelPairs(h.labels, key, fmt.Sprintf("%g", bound)), counts[i])
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelPairs(h.labels, key, "+Inf"), counts[len(h.buckets)])
+			fmt.Fprintf(w, "%s_sum%s %g\n", h.name, labelPairs(h.labels, key, ""), h.sums[key])
+			fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelPairs(h.labels, key, ""), counts[len(h.buckets)])
+		}
+	}
+}
+
+// labelPairs formats the labels of a series, with the le label of histogram
+// buckets when given
+func labelPairs(names []string, key, le string) string {
+	var pairs []string
+	if len(names) > 0 {
+		for i, value := range strings.Split(key, "\x00") {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", names[i], value))
+		}
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf("le=%q", le))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// sortedKeys returns the keys of a map, sorted
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// metricsMiddleware counts and times every AWS API call attempt made by the
+// daemon's clients
+func metricsMiddleware(stack *middleware.Stack) error {
+	return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc("ec2sshMetrics", func(
+		ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler,
+	) (middleware.FinalizeOutput, middleware.Metadata, error) {
+		start := time.Now()
+		out, metadata, err := next.HandleFinalize(ctx, in)
+
+		service := awsmiddleware.GetServiceID(ctx)
+		operation := awsmiddleware.GetOperationName(ctx)
+		outcome := "success"
+		if err != nil {
+			outcome = "error"
+		}
+		daemonMetrics.inc(daemonMetrics.apiCalls, service, operation, outcome)
+		daemonMetrics.observe(daemonMetrics.apiLatency, time.Since(start), service, operation)
+		return out, metadata, err
+	}), middleware.After)
+}
+
+// handleMetrics serves the daemon metrics for Prometheus
+func (d *Daemon) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	daemonMetrics.write(w)
+}
+
+// RecordConnection counts a connection attempt reported by an ec2-ssh run
+func (d *Daemon) RecordConnection(method string, _ *int) error {
+	daemonMetrics.inc(daemonMetrics.connections, method)
+	return nil
+}
+
+// recordConnection reports a connection attempt to the daemon, if one is
+// running, for its metrics
+func recordConnection(method string) {
+	client, err := dialDaemon()
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	client.Call("Daemon.RecordConnection", method, new(int))
+}
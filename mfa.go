@@ -0,0 +1,132 @@
+package ec2ssh
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// autoSerial detects the MFA device of the caller with IAM
+const autoSerial = "auto"
+
+// MFAConfig configures how MFA codes are obtained when assuming roles or
+// getting session tokens requires them
+type MFAConfig struct {
+	Serial  string // MFA device ARN for org mode, or auto
+	Command string // prints a code, e.g. ykman oath accounts code -s AWS
+}
+
+// mfaPrompt serializes the prompts of credentials retrieved concurrently
+var mfaPrompt sync.Mutex
+
+// mfaCode matches the TOTP codes accepted by AWS
+var mfaCode = regexp.MustCompile(`^[0-9]{6}$`)
+
+// mfaTokenProvider returns the function giving an MFA code, from the
+// configured command or typed on the terminal
+func mfaTokenProvider(config MFAConfig) func() (string, error) {
+	return func() (string, error) {
+		mfaPrompt.Lock()
+		defer mfaPrompt.Unlock()
+
+		var code string
+		if config.Command != "" {
+			cmd := exec.Command("sh", "-c", config.Command)
+			cmd.Stdin = os.Stdin // ykman may ask to touch the key
+			cmd.Stderr = os.Stderr
+			output, err := cmd.Output()
+			if err != nil {
+				return "", fmt.Errorf("MFA command failed: %w", err)
+			}
+			code = strings.TrimSpace(string(output))
+		} else {
+			fmt.Fprint(os.Stderr, "MFA code: ")
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil {
+				return "", fmt.Errorf("failed to read the MFA code: %w", err)
+			}
+			code = strings.TrimSpace(line)
+		}
+
+		if !mfaCode.MatchString(code) {
+			return "", fmt.Errorf("invalid MFA code %q, expected 6 digits", code)
+		}
+		return code, nil
+	}
+}
+
+// mfaLoadOptions lets roles assumed through profiles with mfa_serial ask for
+// MFA codes, the SDK fails without a token provider
+func mfaLoadOptions(options Options) config.LoadOptionsFunc {
+	return config.WithAssumeRoleCredentialOptions(func(o *stscreds.AssumeRoleOptions) {
+		o.TokenProvider = mfaTokenProvider(options.MFA)
+	})
+}
+
+// profileUsesMFA reports whether a profile assumes a role with MFA
+func profileUsesMFA(profile string) bool {
+	if profile == "" {
+		return false
+	}
+	shared, err := config.LoadSharedConfigProfile(context.TODO(), profile)
+	return err == nil && shared.MFASerial != ""
+}
+
+// resolveMFASerial returns the configured MFA device, or the first device of
+// the caller when set to auto
+func resolveMFASerial(ctx context.Context, cfg aws.Config, serial string) (string, error) {
+	if serial != autoSerial {
+		return serial, nil
+	}
+	output, err := iam.NewFromConfig(cfg).ListMFADevices(ctx, &iam.ListMFADevicesInput{})
+	if err != nil {
+		return "", fmt.Errorf("failed to detect the MFA device: %w", err)
+	}
+	if len(output.MFADevices) == 0 {
+		return "", fmt.Errorf("no MFA device found for the caller, set mfa.serial")
+	}
+	return aws.ToString(output.MFADevices[0].SerialNumber), nil
+}
+
+// sessionTokenProvider gets MFA authenticated session credentials with
+// GetSessionToken, so roles requiring MFA can be assumed in many accounts
+// with a single code
+type sessionTokenProvider struct {
+	client *sts.Client
+	serial string
+	token  func() (string, error)
+}
+
+// Retrieve gets new session credentials
+func (p *sessionTokenProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	code, err := p.token()
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+	output, err := p.client.GetSessionToken(ctx, &sts.GetSessionTokenInput{
+		SerialNumber: aws.String(p.serial),
+		TokenCode:    aws.String(code),
+	})
+	if err != nil {
+		return aws.Credentials{}, fmt.Errorf("failed to get an MFA session: %w", err)
+	}
+	return aws.Credentials{
+		AccessKeyID:     aws.ToString(output.Credentials.AccessKeyId),
+		SecretAccessKey: aws.ToString(output.Credentials.SecretAccessKey),
+		SessionToken:    aws.ToString(output.Credentials.SessionToken),
+		Source:          "ec2-ssh MFA session",
+		CanExpire:       true,
+		Expires:         aws.ToTime(output.Credentials.Expiration),
+	}, nil
+}
@@ -0,0 +1,85 @@
+package ec2ssh
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// defaultControlPersist is how long master connections stay open once the
+// last session using them ended
+const defaultControlPersist = 10 * time.Minute
+
+// MultiplexConfig configures SSH connection sharing through ControlMaster
+type MultiplexConfig struct {
+	Enabled bool          // open a master connection per target
+	Persist time.Duration // how long idle master connections stay open
+	Reuse   bool          // only attach to an existing master connection
+
+	// JumpHosts shares one master connection to each bastion between the
+	// sessions opened at once through it
+	JumpHosts bool
+}
+
+// validateMultiplex checks how long master connections persist: ssh takes
+// whole seconds, and reads ControlPersist=0 as keeping them open forever
+func validateMultiplex(config MultiplexConfig) error {
+	if config.Persist < time.Second {
+		return fmt.Errorf("invalid multiplex.persist %s, it must be at least 1s", config.Persist)
+	}
+	return nil
+}
+
+// controlPersist returns the ControlPersist option of master connections, in
+// seconds rounded up
+func controlPersist(persist time.Duration) string {
+	return "ControlPersist=" + strconv.Itoa(int((persist+time.Second-1)/time.Second))
+}
+
+// controlDir holds the control sockets, kept short as socket paths are
+// limited to about 100 characters
+func controlDir() string {
+	return filepath.Join(cacheDir(), "cm")
+}
+
+// controlPath returns the control socket of a target, user and port
+// included as they make different connections
+func controlPath(instance *Instance, user, port string) string {
+	sum := sha256.Sum256([]byte(instance.TargetID() + "\x00" + user + "\x00" + port))
+	return filepath.Join(controlDir(), hex.EncodeToString(sum[:8]))
+}
+
+// multiplexOptions returns the ssh options sharing connections to the planned
+// target, none when multiplexing is off
+func (e *Ec2ssh) multiplexOptions(plan *ConnectionPlan) []string {
+	config := e.options.Multiplex
+	if !config.Enabled && !config.Reuse {
+		return nil
+	}
+
+	os.MkdirAll(controlDir(), 0o700)
+	options := []string{"ControlPath=" + controlPath(plan.Instance, plan.User, plan.Port)}
+	if config.Reuse {
+		return append(options, "ControlMaster=no")
+	}
+	return append(options, "ControlMaster=auto", controlPersist(config.Persist))
+}
+
+// checkControlSockets makes sure --reuse has master connections to attach
+// to, ssh would otherwise silently open new connections
+func checkControlSockets(plans []*ConnectionPlan) error {
+	for _, plan := range plans {
+		if plan.Method != MethodSSH {
+			continue
+		}
+		path := controlPath(plan.Instance, plan.User, plan.Port)
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("no open connection to %s to reuse, connect once with multiplexing enabled (--multiplex) first", plan.Instance.InstanceId)
+		}
+	}
+	return nil
+}
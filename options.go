@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 	
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -14,7 +15,20 @@ import (
 type SSMConfig struct {
 	TagKey   string `mapstructure:"tag_key"`
 	TagValue string `mapstructure:"tag_value"` // empty means any value
-	Command  string `mapstructure:"command"`
+	Command  string `mapstructure:"command"`   // empty picks a login shell per OS family
+	Document string `mapstructure:"document"`  // custom session document, replaces command
+
+	// ShellProfile is run before the command on session start, a template
+	// rendered for the instance
+	ShellProfile string `mapstructure:"shell_profile"`
+
+	CommandByPlatform map[string]string `mapstructure:"command_by_platform"`
+}
+
+type RetryConfig struct {
+	MaxAttempts int           `mapstructure:"max_attempts"`
+	Mode        string        `mapstructure:"mode"` // standard or adaptive
+	MaxBackoff  time.Duration `mapstructure:"max_backoff"`
 }
 
 type Options struct {
@@ -22,10 +36,130 @@ type Options struct {
 	UsePrivateIp    bool
 	Template        string
 	PreviewTemplate string
+	PreviewPrefetch int // instances whose preview data is prefetched, 0 disables it
 	Filters         []string
-	Profile         string
+	Membership      MembershipFilters
+	ResourceGroup   string // resource group whose query filters the listing
+	Org             OrgConfig
+	Discovery       string
+	Profiles        []string
+	Context         string
+	ProfileRegions  map[string][]string
+	ShowDuplicates  bool
+	MaxInstances    int
+	Retry           RetryConfig
+	EndpointUrl     string
+	Endpoints       EndpointsConfig
+	HTTP            HTTPConfig
+	UpdateCheck     bool
 	PrintOnly       bool
-	SSM             SSMConfig `mapstructure:"ssm"`
+	Connect         string // connection method forced for every instance
+	ReadOnly        bool
+	NonCompliant    bool
+	Findings        FindingsConfig
+
+	// MaintenanceWarning is how soon scheduled events and maintenance
+	// windows warn before connecting, 0 disables the check
+	MaintenanceWarning time.Duration
+
+	SSM            SSMConfig `mapstructure:"ssm"`
+	Logs           LogsConfig
+	CloudWatchLogs CloudWatchLogsConfig
+	Tunnels        []TunnelConfig
+	Guardrails     []GuardrailConfig
+	Bastions       []BastionConfig
+	Selection      SelectionConfig
+	Fallback       FallbackConfig
+	SSHKey         SSHKeyConfig
+	SSHCertificate SSHCertificateConfig
+	Session        SessionConfig
+
+	CredentialHelpers map[string]string
+	MFA               MFAConfig
+	Container         bool
+	Socks             int
+	Sshuttle          bool
+	Hibernate         bool
+	AuthorizeMyIp     bool
+	Authorize         AuthorizeConfig
+	Reachability      ReachabilityConfig
+	PickAddress       bool
+	Verbose           bool
+	Containers        ContainersConfig
+	AccountAliases    map[string]string
+	Shell             ShellConfig
+	Plugins           []PluginConfig
+	Teleport          TeleportConfig
+	Tailscale         TailscaleConfig
+	Revision          RevisionConfig
+	RightSizing       RightSizingConfig
+	RegionOrder       RegionOrderConfig
+	SearchFields      []string
+	PickBy            string
+	Query             string
+	Output            string
+	InstanceIds       []string
+	Cache             CacheConfig
+	Daemon            DaemonConfig
+	Tracing           TracingConfig
+	Hosts             HostsConfig
+	Ansible           AnsibleConfig
+	Multiplex         MultiplexConfig
+	Panes             PanesConfig
+	Fleet             FleetConfig
+	Webhook           WebhookConfig
+	Snapshot          string // file the listed instances are saved to
+	FromSnapshot      string // file the instances are read from instead of listed
+	Env               EnvConfig
+	As                string
+	Command           string
+	CommandArgs       []string
+
+	ResourceExplorer ResourceExplorerConfig
+}
+
+// instanceCommands are the subcommands acting on the selected instances,
+// with the number of arguments each expects after the optional profile
+var instanceCommands = map[string]int{
+	"push-file": 2,
+	"logs":      0,
+	"tunnel":    0,
+	"stop":      0,
+	"terminate": 0,
+	"triage":    0,
+
+	"console-output":  0,
+	"screenshot":      0,
+	"cloudwatch-logs": 0,
+	"serial":          0,
+	"search":          1,
+	"hosts-gen":       0,
+	"bookmark":        1,
+	"unbookmark":      0,
+	"pin":             0,
+}
+
+// instanceCommandUsage documents the arguments of each instance subcommand
+var instanceCommandUsage = map[string]string{
+	"push-file": "ec2-ssh push-file [profile] <local-file> <remote-path>",
+	"logs":      "ec2-ssh logs [profile]",
+	"tunnel":    "ec2-ssh tunnel [profile]",
+	"stop":      "ec2-ssh stop [profile] [--hibernate]",
+	"terminate": "ec2-ssh terminate [profile]",
+	"triage":    "ec2-ssh triage [profile] [--target-group <arn|name> | --behind-lb <name>]",
+
+	"console-output":  "ec2-ssh console-output [profile]",
+	"screenshot":      "ec2-ssh screenshot [profile]",
+	"cloudwatch-logs": "ec2-ssh cloudwatch-logs [profile]",
+	"serial":          "ec2-ssh serial [profile]",
+	"search":          "ec2-ssh search [profile] <saved search>",
+	"hosts-gen":       "ec2-ssh hosts-gen [profile] [--hosts-format hosts|dnsmasq] [--hosts-file <path>]",
+	"bookmark":        "ec2-ssh bookmark [profile] <note>",
+	"unbookmark":      "ec2-ssh unbookmark [profile]",
+	"pin":             "ec2-ssh pin [profile]",
+
+	"cache-warm": "ec2-ssh cache warm [profile]",
+	"daemon-run": "ec2-ssh daemon run",
 }
 
 func ParseOptions() Options {
@@ -36,9 +170,21 @@ func ParseOptions() Options {
 	}
 	
 	if len(os.Args) > 1 && os.Args[1] == "--completion-list" {
-		profiles := getAWSProfiles()
-		for _, profile := range profiles {
-			fmt.Println(profile)
+		printCompletionList(os.Args[2:])
+		os.Exit(0)
+	}
+	
+	if len(os.Args) > 1 && os.Args[1] == "completion" {
+		if err := runCompletionCommand(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		if !runDoctor() {
+			os.Exit(1)
 		}
 		os.Exit(0)
 	}
@@ -49,63 +195,347 @@ func ParseOptions() Options {
 		os.Exit(0)
 	}
 
-	// Handle positional profile argument
-	var positionalProfile string
-	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
-		positionalProfile = os.Args[1]
-		// Remove the profile from args so pflag doesn't see it
-		os.Args = append(os.Args[:1], os.Args[2:]...)
+	readConfig()
+
+	var contexts map[string]ContextConfig
+	if err := viper.UnmarshalKey("contexts", &contexts); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid contexts configuration: %v\n", err)
+		os.Exit(1)
 	}
 
-	viper.SetConfigName("config")
-	viper.SetConfigType("toml")
-	viper.AddConfigPath("$HOME/.config/ec2-ssh")
-	if err := viper.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			// Config file not found; ignore error if desired
+	if len(os.Args) > 1 && os.Args[1] == "use" {
+		if err := runUseCommand(os.Args[2:], contexts); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "unpin" {
+		if err := runUnpinCommand(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		if err := runDiffCommand(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Internal, runs the sessions of xpanes panes under the session limits
+	if len(os.Args) > 1 && os.Args[1] == "watch-session" {
+		code, err := runWatchSessionCommand(os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(code)
+	}
+
+	// Subcommands acting on the selected instances take their arguments
+	// after the optional profile, they are collected once flags are parsed
+	var command string
+	if len(os.Args) > 1 {
+		if _, ok := instanceCommands[os.Args[1]]; ok {
+			command = os.Args[1]
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		}
+	}
+
+	// The daemon serves in the foreground like a regular run, the other
+	// daemon commands talk to it
+	if len(os.Args) > 1 && os.Args[1] == "daemon" {
+		if len(os.Args) > 2 && os.Args[2] == "run" {
+			command = "daemon-run"
+			os.Args = append(os.Args[:1], os.Args[3:]...)
 		} else {
-			panic(err)
+			if err := runDaemonCommand(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			os.Exit(0)
 		}
 	}
 
+	// Warming the cache lists instances like a regular run, the other cache
+	// commands only deal with the files
+	if len(os.Args) > 1 && os.Args[1] == "cache" {
+		if len(os.Args) > 2 && os.Args[2] == "warm" {
+			command = "cache-warm"
+			os.Args = append(os.Args[:1], os.Args[3:]...)
+		} else {
+			if err := runCacheCommand(os.Args[2:], viper.GetDuration("cache.ttl")); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+	}
+
+	// Handle positional profile argument, several profiles can be given
+	// separated by commas
+	var positionalProfiles []string
+	if command == "" && len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		positionalProfiles = strings.Split(os.Args[1], ",")
+		// Remove the profile from args so pflag doesn't see it
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
 	pflag.StringSlice("region", []string{"us-east-1"}, "The AWS region")
 	pflag.Bool("use-private-ip", true, "Use private IP instead of public DNS")
 	pflag.StringSlice("filters", []string{}, "Filters to apply with the ec2 api call")
+	pflag.StringSlice("asg", []string{}, "Only list instances of these auto scaling groups")
+	pflag.StringSlice("target-group", []string{}, "Only list instances registered in these target groups (ARNs or names)")
+	pflag.StringSlice("behind-lb", []string{}, "Only list instances registered behind these load balancers")
+	pflag.String("resource-group", "", "Only list instances matching the query of this AWS Resource Groups group")
+	pflag.Bool("org", false, "List the instances of every account of the organization by assuming org.role in each")
+	pflag.String("org-role", "", "Role assumed in the member accounts in org mode (default OrganizationAccountAccessRole)")
+	pflag.String("discovery", "", "How instances are found: describe-instances (default) or resource-explorer")
 	pflag.Bool("print-only", false, "Print connection details only, don't SSH")
+	pflag.String("connect", "", "Connect to every instance with this method: ssh, ssm or a registered connector")
+	pflag.Bool("read-only", false, "Refuse every action changing instances or security groups, e.g. for auditors")
+	pflag.String("endpoint-url", "", "Override the AWS API endpoint URL, e.g. for LocalStack")
+	pflag.Bool("container", false, "Pick a running container on the instance and exec into it")
+	pflag.Int("socks", 0, "Open a SOCKS proxy on this local port through the instance")
+	pflag.Bool("sshuttle", false, "Route the instance's VPC CIDRs through it with sshuttle")
+	pflag.String("ssm-document", "", "SSM session document to start instead of running ssm.command")
+	pflag.Bool("hibernate", false, "Hibernate instances launched with hibernation enabled when stopping them")
+	pflag.Bool("authorize-my-ip", false, "Temporarily allow SSH from your public IP in the configured security group")
+	pflag.Bool("pick-address", false, "Pick the private address to connect to on instances with several")
+	pflag.Bool("verbose", false, "Explain how the connection to each instance was planned")
+	pflag.String("theme", "", "Built-in list and preview templates: minimal, detailed (default), ops or network")
+	pflag.Int("max-instances", 0, "Stop listing once this many instances are found (0 means no limit)")
+	pflag.Bool("non-compliant-only", false, "Only list instances with missing, failed or pending patches, or without a patch scan")
+	pflag.Bool("findings", false, "Mark instances with open high-severity GuardDuty or Inspector findings")
+	pflag.Bool("tailscale", false, "Connect with ssh over the tailnet to the instances found on it")
+	pflag.String("home-region", "", "Region listed first, its instances at the top of the list")
+	pflag.Bool("show-duplicates", false, "Show instances listed through several profiles once per profile")
+	pflag.StringSlice("search-fields", []string{}, "Extra fields to fuzzy match on: tags, private-ip, public-ip, ami-name")
+	pflag.String("output", "", "Print the instances instead of picking one: alfred, raycast or ansible-inventory")
+	pflag.StringSlice("instance", []string{}, "Connect to these instance ids without the finder")
+	pflag.Bool("reverse", false, "Connect to the selected instances, and lay out their panes, in reverse order")
+	pflag.Bool("pinned", false, "Act on the instance pinned in this terminal with 'ec2-ssh pin'")
+	pflag.Bool("refresh", false, "List instances again instead of using the cached lists")
+	pflag.String("snapshot", "", "Save the listed instances, as filtered, to this JSON file instead of picking one")
+	pflag.String("from-snapshot", "", "Browse the instances saved in this snapshot file instead of listing them")
+	pflag.Bool("searches", false, "Pick one of the saved searches")
+	pflag.String("pick-by", "", "Pick a value of this tag first, e.g. tag:Service, then the matching instances")
+	pflag.String("hosts-format", "", "Format of hosts-gen: hosts (default) or dnsmasq")
+	pflag.String("hosts-file", "", "File whose ec2-ssh block hosts-gen updates, instead of printing it")
+	pflag.String("as", "", "Open the login shell as this user with sudo -iu, e.g. the application user")
+	pflag.String("command", "", "Run this command on the selected instances instead of opening shells, a template rendered for each")
+	pflag.String("output-dir", "", "Write the output of --command on each instance to a file in this directory, with a summary.json")
+	pflag.Int("canary", 0, "Run --command on this many instances first, and on the others once it succeeded and you confirmed")
+	pflag.Int("max-failures", -1, "Stop starting --command on more instances once it failed on more than this many (-1 never stops)")
+	pflag.Bool("multiplex", false, "Share one SSH connection per instance between sessions (ControlMaster)")
+	pflag.Bool("reuse", false, "Attach to the shared SSH connection opened by an earlier --multiplex session")
+	pflag.String("trace", "", "Trace the run with OpenTelemetry, exporting spans to stdout or otlp")
 	pflag.Parse()
 	viper.BindPFlags(pflag.CommandLine)
+	viper.BindPFlag("ssm.document", pflag.Lookup("ssm-document"))
+	viper.BindPFlag("org.role", pflag.Lookup("org-role"))
+	viper.BindPFlag("tracing.exporter", pflag.Lookup("trace"))
+	viper.BindPFlag("multiplex.enabled", pflag.Lookup("multiplex"))
+	viper.BindPFlag("findings.enabled", pflag.Lookup("findings"))
+	viper.BindPFlag("tailscale.enabled", pflag.Lookup("tailscale"))
+	viper.BindPFlag("region_order.home", pflag.Lookup("home-region"))
+	viper.BindPFlag("hosts.format", pflag.Lookup("hosts-format"))
+	viper.BindPFlag("hosts.file", pflag.Lookup("hosts-file"))
+
+	var commandArgs []string
+	if command != "" {
+		commandArgs = pflag.Args()
+		if len(commandArgs) == instanceCommands[command]+1 {
+			positionalProfiles = strings.Split(commandArgs[0], ",")
+			commandArgs = commandArgs[1:]
+		}
+		if mutatingCommands[command] && readOnlyConfigured() {
+			fmt.Fprintf(os.Stderr, "%s is %v\n", command, errReadOnly)
+			os.Exit(1)
+		}
+		if len(commandArgs) != instanceCommands[command] {
+			fmt.Fprintf(os.Stderr, "Usage: %s\n", instanceCommandUsage[command])
+			os.Exit(1)
+		}
+	}
 
 	viper.RegisterAlias("UsePrivateIp", "use-private-ip")
+	viper.RegisterAlias("ReadOnly", "read-only")
 	viper.RegisterAlias("regions", "region")
+	viper.RegisterAlias("SearchFields", "search-fields")
+	viper.RegisterAlias("MaxInstances", "max-instances")
+	viper.RegisterAlias("PickBy", "pick-by")
+	viper.RegisterAlias("EndpointUrl", "endpoint-url")
 
 	viper.SetDefault("Region", "us-east-1")
 	viper.SetDefault("UsePrivateIp", true)
-	viper.SetDefault("Template", `{{ .InstanceId }}: {{index .Tags "Name"}}`)
-	viper.SetDefault("PreviewTemplate", `
-			Instance Id: {{.InstanceId}}
-			Name:        {{index .Tags "Name"}}
-			Private IP:  {{.PrivateIpAddress}}
-			Public IP:   {{.PublicIpAddress}}
-
-			Tags:
-			{{ range $key, $value := .Tags }}
-				{{ indent 2 $key }}: {{ $value }}
-			{{- end -}}
-		`,
-	)
+	viper.SetDefault("UpdateCheck", true)
+	theme, err := lookupTheme(viper.GetString("theme"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	viper.SetDefault("Template", theme.List)
+	viper.SetDefault("PreviewTemplate", theme.Preview)
 	
-	// SSM defaults
-	viper.SetDefault("ssm.command", "bash -l")
+	// Org mode defaults
+	viper.SetDefault("org.role", "OrganizationAccountAccessRole")
+	viper.SetDefault("org.concurrency", defaultOrgConcurrency)
+
+	// Logs defaults
+	viper.SetDefault("logs.source", "journald")
+	viper.SetDefault("logs.lines", 100)
+	viper.SetDefault("cloudwatch_logs.stream", "{{ .InstanceId }}")
+
+	// Daemon defaults
+	viper.SetDefault("daemon.refresh", defaultDaemonRefresh)
+
+	// Multiplexing defaults
+	viper.SetDefault("multiplex.persist", defaultControlPersist)
+	viper.SetDefault("multiplex.jump_hosts", true)
+	viper.SetDefault("fleet.concurrency", defaultFleetConcurrency)
+	viper.SetDefault("webhook.format", WebhookJSON)
+	viper.SetDefault("preview.prefetch", defaultPreviewPrefetch)
+	viper.SetDefault("findings.sources", []string{"guardduty", "inspector"})
+	viper.SetDefault("maintenance.warn_within", defaultMaintenanceWarning)
+	viper.SetDefault("selection.confirm_above", 10)
+	viper.SetDefault("rightsizing.low", 10.0)
+	viper.SetDefault("rightsizing.high", 80.0)
+	viper.SetDefault("fallback.mode", FallbackAsk)
+	viper.SetDefault("fallback.within", 30*time.Second)
+	viper.SetDefault("ssh_key.mode", SSHKeyAgent)
+	viper.SetDefault("ssh_key.lifetime", time.Hour)
+	viper.SetDefault("ssh_certificate.public_key", "~/.ssh/id_ed25519.pub")
+	viper.SetDefault("selection.environment_tag", "Environment")
+
+	// hosts-gen defaults
+	viper.SetDefault("hosts.format", HostsFormatHosts)
+	viper.SetDefault("hosts.tag", "Name")
+
+	// Container picker defaults
+	viper.SetDefault("containers.cli", "docker")
+
+	// Template shell function defaults
+	viper.SetDefault("shell.enabled", false)
+	viper.SetDefault("shell.timeout", "2s")
 
-	// Use positional profile if provided
-	profile := positionalProfile
+	templateMigrationNotice()
 
-	// Auto-detect region from profile if not specified
+	// Use positional profiles if provided, the current context otherwise
+	profiles, err := expandProfiles(positionalProfiles)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 	regions := viper.GetStringSlice("Regions")
-	if len(regions) == 1 && regions[0] == "us-east-1" && profile != "" {
-		if detectedRegion := getRegionFromProfile(profile); detectedRegion != "" {
-			regions = []string{detectedRegion}
+	filters := viper.GetStringSlice("Filters")
+
+	// Saved searches bring their own profiles, regions and filters
+	searchName := ""
+	if command == "search" {
+		searchName = commandArgs[0]
+	}
+	var query string
+	if searchName != "" || viper.GetBool("searches") {
+		searches, err := savedSearches()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if searchName == "" {
+			if searchName, err = pickSearch(searches); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+		search, ok := searches[searchName]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown search %q, configured searches: %s\n", searchName, strings.Join(searchNames(searches), ", "))
+			os.Exit(1)
+		}
+		if len(profiles) == 0 {
+			if profiles, err = expandProfiles(search.Profiles); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+		if len(search.Regions) > 0 && !pflag.CommandLine.Changed("region") {
+			regions = search.Regions
 		}
+		filters = append(search.Filters, filters...)
+		query = search.Query
+	}
+
+	contextName := ""
+	if len(profiles) == 0 {
+		if name := currentContext(); name != "" {
+			context, ok := contexts[name]
+			if !ok {
+				fmt.Fprintf(os.Stderr, "Warning: current context %q isn't configured, run 'ec2-ssh use' to pick another one\n", name)
+			} else {
+				contextName = name
+				profiles = context.Profiles
+				if len(context.Regions) > 0 && !pflag.CommandLine.Changed("region") {
+					regions = context.Regions
+				}
+				filters = append(context.Filters, filters...)
+			}
+		}
+	}
+
+	// A pinned instance brings its profile and region, and skips the finder
+	instanceIds := viper.GetStringSlice("instance")
+	pinned := viper.GetBool("pinned")
+	if pinned {
+		pin, err := loadPin()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		profiles = nil
+		if pin.Profile != "" {
+			profiles = []string{pin.Profile}
+		}
+		regions = []string{pin.Region}
+		instanceIds = []string{pin.Target}
+	}
+
+	// Auto-detect region from profiles if not specified
+	profileRegions := make(map[string][]string)
+	if len(regions) == 1 && regions[0] == "us-east-1" && !pinned {
+		for _, profile := range profiles {
+			if detectedRegion := getRegionFromProfile(profile); detectedRegion != "" {
+				profileRegions[profile] = []string{detectedRegion}
+			}
+		}
+	}
+
+	var tunnels []TunnelConfig
+	if err := viper.UnmarshalKey("tunnels", &tunnels); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid tunnels configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	var plugins []PluginConfig
+	if err := viper.UnmarshalKey("plugins", &plugins); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid plugins configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	var guardrails []GuardrailConfig
+	if err := viper.UnmarshalKey("guardrails", &guardrails); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid guardrails configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	var bastions []BastionConfig
+	if err := viper.UnmarshalKey("bastions", &bastions); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid bastions configuration: %v\n", err)
+		os.Exit(1)
 	}
 
 	return Options{
@@ -113,40 +543,227 @@ func ParseOptions() Options {
 		UsePrivateIp:    viper.GetBool("UsePrivateIp"),
 		Template:        viper.GetString("Template"),
 		PreviewTemplate: viper.GetString("PreviewTemplate"),
-		Filters:         viper.GetStringSlice("Filters"),
-		Profile:         profile,
-		PrintOnly:       viper.GetBool("print-only"),
+		PreviewPrefetch: viper.GetInt("preview.prefetch"),
+		Filters:         filters,
+		Membership: MembershipFilters{
+			AutoScalingGroups: viper.GetStringSlice("asg"),
+			TargetGroups:      viper.GetStringSlice("target-group"),
+			LoadBalancers:     viper.GetStringSlice("behind-lb"),
+		},
+		ResourceGroup: viper.GetString("resource-group"),
+		Org: OrgConfig{
+			Enabled:     viper.GetBool("org"),
+			Profile:     viper.GetString("org.profile"),
+			Role:        viper.GetString("org.role"),
+			Concurrency: viper.GetInt("org.concurrency"),
+			Exclude:     viper.GetStringSlice("org.exclude"),
+		},
+		Discovery: viper.GetString("discovery"),
+		ResourceExplorer: ResourceExplorerConfig{
+			View:  viper.GetString("resource_explorer.view"),
+			Query: viper.GetString("resource_explorer.query"),
+		},
+		Profiles:           profiles,
+		Context:            contextName,
+		ProfileRegions:     profileRegions,
+		ShowDuplicates:     viper.GetBool("show-duplicates"),
+		MaxInstances:       viper.GetInt("MaxInstances"),
+		PrintOnly:          viper.GetBool("print-only"),
+		ReadOnly:           viper.GetBool("read-only"),
+		NonCompliant:       viper.GetBool("non-compliant-only"),
+		Connect:            viper.GetString("connect"),
+		MaintenanceWarning: viper.GetDuration("maintenance.warn_within"),
+		Findings: FindingsConfig{
+			Enabled: viper.GetBool("findings.enabled"),
+			Sources: viper.GetStringSlice("findings.sources"),
+		},
 		SSM: SSMConfig{
 			TagKey:   viper.GetString("ssm.tag_key"),
 			TagValue: viper.GetString("ssm.tag_value"),
 			Command:  viper.GetString("ssm.command"),
+			Document: viper.GetString("ssm.document"),
+
+			ShellProfile: viper.GetString("ssm.shell_profile"),
+
+			CommandByPlatform: viper.GetStringMapString("ssm.command_by_platform"),
+		},
+		Logs: LogsConfig{
+			Source:  viper.GetString("logs.source"),
+			Lines:   viper.GetInt("logs.lines"),
+			Command: viper.GetString("logs.command"),
+		},
+		CloudWatchLogs: CloudWatchLogsConfig{
+			LogGroup: viper.GetString("cloudwatch_logs.log_group"),
+			Stream:   viper.GetString("cloudwatch_logs.stream"),
+			Filter:   viper.GetString("cloudwatch_logs.filter"),
+		},
+		Tunnels:       tunnels,
+		Container:     viper.GetBool("container"),
+		Socks:         viper.GetInt("socks"),
+		Sshuttle:      viper.GetBool("sshuttle"),
+		Hibernate:     viper.GetBool("hibernate"),
+		AuthorizeMyIp: viper.GetBool("authorize-my-ip"),
+		Authorize: AuthorizeConfig{
+			SecurityGroup: viper.GetString("authorize_my_ip.security_group"),
+		},
+		PickAddress: viper.GetBool("pick-address"),
+		Verbose:     viper.GetBool("verbose"),
+		Reachability: ReachabilityConfig{
+			Source: viper.GetString("reachability.source"),
+		},
+		Containers: ContainersConfig{
+			CLI: viper.GetString("containers.cli"),
+		},
+		AccountAliases: viper.GetStringMapString("account_aliases"),
+		Revision: RevisionConfig{
+			Tag:         viper.GetString("revision.tag"),
+			Parameter:   viper.GetString("revision.parameter"),
+			Application: viper.GetString("revision.codedeploy_application"),
+		},
+		Plugins: plugins,
+		Teleport: TeleportConfig{
+			Tag:     viper.GetString("teleport.tag"),
+			Node:    viper.GetString("teleport.node"),
+			User:    viper.GetString("teleport.user"),
+			Proxy:   viper.GetString("teleport.proxy"),
+			Cluster: viper.GetString("teleport.cluster"),
+		},
+		RightSizing: RightSizingConfig{
+			Enabled: viper.GetBool("rightsizing.enabled"),
+			Low:     viper.GetFloat64("rightsizing.low"),
+			High:    viper.GetFloat64("rightsizing.high"),
+		},
+		RegionOrder: RegionOrderConfig{
+			Home:    viper.GetString("region_order.home"),
+			Latency: viper.GetBool("region_order.latency"),
+		},
+		Tailscale: TailscaleConfig{
+			Enabled: viper.GetBool("tailscale.enabled"),
+			Tag:     viper.GetString("tailscale.tag"),
+		},
+		Shell: ShellConfig{
+			Enabled: viper.GetBool("shell.enabled"),
+			Timeout: viper.GetDuration("shell.timeout"),
+		},
+		SearchFields: viper.GetStringSlice("SearchFields"),
+		PickBy:       viper.GetString("PickBy"),
+		Retry: RetryConfig{
+			MaxAttempts: viper.GetInt("retry.max_attempts"),
+			Mode:        viper.GetString("retry.mode"),
+			MaxBackoff:  viper.GetDuration("retry.max_backoff"),
+		},
+		EndpointUrl: viper.GetString("EndpointUrl"),
+		Endpoints: EndpointsConfig{
+			EC2: viper.GetString("endpoints.ec2"),
+			SSM: viper.GetString("endpoints.ssm"),
+			IAM: viper.GetString("endpoints.iam"),
+		},
+		HTTP: HTTPConfig{
+			Proxy:    viper.GetString("http.proxy"),
+			NoProxy:  viper.GetString("http.no_proxy"),
+			CABundle: viper.GetString("http.ca_bundle"),
 		},
+		UpdateCheck: viper.GetBool("UpdateCheck"),
+		Query:       query,
+		Output:      viper.GetString("output"),
+		InstanceIds: instanceIds,
+		Cache: CacheConfig{
+			TTL:     viper.GetDuration("cache.ttl"),
+			Refresh: viper.GetBool("refresh"),
+		},
+		Daemon: DaemonConfig{
+			Refresh: viper.GetDuration("daemon.refresh"),
+			HTTP:    viper.GetString("daemon.http"),
+		},
+		Tracing: TracingConfig{
+			Exporter: viper.GetString("tracing.exporter"),
+			Endpoint: viper.GetString("tracing.endpoint"),
+		},
+		As:         viper.GetString("as"),
+		Env:        readEnvConfig(),
+		Guardrails: guardrails,
+		Bastions:   bastions,
+		Selection: SelectionConfig{
+			ConfirmAbove:   viper.GetInt("selection.confirm_above"),
+			EnvironmentTag: viper.GetString("selection.environment_tag"),
+			Reverse:        viper.GetBool("reverse"),
+		},
+		SSHKey: SSHKeyConfig{
+			Command:            viper.GetString("ssh_key.command"),
+			CertificateCommand: viper.GetString("ssh_key.certificate_command"),
+			Mode:               viper.GetString("ssh_key.mode"),
+			Lifetime:           viper.GetDuration("ssh_key.lifetime"),
+		},
+		SSHCertificate: SSHCertificateConfig{
+			Command:   viper.GetString("ssh_certificate.command"),
+			PublicKey: viper.GetString("ssh_certificate.public_key"),
+		},
+		Fallback: FallbackConfig{
+			Mode:   viper.GetString("fallback.mode"),
+			Within: viper.GetDuration("fallback.within"),
+		},
+		CredentialHelpers: readCredentialHelpers(),
+		MFA: MFAConfig{
+			Serial:  viper.GetString("mfa.serial"),
+			Command: viper.GetString("mfa.command"),
+		},
+		Session: SessionConfig{
+			MaxDuration: viper.GetDuration("session.max_duration"),
+			IdleTimeout: viper.GetDuration("session.idle_timeout"),
+		},
+		Multiplex: MultiplexConfig{
+			Enabled:   viper.GetBool("multiplex.enabled"),
+			Persist:   viper.GetDuration("multiplex.persist"),
+			Reuse:     viper.GetBool("reuse"),
+			JumpHosts: viper.GetBool("multiplex.jump_hosts"),
+		},
+		Panes: PanesConfig{
+			Prompt: viper.GetBool("panes.prompt"),
+		},
+		Fleet: FleetConfig{
+			Command:     viper.GetString("command"),
+			Concurrency: viper.GetInt("fleet.concurrency"),
+			OutputDir:   viper.GetString("output-dir"),
+			Canary:      viper.GetInt("canary"),
+			MaxFailures: viper.GetInt("max-failures"),
+		},
+		Webhook: WebhookConfig{
+			URL:    viper.GetString("webhook.url"),
+			Format: viper.GetString("webhook.format"),
+		},
+		Snapshot:     viper.GetString("snapshot"),
+		FromSnapshot: viper.GetString("from-snapshot"),
+		Ansible: AnsibleConfig{
+			GroupBy: viper.GetString("ansible.group_by"),
+		},
+		Hosts: HostsConfig{
+			Format: viper.GetString("hosts.format"),
+			File:   viper.GetString("hosts.file"),
+			Tag:    viper.GetString("hosts.tag"),
+			Domain: viper.GetString("hosts.domain"),
+		},
+		Command:     command,
+		CommandArgs: commandArgs,
 	}
 }
 
-// printProfileCompletion prints a complete bash completion script
-func printProfileCompletion() {
-	fmt.Print(`#!/bin/bash
-
-# Bash completion for ec2-ssh
-_ec2_ssh_completion() {
-    local cur="${COMP_WORDS[COMP_CWORD]}"
-    local prev="${COMP_WORDS[COMP_CWORD-1]}"
-    
-    # If we're completing the first argument (profile)
-    if [[ ${COMP_CWORD} -eq 1 ]]; then
-        local profiles
-        profiles=$(ec2-ssh --completion-list 2>/dev/null)
-        COMPREPLY=($(compgen -W "$profiles" -- "$cur"))
-    fi
+// readConfig reads the config file, if any
+func readConfig() {
+	viper.SetConfigName("config")
+	viper.SetConfigType("toml")
+	viper.AddConfigPath("$HOME/.config/ec2-ssh")
+	if err := viper.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+			// Config file not found; ignore error if desired
+		} else {
+			panic(err)
+		}
+	}
 }
 
-# Register completion for ec2-ssh
-complete -F _ec2_ssh_completion ec2-ssh
-
-# If you want to use 's' as an alias, uncomment this line:
-# complete -F _ec2_ssh_completion s
-`)
+// printProfileCompletion prints a complete bash completion script
+func printProfileCompletion() {
+	fmt.Print(bashCompletion)
 }
 
 // getAWSProfiles extracts profile names from AWS config file
@@ -208,6 +825,41 @@ func getRegionFromProfile(profile string) string {
 	return ""
 }
 
+// expandProfiles expands glob patterns such as 'dev-*' over the profiles of
+// the AWS config file, other names are kept as is
+func expandProfiles(patterns []string) ([]string, error) {
+	var profiles []string
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		if !strings.ContainsAny(pattern, "*?[") {
+			if !seen[pattern] {
+				seen[pattern] = true
+				profiles = append(profiles, pattern)
+			}
+			continue
+		}
+
+		matched := false
+		for _, profile := range getAWSProfiles() {
+			ok, err := filepath.Match(pattern, profile)
+			if err != nil {
+				return nil, fmt.Errorf("invalid profile pattern %q: %w", pattern, err)
+			}
+			if ok {
+				matched = true
+				if !seen[profile] {
+					seen[profile] = true
+					profiles = append(profiles, profile)
+				}
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("no profile matches %q, available profiles: %s", pattern, formatProfiles(getAWSProfiles()))
+		}
+	}
+	return profiles, nil
+}
+
 // formatProfiles formats a list of profiles for display
 func formatProfiles(profiles []string) string {
 	if len(profiles) == 0 {
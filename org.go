@@ -0,0 +1,222 @@
+package ec2ssh
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/cloudtrail"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/codedeploy"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2instanceconnect"
+	elb "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go-v2/service/guardduty"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/inspector2"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	orgtypes "github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	re "github.com/aws/aws-sdk-go-v2/service/resourceexplorer2"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroups"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// defaultOrgConcurrency is the number of accounts listed at once in org mode
+const defaultOrgConcurrency = 8
+
+// OrgConfig configures the discovery of instances across the accounts of an
+// AWS Organization, by assuming a role in every member account
+type OrgConfig struct {
+	Enabled     bool
+	Profile     string   // management profile, the positional profile wins
+	Role        string   // role name assumed in every member account
+	Concurrency int      // accounts listed at once
+	Exclude     []string // account ids left out
+}
+
+// orgAccount is an active account of the organization
+type orgAccount struct {
+	Id   string
+	Name string
+}
+
+// newOrgClients creates the clients for every active account of the
+// organization and region. The accounts are listed with the management
+// profile, which is used as is for the management account, a role is assumed
+// in the other ones.
+func newOrgClients(options Options, httpClient *awshttp.BuildableClient) ([]*awsClients, error) {
+	profile := options.Org.Profile
+	if len(options.Profiles) > 0 {
+		profile = options.Profiles[0]
+	}
+	regions := options.Regions
+	if detected, ok := options.ProfileRegions[profile]; ok {
+		regions = detected
+	}
+	regions = orderRegions(options, regions)
+
+	opts := loadOptions(options, profile, regions[0])
+	if httpClient != nil {
+		opts = append(opts, config.WithHTTPClient(httpClient))
+	}
+	managementCfg, err := config.LoadDefaultConfig(context.TODO(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	// Roles requiring MFA are assumed with an MFA session, so a single code
+	// is needed for every account
+	if options.MFA.Serial != "" {
+		serial, err := resolveMFASerial(context.TODO(), managementCfg, options.MFA.Serial)
+		if err != nil {
+			return nil, err
+		}
+		managementCfg.Credentials = aws.NewCredentialsCache(&sessionTokenProvider{
+			client: sts.NewFromConfig(managementCfg, func(o *sts.Options) {
+				o.BaseEndpoint = endpoint(options, "sts")
+			}),
+			serial: serial,
+			token:  mfaTokenProvider(options.MFA),
+		})
+	}
+
+	managementId, accounts, err := listOrgAccounts(context.TODO(), options, managementCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	excluded := make(map[string]bool)
+	for _, id := range options.Org.Exclude {
+		excluded[id] = true
+	}
+
+	stsClient := sts.NewFromConfig(managementCfg, func(o *sts.Options) {
+		o.BaseEndpoint = endpoint(options, "sts")
+	})
+
+	clients := make([]*awsClients, 0)
+	for _, account := range accounts {
+		if excluded[account.Id] {
+			continue
+		}
+
+		var credentials aws.CredentialsProvider
+		if account.Id != managementId {
+			roleArn := fmt.Sprintf("arn:%s:iam::%s:role/%s", partition(regions[0]), account.Id, options.Org.Role)
+			credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(stsClient, roleArn, func(o *stscreds.AssumeRoleOptions) {
+				o.RoleSessionName = "ec2-ssh"
+			}))
+		}
+
+		var iamClient *iam.Client
+		for _, region := range regions {
+			cfg := managementCfg.Copy()
+			cfg.Region = region
+			if credentials != nil {
+				cfg.Credentials = credentials
+			}
+
+			if iamClient == nil {
+				iamClient = iam.NewFromConfig(cfg, func(o *iam.Options) {
+					o.BaseEndpoint = endpoint(options, "iam")
+				})
+			}
+
+			c := &awsClients{
+				Profile:     profile,
+				Region:      region,
+				Account:     account.Id,
+				AccountName: account.Name,
+				EC2: ec2.NewFromConfig(cfg, func(o *ec2.Options) {
+					o.BaseEndpoint = endpoint(options, "ec2")
+				}),
+				SSM: ssm.NewFromConfig(cfg, func(o *ssm.Options) {
+					o.BaseEndpoint = endpoint(options, "ssm")
+				}),
+				IAM: iamClient,
+				AutoScaling: autoscaling.NewFromConfig(cfg, func(o *autoscaling.Options) {
+					o.BaseEndpoint = endpoint(options, "autoscaling")
+				}),
+				ELB: elb.NewFromConfig(cfg, func(o *elb.Options) {
+					o.BaseEndpoint = endpoint(options, "elasticloadbalancing")
+				}),
+				InstanceConnect: ec2instanceconnect.NewFromConfig(cfg, func(o *ec2instanceconnect.Options) {
+					o.BaseEndpoint = endpoint(options, "ec2-instance-connect")
+				}),
+				ResourceExplorer: re.NewFromConfig(cfg, func(o *re.Options) {
+					o.BaseEndpoint = endpoint(options, "resource-explorer-2")
+				}),
+				CloudTrail: cloudtrail.NewFromConfig(cfg, func(o *cloudtrail.Options) {
+					o.BaseEndpoint = endpoint(options, "cloudtrail")
+				}),
+				CodeDeploy: codedeploy.NewFromConfig(cfg, func(o *codedeploy.Options) {
+					o.BaseEndpoint = endpoint(options, "codedeploy")
+				}),
+				GuardDuty: guardduty.NewFromConfig(cfg, func(o *guardduty.Options) {
+					o.BaseEndpoint = endpoint(options, "guardduty")
+				}),
+				Inspector: inspector2.NewFromConfig(cfg, func(o *inspector2.Options) {
+					o.BaseEndpoint = endpoint(options, "inspector2")
+				}),
+				CloudWatchLogs: cloudwatchlogs.NewFromConfig(cfg, func(o *cloudwatchlogs.Options) {
+					o.BaseEndpoint = endpoint(options, "logs")
+				}),
+				CloudWatch: cloudwatch.NewFromConfig(cfg, func(o *cloudwatch.Options) {
+					o.BaseEndpoint = endpoint(options, "monitoring")
+				}),
+				STS: sts.NewFromConfig(cfg, func(o *sts.Options) {
+					o.BaseEndpoint = endpoint(options, "sts")
+				}),
+				ResourceGroups: resourcegroups.NewFromConfig(cfg, func(o *resourcegroups.Options) {
+					o.BaseEndpoint = endpoint(options, "resource-groups")
+				}),
+			}
+			// Member accounts are reached through the assumed role, the
+			// aws CLI gets its credentials through the environment
+			if credentials != nil {
+				c.Profile = ""
+				c.Credentials = credentials
+			}
+			clients = append(clients, c)
+		}
+	}
+	return clients, nil
+}
+
+// listOrgAccounts returns the id of the management account and the active
+// accounts of the organization
+func listOrgAccounts(ctx context.Context, options Options, cfg aws.Config) (string, []orgAccount, error) {
+	client := organizations.NewFromConfig(cfg, func(o *organizations.Options) {
+		o.BaseEndpoint = endpoint(options, "organizations")
+	})
+
+	organization, err := client.DescribeOrganization(ctx, &organizations.DescribeOrganizationInput{})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to describe the organization: %w", err)
+	}
+
+	var accounts []orgAccount
+	paginator := organizations.NewListAccountsPaginator(client, &organizations.ListAccountsInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to list the organization accounts: %w", err)
+		}
+		for _, account := range page.Accounts {
+			if account.Status != orgtypes.AccountStatusActive {
+				continue
+			}
+			accounts = append(accounts, orgAccount{
+				Id:   aws.ToString(account.Id),
+				Name: aws.ToString(account.Name),
+			})
+		}
+	}
+	return aws.ToString(organization.Organization.MasterAccountId), accounts, nil
+}
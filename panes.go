@@ -0,0 +1,32 @@
+package ec2ssh
+
+// PanesConfig configures the xpanes panes opened on several instances
+type PanesConfig struct {
+	Prompt bool // prefix the remote shell prompt with the instance name and id
+}
+
+// paneTitle returns the label identifying an instance in its pane: its Name
+// tag and target id
+func paneTitle(instance *Instance) string {
+	if name := instance.Tags["Name"]; name != "" {
+		return name + " (" + instance.TargetID() + ")"
+	}
+	return instance.TargetID()
+}
+
+// titlePane returns the shell command titling the tmux pane it runs in,
+// shown on the pane border by xpanes -t. Where tmux supports it (3.4+), the
+// remote shell is kept from renaming the pane after its host.
+func titlePane(title string) string {
+	return "tmux select-pane -T " + shellQuote(title) + "; tmux set -p allow-set-title off 2>/dev/null"
+}
+
+// promptShell returns the remote command opening a bash shell reading the
+// same files as a login shell, with its prompt prefixed by a label. Hosts
+// without bash get their usual login shell.
+func promptShell(label string) string {
+	rc := `. /etc/profile
+if [ -f ~/.bash_profile ]; then . ~/.bash_profile; elif [ -f ~/.bashrc ]; then . ~/.bashrc; fi
+PS1=` + shellQuote("["+label+"] ") + `"$PS1"`
+	return "command -v bash >/dev/null && exec bash -c " + shellQuote("exec bash --rcfile <(echo "+shellQuote(rc)+") -i") + ` || exec "$SHELL" -l`
+}
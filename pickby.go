@@ -0,0 +1,91 @@
+package ec2ssh
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	finder "github.com/ktr0731/go-fuzzyfinder"
+)
+
+// untaggedValue stands for the instances without the --pick-by tag
+const untaggedValue = "(untagged)"
+
+// tagValueCount is a distinct value of a tag and the number of instances
+// carrying it
+type tagValueCount struct {
+	Value string
+	Count int
+}
+
+// pickByTag lets the user pick a value of the --pick-by tag first, e.g. a
+// service, and returns the instances carrying that value. This keeps very
+// large accounts navigable.
+func (e *Ec2ssh) pickByTag(instances []Instance) []Instance {
+	key := strings.TrimPrefix(e.options.PickBy, "tag:")
+
+	values := tagValueCounts(instances, key)
+	if len(values) <= 1 {
+		return instances
+	}
+
+	idx, err := finder.Find(values, func(i int) string {
+		return fmt.Sprintf("%s (%d)", values[i].Value, values[i].Count)
+	}, finder.WithPromptString(key+"> "))
+	if err != nil {
+		if errors.Is(err, finder.ErrAbort) {
+			e.exit(1)
+		}
+		panic(err)
+	}
+
+	matching := make([]Instance, 0, values[idx].Count)
+	for _, instance := range instances {
+		if tagValue(&instance, key) == values[idx].Value {
+			matching = append(matching, instance)
+		}
+	}
+	return matching
+}
+
+// tagValueCounts returns the distinct values of a tag over the instances,
+// the most common first
+func tagValueCounts(instances []Instance, key string) []tagValueCount {
+	counts := make(map[string]int)
+	for i := range instances {
+		counts[tagValue(&instances[i], key)]++
+	}
+
+	values := make([]tagValueCount, 0, len(counts))
+	for value, count := range counts {
+		values = append(values, tagValueCount{Value: value, Count: count})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Count != values[j].Count {
+			return values[i].Count > values[j].Count
+		}
+		return values[i].Value < values[j].Value
+	})
+	return values
+}
+
+// tagValue returns the value of a tag of the instance, untaggedValue if the
+// instance doesn't have it
+func tagValue(instance *Instance, key string) string {
+	if value, ok := instance.Tags[key]; ok && value != "" {
+		return value
+	}
+	return untaggedValue
+}
+
+// validatePickBy checks --pick-by names a tag
+func validatePickBy(pickBy string) error {
+	if pickBy == "" {
+		return nil
+	}
+	if !strings.HasPrefix(pickBy, "tag:") || pickBy == "tag:" {
+		return fmt.Errorf("invalid --pick-by %q, expected tag:<key>, e.g. tag:Service", pickBy)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package ec2ssh
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// sessionVars are the environment variables identifying a terminal session,
+// in order of preference. EC2SSH_SESSION lets users name their own.
+var sessionVars = []string{"EC2SSH_SESSION", "TMUX_PANE", "WEZTERM_PANE", "KITTY_WINDOW_ID", "WT_SESSION", "ITERM_SESSION_ID", "TERM_SESSION_ID", "STY"}
+
+// pinTTL is how long pins last. Sessions told apart by the parent shell's pid
+// leave a pin behind whenever the shell exits, so old pins are removed.
+const pinTTL = 24 * time.Hour
+
+// Pin is the instance pinned in a terminal session
+type Pin struct {
+	Target  string // target id
+	Name    string
+	Profile string
+	Region  string
+	Pinned  time.Time
+}
+
+// terminalSession identifies the terminal session ec2-ssh runs in, falling
+// back on the shell it was started from
+func terminalSession() string {
+	for _, name := range sessionVars {
+		if value := os.Getenv(name); value != "" {
+			// Pane ids are only unique within a tmux server
+			if name == "TMUX_PANE" {
+				value = os.Getenv("TMUX") + value
+			}
+			return name + "=" + value
+		}
+	}
+	return "ppid=" + strconv.Itoa(os.Getppid())
+}
+
+// pinsDir holds the pins of every terminal session
+func pinsDir() string {
+	return filepath.Join(cacheDir(), "pins")
+}
+
+// pinPath returns the file holding the pin of the terminal session
+func pinPath() string {
+	sum := sha256.Sum256([]byte(terminalSession()))
+	return filepath.Join(pinsDir(), hex.EncodeToString(sum[:8])+".json")
+}
+
+// prunePins removes the pins older than pinTTL, of any terminal session
+func prunePins() {
+	entries, err := os.ReadDir(pinsDir())
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > pinTTL {
+			os.Remove(filepath.Join(pinsDir(), entry.Name()))
+		}
+	}
+}
+
+// loadPin reads the pin of the terminal session
+func loadPin() (*Pin, error) {
+	data, err := os.ReadFile(pinPath())
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no instance pinned in this terminal, pin one with 'ec2-ssh pin' first")
+	}
+	if err != nil {
+		return nil, err
+	}
+	var pin Pin
+	if err := json.Unmarshal(data, &pin); err != nil {
+		return nil, fmt.Errorf("invalid pin file %s: %w", pinPath(), err)
+	}
+	if time.Since(pin.Pinned) > pinTTL {
+		os.Remove(pinPath())
+		return nil, fmt.Errorf("the pin of this terminal expired after %s, pin an instance again with 'ec2-ssh pin'", pinTTL)
+	}
+	return &pin, nil
+}
+
+// pinInstance pins the selected instance in the terminal session, so
+// later runs with --pinned reuse it without picking it again
+func pinInstance(selected []*Instance) error {
+	if len(selected) != 1 {
+		return fmt.Errorf("select a single instance to pin")
+	}
+	instance := selected[0]
+	data, err := json.MarshalIndent(Pin{
+		Target:  instance.TargetID(),
+		Name:    instance.Tags["Name"],
+		Profile: instance.Profile,
+		Region:  instance.Region,
+		Pinned:  time.Now(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	prunePins()
+	if err := os.MkdirAll(pinsDir(), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(pinPath(), data, 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("Pinned %s (%s) in this terminal, use --pinned to reuse it\n", instance.InstanceId, instance.Tags["Name"])
+	return nil
+}
+
+// runUnpinCommand removes the pin of the terminal session, and the expired
+// pins of the others
+func runUnpinCommand() error {
+	prunePins()
+	if err := os.Remove(pinPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,320 @@
+package ec2ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	finder "github.com/ktr0731/go-fuzzyfinder"
+)
+
+// Built-in connection methods, custom ones are added with RegisterConnector
+const (
+	MethodSSH = "ssh"
+	MethodSSM = "ssm"
+)
+
+// overrideTagPrefix prefixes the instance tags letting infra owners override
+// how their instances are reached, e.g. ec2ssh:connect=ssm,
+// ec2ssh:user=admin or ec2ssh:port=2222
+const overrideTagPrefix = "ec2ssh:"
+
+// ConnectionPlan describes how to connect to an instance
+type ConnectionPlan struct {
+	Instance *Instance
+	Method   string
+	Host     string
+	User     string
+	Port     string
+	Options  []string // ssh -o options
+	Env      []string // NAME=value set in the session
+	LoginAs  string   // user the login shell is opened as, with sudo
+	Command  string   // run instead of a login shell when set
+	Prompt   string   // label prefixed to the prompt of the login shell
+	Reasons  []string // how the method and host were decided, in order
+
+	fallback bool  // retrying a failed connection, which isn't retried again
+	refused  error // set when a guardrail forbids connecting
+}
+
+// PlanConnection decides how to connect to an instance, from the SSM
+// configuration, the addressing options and the instance's override tags
+func (e *Ec2ssh) PlanConnection(instance *Instance) *ConnectionPlan {
+	return e.planConnection(instance, "")
+}
+
+// planConnection plans a connection to an instance, with the given method
+// when set instead of the one the configuration and tags decide
+func (e *Ec2ssh) planConnection(instance *Instance, method string) *ConnectionPlan {
+	plan := &ConnectionPlan{
+		Instance: instance,
+		Method:   MethodSSH,
+		Host:     e.sshHost(instance),
+	}
+	plan.because(e.addressReason(instance))
+
+	if e.options.SSM.TagKey != "" {
+		if e.shouldUseSSM(instance) {
+			plan.Method = MethodSSM
+			plan.because("tag %s set", e.options.SSM.TagKey)
+		} else {
+			plan.because("tag %s absent", e.options.SSM.TagKey)
+		}
+	}
+	if teleport.usesTeleport(instance) {
+		plan.Method = MethodTeleport
+		plan.because("tag %s set", teleport.config.Tag)
+	}
+	if tailscale.address(instance) != "" {
+		plan.Method = MethodTailscale
+		plan.because("on the tailnet")
+	}
+
+	if tagged := instance.Tags[overrideTagPrefix+"connect"]; knownMethod(tagged) {
+		plan.Method = tagged
+		plan.because("tag %sconnect=%s", overrideTagPrefix, tagged)
+	} else if tagged != "" {
+		plan.because("tag %sconnect=%s ignored, unknown method", overrideTagPrefix, tagged)
+	} else {
+		plan.because("tag %sconnect absent", overrideTagPrefix)
+	}
+	if e.options.Connect != "" {
+		plan.Method = e.options.Connect
+		plan.because("--connect %s", e.options.Connect)
+	}
+	if method != "" {
+		plan.Method = method
+		plan.because("retrying via %s", method)
+	}
+	if plan.Method == MethodTailscale {
+		if address := tailscale.address(instance); address != "" {
+			plan.Host = address
+			plan.because("tailnet address %s", address)
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: %s isn't on the tailnet, connecting to %s\n", instance.InstanceId, plan.Host)
+			plan.because("not on the tailnet")
+		}
+	}
+	plan.User = instance.Tags[overrideTagPrefix+"user"]
+	plan.Port = instance.Tags[overrideTagPrefix+"port"]
+	if plan.User != "" {
+		plan.because("tag %suser=%s", overrideTagPrefix, plan.User)
+	}
+	if plan.Port != "" {
+		plan.because("tag %sport=%s", overrideTagPrefix, plan.Port)
+	}
+
+	// Windows instances have no sudo
+	if instance.OSFamily() != "windows" {
+		plan.LoginAs = instance.Tags[overrideTagPrefix+"login-as"]
+		if e.options.As != "" {
+			plan.LoginAs = e.options.As
+		}
+	}
+
+	// Instances with several interfaces can say which one to connect
+	// through, by device index or ENI id
+	if plan.Method == MethodSSH && e.options.UsePrivateIp {
+		if selector := instance.Tags[overrideTagPrefix+"interface"]; selector != "" {
+			for _, eni := range instance.NetworkInterfaces {
+				if (selector == eni.NetworkInterfaceId || selector == strconv.Itoa(int(eni.DeviceIndex))) && len(eni.PrivateIpAddresses) > 0 {
+					plan.Host = eni.PrivateIpAddresses[0]
+					plan.because("tag %sinterface=%s", overrideTagPrefix, selector)
+				}
+			}
+		}
+	}
+
+	// Instances without a public address are reached privately, through
+	// their bastion if they have one
+	var bastion *BastionConfig
+	if plan.Method == MethodSSH {
+		bastion = e.bastionFor(instance)
+	}
+	if plan.Method == MethodSSH && plan.Host == "" && bastion == nil {
+		e.addressPrivately(plan)
+	}
+
+	if plan.Method == MethodSSH {
+		plan.Options = e.multiplexOptions(plan)
+		// Instances behind a bastion are reached on their private address
+		// from within the VPC
+		if bastion != nil {
+			if !e.options.UsePrivateIp && instance.PrivateIpAddress != "" {
+				plan.Host = instance.PrivateIpAddress
+			}
+			plan.Options = append(plan.Options, "ProxyJump="+bastion.Host)
+			plan.because("bastion %s", bastion.Name)
+		}
+		// Unreachable hosts fail fast enough to be retried via SSM
+		if e.options.Fallback.Mode == FallbackAuto {
+			plan.Options = append(plan.Options, fmt.Sprintf("ConnectTimeout=%d", fallbackConnectTimeout/time.Second))
+		}
+	}
+
+	// Windows SSM sessions run PowerShell, which env can't wrap
+	if plan.Method == MethodSSH || plan.Method == MethodTailscale || instance.OSFamily() != "windows" {
+		plan.Env = e.sessionEnv(instance)
+	}
+
+	// Guarded instances are only planned once confirmed with checkGuardrail,
+	// whatever path asked for the plan
+	if g := e.guardrail(instance); g != nil && !e.guardrailConfirmed[instance.TargetID()] {
+		plan.refused = fmt.Errorf("%s is protected by a guardrail", instance.InstanceId)
+		plan.because("guardrail %s", g.Action)
+	}
+
+	return plan
+}
+
+// because records a step of the planner's decision
+func (p *ConnectionPlan) because(format string, args ...any) {
+	p.Reasons = append(p.Reasons, fmt.Sprintf(format, args...))
+}
+
+// Explain returns the planner's decision trail, e.g. "no public IP, tag
+// SSM set, tag ec2ssh:connect absent → ssm"
+func (p *ConnectionPlan) Explain() string {
+	return strings.Join(p.Reasons, ", ") + " → " + p.Method
+}
+
+// addressReason tells where the ssh address of an instance comes from
+func (e *Ec2ssh) addressReason(instance *Instance) string {
+	switch {
+	case e.options.UsePrivateIp && instance.PrivateIpAddress != "":
+		return "private IP"
+	case e.options.UsePrivateIp:
+		return "no private IP"
+	case instance.PublicDnsName != "":
+		return "public DNS name"
+	case instance.PublicIpAddress != "":
+		return "public IP"
+	default:
+		return "no public IP"
+	}
+}
+
+// pickAddress lets the user pick the private address to connect to among
+// those of every interface of the instance, when it has several
+func (e *Ec2ssh) pickAddress(plan *ConnectionPlan) error {
+	type address struct {
+		ip, eni string
+	}
+
+	var addresses []address
+	for _, eni := range plan.Instance.NetworkInterfaces {
+		for _, ip := range eni.PrivateIpAddresses {
+			addresses = append(addresses, address{
+				ip:  ip,
+				eni: fmt.Sprintf("%s, device %d, %s %s", eni.NetworkInterfaceId, eni.DeviceIndex, eni.SubnetId, eni.Description),
+			})
+		}
+	}
+	if len(addresses) < 2 {
+		return nil
+	}
+
+	idx, err := finder.Find(addresses, func(i int) string {
+		return fmt.Sprintf("%s (%s)", addresses[i].ip, addresses[i].eni)
+	}, finder.WithPromptString(plan.Instance.InstanceId+" address> "))
+	if err != nil {
+		if errors.Is(err, finder.ErrAbort) {
+			e.exit(1)
+		}
+		return err
+	}
+	plan.Host = addresses[idx].ip
+	return nil
+}
+
+// Valid reports whether the plan has everything needed to connect
+func (p *ConnectionPlan) Valid() bool {
+	if p.refused != nil {
+		return false
+	}
+	_, custom := connectorFor(p.Method)
+	return custom || p.Method == MethodSSM || p.Host != ""
+}
+
+// sshArgs returns the ssh arguments reaching the planned host
+func (p *ConnectionPlan) sshArgs() []string {
+	var args []string
+	if p.Port != "" {
+		args = append(args, "-p", p.Port)
+	}
+	for _, option := range p.Options {
+		args = append(args, "-o", option)
+	}
+	if len(p.Env) > 0 {
+		args = append(args, "-o", setEnvOption(p.Env))
+	}
+	destination := p.Host
+	if p.User != "" {
+		destination = p.User + "@" + p.Host
+	}
+	return append(args, destination)
+}
+
+// command returns the command line connecting interactively as planned
+func (e *Ec2ssh) command(plan *ConnectionPlan) []string {
+	if connector, ok := connectorFor(plan.Method); ok {
+		return connector.Command(plan)
+	}
+	if plan.Method == MethodSSM {
+		if plan.Command != "" {
+			return append([]string{"aws"}, e.ssmCommandArgs(plan.Instance.InstanceId, cliProfile(plan.Instance), withEnv(plan.Env, plan.Command))...)
+		}
+		return append([]string{"aws"}, e.ssmSessionArgs(plan)...)
+	}
+	return sshCommand(plan)
+}
+
+// sshCommand returns the ssh command line opening a session on the planned
+// host
+func sshCommand(plan *ConnectionPlan) []string {
+	if plan.Command != "" {
+		return append(append([]string{"ssh", "-t"}, plan.sshArgs()...), plan.Command)
+	}
+	if plan.LoginAs != "" {
+		return append(append([]string{"ssh", "-t"}, plan.sshArgs()...), loginAsCommand(plan.LoginAs))
+	}
+	if plan.Prompt != "" {
+		return append(append([]string{"ssh", "-t"}, plan.sshArgs()...), promptShell(plan.Prompt))
+	}
+	return append([]string{"ssh"}, plan.sshArgs()...)
+}
+
+// loginAsCommand returns the command opening a login shell as a user
+func loginAsCommand(user string) string {
+	return "sudo -iu " + shellQuote(user)
+}
+
+// remoteArgs returns the command line running a command on the planned
+// instance without a terminal, its output going to the local stdout
+func (e *Ec2ssh) remoteArgs(plan *ConnectionPlan, command string) []string {
+	if connector, ok := connectorFor(plan.Method); ok {
+		return connector.RemoteCommand(plan, command)
+	}
+	if plan.Method == MethodSSM {
+		return append([]string{"aws"}, e.ssmCommandArgs(plan.Instance.InstanceId, cliProfile(plan.Instance), command)...)
+	}
+	return append(append([]string{"ssh"}, plan.sshArgs()...), command)
+}
+
+// runRemote runs a command on the planned instance and returns its output,
+// through Run Command for SSM instances so it isn't mixed with session
+// messages
+func (e *Ec2ssh) runRemote(plan *ConnectionPlan, command string) (string, error) {
+	if plan.Method == MethodSSM {
+		return runShellScript(context.TODO(), plan.Instance, []string{command})
+	}
+
+	args := e.remoteArgs(plan, command)
+	output, err := exec.Command(args[0], args[1:]...).Output()
+	return string(output), err
+}
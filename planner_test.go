@@ -0,0 +1,93 @@
+package ec2ssh
+
+import (
+	"testing"
+)
+
+func TestPlanConnectionExplain(t *testing.T) {
+	online := &Compliance{PingStatus: "Online"}
+	bastions := []BastionConfig{{Name: "prod", Host: "bastion.example.com", VpcIds: []string{"vpc-prod"}}}
+
+	tests := []struct {
+		name     string
+		options  Options
+		instance Instance
+		want     string
+		host     string
+	}{
+		{
+			name:     "public DNS name",
+			instance: Instance{PublicDnsName: "ec2-1-2-3-4.compute.amazonaws.com", PrivateIpAddress: "10.0.0.1"},
+			want:     "public DNS name, tag ec2ssh:connect absent → ssh",
+			host:     "ec2-1-2-3-4.compute.amazonaws.com",
+		},
+		{
+			name:     "SSM tag set",
+			options:  Options{SSM: SSMConfig{TagKey: "SSM"}},
+			instance: Instance{PublicIpAddress: "1.2.3.4", Tags: map[string]string{"SSM": "true"}},
+			want:     "public IP, tag SSM set, tag ec2ssh:connect absent → ssm",
+		},
+		{
+			name:     "SSM tag absent",
+			options:  Options{SSM: SSMConfig{TagKey: "SSM"}},
+			instance: Instance{PublicIpAddress: "1.2.3.4"},
+			want:     "public IP, tag SSM absent, tag ec2ssh:connect absent → ssh",
+			host:     "1.2.3.4",
+		},
+		{
+			name:     "connect tag",
+			instance: Instance{PublicIpAddress: "1.2.3.4", Tags: map[string]string{"ec2ssh:connect": "ssm"}},
+			want:     "public IP, tag ec2ssh:connect=ssm → ssm",
+		},
+		{
+			name:     "unknown connect tag",
+			instance: Instance{PublicIpAddress: "1.2.3.4", Tags: map[string]string{"ec2ssh:connect": "telnet"}},
+			want:     "public IP, tag ec2ssh:connect=telnet ignored, unknown method → ssh",
+			host:     "1.2.3.4",
+		},
+		{
+			name:     "--connect",
+			options:  Options{Connect: MethodSSM},
+			instance: Instance{PublicIpAddress: "1.2.3.4", Tags: map[string]string{"ec2ssh:connect": "ssh"}},
+			want:     "public IP, tag ec2ssh:connect=ssh, --connect ssm → ssm",
+		},
+		{
+			name:     "no public IP, private IP",
+			instance: Instance{PrivateIpAddress: "10.0.0.1"},
+			want:     "no public IP, tag ec2ssh:connect absent, private IP → ssh",
+			host:     "10.0.0.1",
+		},
+		{
+			name:     "no public IP, SSM agent online",
+			instance: Instance{PrivateIpAddress: "10.0.0.1", compliance: online},
+			want:     "no public IP, tag ec2ssh:connect absent, SSM agent online → ssm",
+		},
+		{
+			name:     "private IP",
+			options:  Options{UsePrivateIp: true},
+			instance: Instance{PublicIpAddress: "1.2.3.4", PrivateIpAddress: "10.0.0.1"},
+			want:     "private IP, tag ec2ssh:connect absent → ssh",
+			host:     "10.0.0.1",
+		},
+		{
+			name:     "bastion",
+			options:  Options{Bastions: bastions},
+			instance: Instance{PrivateIpAddress: "10.0.0.1", VpcId: "vpc-prod"},
+			want:     "no public IP, tag ec2ssh:connect absent, bastion prod → ssh",
+			host:     "10.0.0.1",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := &Ec2ssh{options: test.options}
+			test.instance.InstanceId = "i-0123456789abcdef0"
+			plan := e.PlanConnection(&test.instance)
+			if got := plan.Explain(); got != test.want {
+				t.Errorf("Explain() = %q, want %q", got, test.want)
+			}
+			if plan.Method == MethodSSH && plan.Host != test.host {
+				t.Errorf("Host = %q, want %q", plan.Host, test.host)
+			}
+		})
+	}
+}
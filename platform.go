@@ -0,0 +1,100 @@
+package ec2ssh
+
+import (
+	"strings"
+)
+
+// distributions maps AMI name fragments to the OS family they identify,
+// checked in order
+var distributions = []struct {
+	fragment, family string
+}{
+	{"bottlerocket", "bottlerocket"},
+	{"alpine", "alpine"},
+	{"ubuntu", "ubuntu"},
+	{"debian", "debian"},
+	{"amzn", "amazon"},
+	{"al2023", "amazon"},
+	{"rhel", "rhel"},
+	{"red hat", "rhel"},
+	{"centos", "centos"},
+	{"rocky", "rocky"},
+	{"alma", "alma"},
+	{"suse", "suse"},
+	{"sles", "suse"},
+}
+
+// loginShells are the commands SSM sessions start by default on the OS
+// families without bash: Bottlerocket sessions land in its control
+// container, which hands over to the admin container, and Alpine only has
+// BusyBox sh
+var loginShells = map[string]string{
+	"windows":      "powershell",
+	"bottlerocket": "enter-admin-container",
+	"alpine":       "sh -l",
+}
+
+// portableLoginShell is the command SSM sessions start by default on other
+// instances: a bash login shell, or sh where bash isn't installed
+const portableLoginShell = `sh -c 'command -v bash >/dev/null && exec bash -l || exec sh -l'`
+
+// OSFamily returns the operating system of the instance: the one of its
+// ec2ssh:platform tag, windows, a Linux distribution detected from the AMI
+// name (e.g. ubuntu, amazon, bottlerocket), or linux when the distribution
+// is unknown
+func (i *Instance) OSFamily() string {
+	if family := i.Tags[overrideTagPrefix+"platform"]; family != "" {
+		return strings.ToLower(family)
+	}
+	if i.Platform == "windows" || strings.Contains(i.PlatformDetails, "Windows") {
+		return "windows"
+	}
+
+	name := strings.ToLower(i.ImageName)
+	for _, d := range distributions {
+		if strings.Contains(name, d.fragment) {
+			return d.family
+		}
+	}
+	if strings.Contains(i.PlatformDetails, "Red Hat") {
+		return "rhel"
+	}
+	if strings.Contains(i.PlatformDetails, "SUSE") {
+		return "suse"
+	}
+	return "linux"
+}
+
+// ssmShell returns the command SSM sessions start on an instance: the one
+// configured for its OS family in ssm.command_by_platform, the "linux" one
+// for any Linux distribution, ssm.command, or a login shell that works on
+// its OS family
+func (e *Ec2ssh) ssmShell(instance *Instance) string {
+	byPlatform := e.options.SSM.CommandByPlatform
+	if len(byPlatform) == 0 && e.options.SSM.Command != "" {
+		return e.options.SSM.Command
+	}
+
+	// Distributions are told apart by the AMI name, which is only listed
+	// with the ami-name search field
+	if instance.ImageName == "" && instance.clients != nil && instance.Tags[overrideTagPrefix+"platform"] == "" && instance.OSFamily() != "windows" {
+		instances := []Instance{*instance}
+		resolveImageNames(instance.clients.EC2, instances)
+		instance.ImageName = instances[0].ImageName
+	}
+
+	family := instance.OSFamily()
+	if command, ok := byPlatform[family]; ok {
+		return command
+	}
+	if command, ok := byPlatform["linux"]; ok && family != "windows" {
+		return command
+	}
+	if e.options.SSM.Command != "" {
+		return e.options.SSM.Command
+	}
+	if command, ok := loginShells[family]; ok {
+		return command
+	}
+	return portableLoginShell
+}
@@ -0,0 +1,132 @@
+package ec2ssh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultPluginTimeout bounds plugins without their own timeout
+const defaultPluginTimeout = 2 * time.Second
+
+// PluginConfig configures a preview plugin: a command reading an instance as
+// JSON on its stdin and printing extra fields on its stdout, either as a JSON
+// object or as key=value lines
+type PluginConfig struct {
+	Name    string        `mapstructure:"name"`
+	Command string        `mapstructure:"command"`
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// validatePlugins checks every plugin has a unique name and a command
+func validatePlugins(plugins []PluginConfig) error {
+	names := make(map[string]bool)
+	for _, p := range plugins {
+		if p.Name == "" || p.Command == "" {
+			return fmt.Errorf("plugins need a name and a command")
+		}
+		if names[p.Name] {
+			return fmt.Errorf("plugin %q is configured twice", p.Name)
+		}
+		names[p.Name] = true
+	}
+	return nil
+}
+
+// pluginFunc returns the "plugin" template function, which runs a plugin for
+// an instance and returns its fields, e.g.
+// {{ with plugin "cmdb" . }}{{ .owner }}{{ end }}. Fields are kept for the
+// rest of the run, so each plugin runs once per instance.
+func pluginFunc(plugins []PluginConfig) func(name string, i *Instance) (map[string]string, error) {
+	var mu sync.Mutex
+	cached := make(map[string]map[string]string)
+
+	return func(name string, i *Instance) (map[string]string, error) {
+		var plugin *PluginConfig
+		for idx := range plugins {
+			if plugins[idx].Name == name {
+				plugin = &plugins[idx]
+			}
+		}
+		if plugin == nil {
+			return nil, fmt.Errorf("plugin: no plugin named %q is configured", name)
+		}
+
+		key := name + "\x00" + i.TargetID()
+		mu.Lock()
+		fields, ok := cached[key]
+		mu.Unlock()
+		if ok {
+			return fields, nil
+		}
+
+		fields, err := runPlugin(*plugin, i)
+		if err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		cached[key] = fields
+		mu.Unlock()
+		return fields, nil
+	}
+}
+
+// runPlugin runs a plugin with the instance as JSON on its stdin
+func runPlugin(plugin PluginConfig, i *Instance) (map[string]string, error) {
+	input, err := json.Marshal(i)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := plugin.Timeout
+	if timeout <= 0 {
+		timeout = defaultPluginTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", plugin.Command)
+	cmd.Stdin = bytes.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("plugin %s timed out after %s", plugin.Name, timeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s failed: %w: %s", plugin.Name, err, strings.TrimSpace(stderr.String()))
+	}
+	return parsePluginOutput(plugin.Name, output)
+}
+
+// parsePluginOutput reads the fields printed by a plugin, a JSON object of
+// strings or key=value lines
+func parsePluginOutput(name string, output []byte) (map[string]string, error) {
+	fields := make(map[string]string)
+	if trimmed := bytes.TrimSpace(output); bytes.HasPrefix(trimmed, []byte("{")) {
+		if err := json.Unmarshal(trimmed, &fields); err != nil {
+			return nil, fmt.Errorf("plugin %s printed invalid JSON: %w", name, err)
+		}
+		return fields, nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("plugin %s printed %q, expected key=value", name, line)
+		}
+		fields[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return fields, nil
+}
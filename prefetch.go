@@ -0,0 +1,219 @@
+package ec2ssh
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// prefetchBatch is the number of instances described per call when
+// prefetching preview data, within the limits of DescribeInstances and
+// DescribeInstanceStatus
+const prefetchBatch = 100
+
+// defaultPreviewPrefetch is how many instances, from the top of the list,
+// get their preview data prefetched
+const defaultPreviewPrefetch = 1000
+
+// InstanceStatus is the outcome of the EC2 status checks of an instance
+type InstanceStatus struct {
+	System   string // ok, impaired, initializing, insufficient-data or not-applicable
+	Instance string
+}
+
+// imageNames caches AMI names by region and image id, images being shared by
+// many instances
+var imageNames sync.Map
+
+// prefetchPreviews fetches the preview data of the first instances of the
+// list in the background once it's loaded: their full description, status
+// checks and AMI names, in batches of a hundred instances per call, one call
+// at a time per profile and region, and who launched them when the preview
+// shows it. Previews highlighted before their batch came back fetch their
+// own data as before.
+func (e *Ec2ssh) prefetchPreviews(instances []Instance) {
+	if len(instances) > e.options.PreviewPrefetch {
+		instances = instances[:e.options.PreviewPrefetch]
+	}
+
+	grouped := make(map[*awsClients][]*Instance)
+	for i := range instances {
+		if c := instances[i].clients; c != nil {
+			grouped[c] = append(grouped[c], &instances[i])
+		}
+	}
+	launchedBy := strings.Contains(e.options.PreviewTemplate, ".LaunchedBy")
+	for c, group := range grouped {
+		go func(c *awsClients, group []*Instance) {
+			prefetchGroup(c, group)
+			if launchedBy {
+				prefetchLaunches(c, group)
+			}
+		}(c, group)
+	}
+}
+
+// prefetchGroup prefetches the preview data of instances sharing clients.
+// Instances are matched with an instance-id filter, which skips the ids that
+// don't exist anymore, e.g. from the cache or a snapshot, instead of failing
+// the whole batch. Failures are ignored, the previews then fetch their data
+// on demand.
+func prefetchGroup(c *awsClients, instances []*Instance) {
+	for start := 0; start < len(instances); start += prefetchBatch {
+		end := start + prefetchBatch
+		if end > len(instances) {
+			end = len(instances)
+		}
+		batch := make(map[string]*Instance, end-start)
+		ids := make([]string, 0, end-start)
+		for _, instance := range instances[start:end] {
+			batch[instance.InstanceId] = instance
+			ids = append(ids, instance.InstanceId)
+		}
+
+		// DescribeInstanceStatus has no instance-id filter, so it's only
+		// given the ids DescribeInstances found
+		var found []string
+		paginator := ec2.NewDescribeInstancesPaginator(c.EC2, &ec2.DescribeInstancesInput{
+			Filters: []types.Filter{{Name: aws.String("instance-id"), Values: ids}},
+		})
+		for paginator.HasMorePages() {
+			described, err := paginator.NextPage(context.TODO())
+			if err != nil {
+				found = nil
+				break
+			}
+			for _, r := range described.Reservations {
+				for _, i := range r.Instances {
+					if instance, ok := batch[aws.ToString(i.InstanceId)]; ok {
+						instance.detail.setInstance(&i)
+						found = append(found, instance.InstanceId)
+					}
+				}
+			}
+		}
+		if len(found) == 0 {
+			continue
+		}
+
+		statuses, err := c.EC2.DescribeInstanceStatus(context.TODO(), &ec2.DescribeInstanceStatusInput{
+			InstanceIds:         found,
+			IncludeAllInstances: aws.Bool(true),
+		})
+		if err == nil {
+			for _, status := range statuses.InstanceStatuses {
+				if instance, ok := batch[aws.ToString(status.InstanceId)]; ok {
+					instance.detail.setStatus(&status)
+				}
+			}
+		}
+	}
+
+	prefetchImageNames(c, instances)
+}
+
+// prefetchImageNames caches the names of the AMIs of instances sharing
+// clients
+func prefetchImageNames(c *awsClients, instances []*Instance) {
+	var missing []Instance
+	for _, instance := range instances {
+		if _, ok := imageNames.Load(c.Region + "/" + instance.ImageId); !ok && instance.ImageName == "" {
+			missing = append(missing, Instance{ImageId: instance.ImageId})
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+	resolveImageNames(c.EC2, missing)
+	for _, instance := range missing {
+		imageNames.Store(c.Region+"/"+instance.ImageId, instance.ImageName)
+	}
+}
+
+// AMIName returns the name of the instance's AMI, from the listing when the
+// ami-name search field is used, from the prefetched names or by describing
+// the image otherwise, e.g. {{ .AMIName }} in the preview template
+func (i *Instance) AMIName() string {
+	if i.ImageName != "" || i.ImageId == "" || i.clients == nil {
+		return i.ImageName
+	}
+	key := i.clients.Region + "/" + i.ImageId
+	if name, ok := imageNames.Load(key); ok {
+		return name.(string)
+	}
+	instances := []Instance{{ImageId: i.ImageId}}
+	resolveImageNames(i.clients.EC2, instances)
+	imageNames.Store(key, instances[0].ImageName)
+	return instances[0].ImageName
+}
+
+// Status returns the outcome of the status checks of the instance, e.g.
+// {{ with .Status }}{{ .System }}/{{ .Instance }}{{ end }} in the preview
+// template. It's nil for instances without clients, e.g. browsed from a
+// snapshot.
+func (i *Instance) Status() (*InstanceStatus, error) {
+	if i.clients == nil {
+		return nil, nil
+	}
+	status, err := i.instanceStatus()
+	if err != nil {
+		return nil, err
+	}
+	result := &InstanceStatus{System: "not-applicable", Instance: "not-applicable"}
+	if status.SystemStatus != nil {
+		result.System = string(status.SystemStatus.Status)
+	}
+	if status.InstanceStatus != nil {
+		result.Instance = string(status.InstanceStatus.Status)
+	}
+	return result, nil
+}
+
+// instanceStatus returns the DescribeInstanceStatus output for the
+// instance, prefetched or fetched by id on first use
+func (i *Instance) instanceStatus() (*types.InstanceStatus, error) {
+	d := i.detail
+	d.statusOnce.Do(func() {
+		if i.clients == nil {
+			d.statusErr = fmt.Errorf("no client available to describe %s", i.InstanceId)
+			return
+		}
+
+		output, err := i.clients.EC2.DescribeInstanceStatus(context.TODO(), &ec2.DescribeInstanceStatusInput{
+			InstanceIds:         []string{i.InstanceId},
+			IncludeAllInstances: aws.Bool(true),
+		})
+		if err != nil {
+			d.statusErr = fmt.Errorf("failed to describe the status of %s: %w", i.InstanceId, err)
+			return
+		}
+		// Instances without status checks, e.g. terminated ones, aren't
+		// listed
+		d.status = &types.InstanceStatus{}
+		for _, status := range output.InstanceStatuses {
+			d.status = &status
+		}
+	})
+	return d.status, d.statusErr
+}
+
+// setInstance records a prefetched description, unless one was fetched
+// already
+func (d *instanceDetail) setInstance(instance *types.Instance) {
+	d.once.Do(func() {
+		d.instance = instance
+	})
+}
+
+// setStatus records prefetched status checks, unless they were fetched
+// already
+func (d *instanceDetail) setStatus(status *types.InstanceStatus) {
+	d.statusOnce.Do(func() {
+		d.status = status
+	})
+}
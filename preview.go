@@ -0,0 +1,58 @@
+package ec2ssh
+
+import (
+	"sync"
+	"time"
+)
+
+// previewLoading is shown in the preview pane while a preview is rendered in
+// the background
+const previewLoading = "loading…"
+
+// previewWait is how long the finder waits for a preview before falling back
+// to the loading placeholder, so cheap previews never flash it
+const previewWait = 50 * time.Millisecond
+
+// previewCache renders previews asynchronously and remembers them per
+// instance, so slow templates don't block cursoring through the list.
+type previewCache struct {
+	mu      sync.Mutex
+	entries map[int]*previewEntry
+	render  func(i int) string
+}
+
+type previewEntry struct {
+	done   chan struct{}
+	output string
+}
+
+func newPreviewCache(render func(i int) string) *previewCache {
+	return &previewCache{
+		entries: make(map[int]*previewEntry),
+		render:  render,
+	}
+}
+
+// Get returns the preview for instance i, starting its rendering if needed.
+// The loading placeholder is returned if it isn't ready within wait; the
+// rendered preview shows up on the next redraw.
+func (c *previewCache) Get(i int, wait time.Duration) string {
+	c.mu.Lock()
+	entry, ok := c.entries[i]
+	if !ok {
+		entry = &previewEntry{done: make(chan struct{})}
+		c.entries[i] = entry
+		go func() {
+			entry.output = c.render(i)
+			close(entry.done)
+		}()
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-entry.done:
+		return entry.output
+	case <-time.After(wait):
+		return previewLoading
+	}
+}
@@ -0,0 +1,62 @@
+package ec2ssh
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// vpcDNSHostnames caches whether VPCs give public DNS names to their
+// instances, by region and VPC id
+var vpcDNSHostnames sync.Map
+
+// publicDNSDisabled reports whether the VPC of an instance has DNS hostnames
+// disabled, as in accounts enforcing private networking. Failures to tell
+// count as not disabled.
+func publicDNSDisabled(instance *Instance) bool {
+	if instance.clients == nil || instance.VpcId == "" {
+		return false
+	}
+	key := instance.Region + "/" + instance.VpcId
+	if enabled, ok := vpcDNSHostnames.Load(key); ok {
+		return !enabled.(bool)
+	}
+
+	output, err := instance.clients.EC2.DescribeVpcAttribute(context.TODO(), &ec2.DescribeVpcAttributeInput{
+		VpcId:     aws.String(instance.VpcId),
+		Attribute: types.VpcAttributeNameEnableDnsHostnames,
+	})
+	if err != nil || output.EnableDnsHostnames == nil {
+		return false
+	}
+	enabled := aws.ToBool(output.EnableDnsHostnames.Value)
+	vpcDNSHostnames.Store(key, enabled)
+	return !enabled
+}
+
+// addressPrivately plans the connection to an instance without any public
+// address, which ssh couldn't reach, via SSM when its agent is online or on
+// its private IP otherwise, and tells why in one line
+func (e *Ec2ssh) addressPrivately(plan *ConnectionPlan) {
+	instance := plan.Instance
+	why := "has no public IP"
+	if publicDNSDisabled(instance) {
+		why = fmt.Sprintf("has no public IP, and its VPC %s has public DNS hostnames disabled", instance.VpcId)
+	}
+
+	switch {
+	case e.supportsMethod(plan, MethodSSM):
+		plan.Method = MethodSSM
+		plan.because("SSM agent online")
+		fmt.Fprintf(os.Stderr, "%s %s, connecting via SSM\n", instance.InstanceId, why)
+	case instance.PrivateIpAddress != "":
+		plan.Host = instance.PrivateIpAddress
+		plan.because("private IP")
+		fmt.Fprintf(os.Stderr, "%s %s, connecting to its private IP %s (set --use-private-ip to skip this check)\n", instance.InstanceId, why, plan.Host)
+	}
+}
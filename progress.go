@@ -0,0 +1,113 @@
+package ec2ssh
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// progressDelay is how long something runs before its progress is shown, so
+// quick runs, e.g. from the cache, don't flash a status line
+const progressDelay = 300 * time.Millisecond
+
+// progressFrames are the spinner frames drawn before the status
+var progressFrames = []rune("⠋⠙⠹⠸⠼⠴⠦⠧⠇⠏")
+
+// progress draws a status line on stderr, redrawn in place while something
+// long runs, such as listing the instances of a whole organization or
+// running a command on a fleet. Lines printed meanwhile go above it. It
+// draws nothing when stderr isn't a terminal.
+type progress struct {
+	mu       sync.Mutex
+	status   func() string
+	shown    bool
+	frame    int
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// startProgress starts showing the status returned by a function, until
+// Stop is called
+func startProgress(status func() string) *progress {
+	p := &progress{
+		status:  status,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if !isTerminal(os.Stderr) {
+		close(p.stopped)
+		return p
+	}
+	go p.run()
+	return p
+}
+
+// run redraws the status line 10 times a second
+func (p *progress) run() {
+	defer close(p.stopped)
+	select {
+	case <-time.After(progressDelay):
+	case <-p.stop:
+		return
+	}
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		p.mu.Lock()
+		fmt.Fprintf(os.Stderr, "\r\033[K%c %s", progressFrames[p.frame%len(progressFrames)], p.status())
+		p.frame++
+		p.shown = true
+		p.mu.Unlock()
+
+		select {
+		case <-ticker.C:
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// clear erases the status line, p.mu being held
+func (p *progress) clear() {
+	if p.shown {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+		p.shown = false
+	}
+}
+
+// Println prints a line to stdout above the status line
+func (p *progress) Println(line string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	fmt.Println(line)
+}
+
+// Warnf prints a warning to stderr above the status line
+func (p *progress) Warnf(format string, args ...interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	fmt.Fprintf(os.Stderr, "Warning: "+format+"\n", args...)
+}
+
+// Stop erases the status line for good
+func (p *progress) Stop() {
+	p.stopOnce.Do(func() { close(p.stop) })
+	<-p.stopped
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+}
+
+// isTerminal reports whether a file is a terminal that can redraw lines
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0 && os.Getenv("TERM") != "dumb"
+}
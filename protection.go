@@ -0,0 +1,162 @@
+package ec2ssh
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Protection holds the stop and termination protection attributes of an
+// instance
+type Protection struct {
+	Stop        bool
+	Termination bool
+}
+
+// Protection returns the stop and termination protection attributes of the
+// instance, e.g. {{ with .Protection }}{{ .Termination }}{{ end }} in the
+// preview template. It's nil when they can't be fetched, e.g. without
+// ec2:DescribeInstanceAttribute, so the rest of the preview still renders.
+// There's no batch API for instance attributes, so they're fetched once per
+// instance when first highlighted rather than prefetched.
+func (i *Instance) Protection() *Protection {
+	d := i.detail
+	d.protectionOnce.Do(func() {
+		d.protection, _ = i.protection()
+	})
+	return d.protection
+}
+
+// protection fetches the stop and termination protection attributes of the
+// instance
+func (i *Instance) protection() (*Protection, error) {
+	if i.clients == nil {
+		return nil, fmt.Errorf("no client available to describe %s", i.InstanceId)
+	}
+
+	stop, err := i.clients.EC2.DescribeInstanceAttribute(context.TODO(), &ec2.DescribeInstanceAttributeInput{
+		InstanceId: aws.String(i.InstanceId),
+		Attribute:  types.InstanceAttributeNameDisableApiStop,
+	})
+	if err != nil {
+		return nil, err
+	}
+	termination, err := i.clients.EC2.DescribeInstanceAttribute(context.TODO(), &ec2.DescribeInstanceAttributeInput{
+		InstanceId: aws.String(i.InstanceId),
+		Attribute:  types.InstanceAttributeNameDisableApiTermination,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Protection{
+		Stop:        stop.DisableApiStop != nil && aws.ToBool(stop.DisableApiStop.Value),
+		Termination: termination.DisableApiTermination != nil && aws.ToBool(termination.DisableApiTermination.Value),
+	}, nil
+}
+
+// Hibernation reports whether the instance was launched with hibernation
+// enabled, which is required to hibernate it when stopping
+func (i *Instance) Hibernation() (bool, error) {
+	detail, err := i.Detail()
+	if err != nil || detail == nil {
+		return false, err
+	}
+	return detail.HibernationOptions != nil && aws.ToBool(detail.HibernationOptions.Configured), nil
+}
+
+// runStateAction stops or terminates the selected instances after
+// confirmation. Protected instances are reported, and their protection can be
+// lifted on the spot after a separate confirmation.
+func (e *Ec2ssh) runStateAction(instances []*Instance, action string) error {
+	var failed int
+	for _, instance := range instances {
+		if err := e.changeState(instance, action); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%s failed on %d of %d instances", action, failed, len(instances))
+	}
+	return nil
+}
+
+// changeState stops or terminates an instance, dealing with its protection
+func (e *Ec2ssh) changeState(instance *Instance, action string) error {
+	if err := e.checkWritable(action); err != nil {
+		return err
+	}
+	name := instance.InstanceId
+	if tag := instance.Tags["Name"]; tag != "" {
+		name += " (" + tag + ")"
+	}
+
+	protection, err := instance.protection()
+	if err != nil {
+		return fmt.Errorf("failed to check the protection of %s: %w", instance.InstanceId, err)
+	}
+
+	attribute := &types.AttributeBooleanValue{Value: aws.Bool(false)}
+	input := &ec2.ModifyInstanceAttributeInput{InstanceId: aws.String(instance.InstanceId)}
+	protected := false
+	switch action {
+	case "stop":
+		protected = protection.Stop
+		input.DisableApiStop = attribute
+	case "terminate":
+		protected = protection.Termination
+		input.DisableApiTermination = attribute
+	}
+
+	hibernate := false
+	if action == "stop" && e.options.Hibernate {
+		if hibernate, err = instance.Hibernation(); err != nil {
+			return err
+		}
+		if !hibernate {
+			fmt.Printf("%s wasn't launched with hibernation enabled, it will be stopped\n", name)
+		}
+	}
+
+	verb := action
+	if hibernate {
+		verb = "hibernate"
+	}
+	if !confirm(fmt.Sprintf("%s %s?", strings.ToUpper(verb[:1])+verb[1:], name)) {
+		fmt.Printf("Skipping %s\n", name)
+		return nil
+	}
+
+	// Lifted only once the action is confirmed, so declining it never leaves
+	// the instance unprotected
+	if protected {
+		if !confirm(fmt.Sprintf("%s has %s protection enabled, disable it?", name, action)) {
+			fmt.Printf("Skipping %s\n", name)
+			return nil
+		}
+		if _, err := instance.clients.EC2.ModifyInstanceAttribute(context.TODO(), input); err != nil {
+			return fmt.Errorf("failed to disable the %s protection of %s: %w", action, instance.InstanceId, err)
+		}
+	}
+
+	if action == "stop" {
+		_, err = instance.clients.EC2.StopInstances(context.TODO(), &ec2.StopInstancesInput{
+			InstanceIds: []string{instance.InstanceId},
+			Hibernate:   aws.Bool(hibernate),
+		})
+	} else {
+		_, err = instance.clients.EC2.TerminateInstances(context.TODO(), &ec2.TerminateInstancesInput{
+			InstanceIds: []string{instance.InstanceId},
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to %s %s: %w", verb, instance.InstanceId, err)
+	}
+	fmt.Printf("Requested %s of %s\n", verb, name)
+	return nil
+}
@@ -0,0 +1,158 @@
+package ec2ssh
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+)
+
+const (
+	// pushChunkSize is the amount of base64 text sent per Run Command
+	// invocation, well under the SSM parameter size limit
+	pushChunkSize = 32 * 1024
+	// pushMaxSize caps the files pushed through SSM, larger files take too
+	// many round trips and are better staged through S3
+	pushMaxSize = 4 * 1024 * 1024
+	// pushConcurrency is the number of chunks sent at once to an instance
+	pushConcurrency = 8
+)
+
+// pushFile copies a local file to the selected instances through SSM Run
+// Command, for instances that can't be reached over SSH. The file is sent as
+// base64 chunks, then decoded and checksummed on the instance.
+func (e *Ec2ssh) pushFile(instances []*Instance, localPath, remotePath string) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	if info.Size() > pushMaxSize {
+		return fmt.Errorf("%s is %d bytes, push-file is limited to %d bytes", localPath, info.Size(), pushMaxSize)
+	}
+
+	content, err := os.ReadFile(localPath)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(content)
+	commands := newPushCommands(base64.StdEncoding.EncodeToString(content), remotePath,
+		hex.EncodeToString(sum[:]), info.Mode().Perm())
+
+	var failed int
+	lock := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	for _, instance := range instances {
+		wg.Add(1)
+		go func(instance *Instance) {
+			defer wg.Done()
+
+			if err := commands.run(instance); err != nil {
+				lock.Lock()
+				failed++
+				fmt.Fprintf(os.Stderr, "%s: push failed: %v\n", instance.InstanceId, err)
+				lock.Unlock()
+				return
+			}
+
+			lock.Lock()
+			fmt.Printf("%s: pushed %s to %s (%d bytes)\n", instance.InstanceId, localPath, remotePath, len(content))
+			lock.Unlock()
+		}(instance)
+	}
+	wg.Wait()
+
+	if failed > 0 {
+		return fmt.Errorf("push failed on %d of %d instances", failed, len(instances))
+	}
+	return nil
+}
+
+// pushCommands are the Run Command invocations writing an encoded file to
+// its remote path: one clearing the staging files, one per chunk writing its
+// own numbered staging file, and one joining them in order, decoding and
+// verifying the file
+type pushCommands struct {
+	prepare []string
+	chunks  [][]string
+	finish  []string
+}
+
+// newPushCommands returns the commands pushing the encoded file
+func newPushCommands(encoded, remotePath, sha256sum string, mode os.FileMode) *pushCommands {
+	staging := remotePath + ".ec2ssh-b64"
+	remote := shellQuote(remotePath)
+
+	commands := &pushCommands{
+		prepare: []string{fmt.Sprintf("rm -f %s %s.*", shellQuote(staging), shellQuote(staging))},
+	}
+	var parts []string
+	for start := 0; start < len(encoded); start += pushChunkSize {
+		end := start + pushChunkSize
+		if end > len(encoded) {
+			end = len(encoded)
+		}
+		part := shellQuote(fmt.Sprintf("%s.%05d", staging, len(parts)))
+		parts = append(parts, part)
+		commands.chunks = append(commands.chunks, []string{
+			fmt.Sprintf("printf '%%s' '%s' > %s", encoded[start:end], part),
+		})
+	}
+	commands.finish = []string{
+		"set -e",
+		fmt.Sprintf(": > %s", shellQuote(staging)),
+	}
+	// The parts are listed rather than globbed, so leftovers of an earlier
+	// push can't end up in the file
+	for _, part := range parts {
+		commands.finish = append(commands.finish, fmt.Sprintf("cat %s >> %s", part, shellQuote(staging)))
+	}
+	commands.finish = append(commands.finish,
+		fmt.Sprintf("base64 -d %s > %s", shellQuote(staging), remote),
+		fmt.Sprintf("rm -f %s %s.*", shellQuote(staging), shellQuote(staging)),
+		fmt.Sprintf("echo '%s  '%s | sha256sum -c --quiet -", sha256sum, remote),
+		fmt.Sprintf("chmod %o %s", mode, remote),
+	)
+	return commands
+}
+
+// run pushes the file to an instance, sending up to pushConcurrency chunks
+// at once
+func (c *pushCommands) run(instance *Instance) error {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	if _, err := runShellScript(ctx, instance, c.prepare); err != nil {
+		return err
+	}
+
+	var firstErr error
+	lock := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	limit := make(chan struct{}, pushConcurrency)
+	for _, chunk := range c.chunks {
+		wg.Add(1)
+		limit <- struct{}{}
+		go func(chunk []string) {
+			defer wg.Done()
+			defer func() { <-limit }()
+			if _, err := runShellScript(ctx, instance, chunk); err != nil {
+				lock.Lock()
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				lock.Unlock()
+			}
+		}(chunk)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+
+	_, err := runShellScript(ctx, instance, c.finish)
+	return err
+}
@@ -0,0 +1,139 @@
+package ec2ssh
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// reachabilityTimeout bounds how long a Reachability Analyzer analysis is
+// waited for
+const reachabilityTimeout = 3 * time.Minute
+
+type ReachabilityConfig struct {
+	// Source is where connections come from as seen by the VPC, e.g. the
+	// bastion instance, an internet gateway or a transit gateway attachment
+	Source string `mapstructure:"source"`
+}
+
+// offerReachabilityAnalysis offers to diagnose a failed SSH connection with
+// VPC Reachability Analyzer, from the configured source to the instance
+func (e *Ec2ssh) offerReachabilityAnalysis(plan *ConnectionPlan) {
+	// The analysis creates a network insights path and analysis
+	if e.checkWritable("Reachability Analyzer") != nil {
+		return
+	}
+	source := e.options.Reachability.Source
+	if source == "" {
+		fmt.Println("Hint: set reachability.source in the config (e.g. your bastion or internet gateway id) to diagnose failed connections with VPC Reachability Analyzer")
+		return
+	}
+	if !confirm("Run a VPC Reachability Analyzer analysis to find what blocks the connection (billed per analysis)?") {
+		return
+	}
+
+	summary, err := analyzeReachability(plan, source)
+	if err != nil {
+		fmt.Printf("Reachability analysis failed: %v\n", err)
+		return
+	}
+	fmt.Println(summary)
+}
+
+// analyzeReachability runs a Reachability Analyzer analysis from a source to
+// the instance on the SSH port and summarizes the result. The path and
+// analysis are deleted afterwards.
+func analyzeReachability(plan *ConnectionPlan, source string) (string, error) {
+	if plan.Instance.clients == nil {
+		return "", fmt.Errorf("no client available for %s", plan.Instance.InstanceId)
+	}
+	client := plan.Instance.clients.EC2
+	ctx := context.TODO()
+
+	port := int32(22)
+	if plan.Port != "" {
+		if p, err := strconv.Atoi(plan.Port); err == nil {
+			port = int32(p)
+		}
+	}
+
+	path, err := client.CreateNetworkInsightsPath(ctx, &ec2.CreateNetworkInsightsPathInput{
+		Source:          aws.String(source),
+		Destination:     aws.String(plan.Instance.InstanceId),
+		Protocol:        types.ProtocolTcp,
+		DestinationPort: aws.Int32(port),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create the path: %w", err)
+	}
+	pathId := path.NetworkInsightsPath.NetworkInsightsPathId
+	defer client.DeleteNetworkInsightsPath(ctx, &ec2.DeleteNetworkInsightsPathInput{NetworkInsightsPathId: pathId})
+
+	started, err := client.StartNetworkInsightsAnalysis(ctx, &ec2.StartNetworkInsightsAnalysisInput{
+		NetworkInsightsPathId: pathId,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to start the analysis: %w", err)
+	}
+	analysisId := started.NetworkInsightsAnalysis.NetworkInsightsAnalysisId
+	defer client.DeleteNetworkInsightsAnalysis(ctx, &ec2.DeleteNetworkInsightsAnalysisInput{NetworkInsightsAnalysisId: analysisId})
+
+	fmt.Printf("Analyzing the path from %s to %s on port %d...\n", source, plan.Instance.InstanceId, port)
+	deadline := time.Now().Add(reachabilityTimeout)
+	for {
+		output, err := client.DescribeNetworkInsightsAnalyses(ctx, &ec2.DescribeNetworkInsightsAnalysesInput{
+			NetworkInsightsAnalysisIds: []string{aws.ToString(analysisId)},
+		})
+		if err != nil {
+			return "", err
+		}
+		if len(output.NetworkInsightsAnalyses) > 0 {
+			analysis := output.NetworkInsightsAnalyses[0]
+			switch analysis.Status {
+			case types.AnalysisStatusSucceeded:
+				return summarizeAnalysis(analysis), nil
+			case types.AnalysisStatusFailed:
+				return "", fmt.Errorf("%s", aws.ToString(analysis.StatusMessage))
+			}
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("no result after %s", reachabilityTimeout)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// summarizeAnalysis describes the components blocking a path, or that the
+// network isn't to blame
+func summarizeAnalysis(analysis types.NetworkInsightsAnalysis) string {
+	if aws.ToBool(analysis.NetworkPathFound) {
+		return "The network path is open: check that sshd is running and accepts your key, or the host's firewall"
+	}
+
+	lines := []string{"The network path is blocked:"}
+	for _, explanation := range analysis.Explanations {
+		component := explanation.Component
+		for _, c := range []*types.AnalysisComponent{explanation.SecurityGroup, explanation.Acl, explanation.RouteTable, explanation.Subnet} {
+			if c != nil {
+				component = c
+				break
+			}
+		}
+
+		line := "  - " + aws.ToString(explanation.ExplanationCode)
+		if component != nil {
+			line += " at " + aws.ToString(component.Id)
+			if name := aws.ToString(component.Name); name != "" {
+				line += " (" + name + ")"
+			}
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,65 @@
+package ec2ssh
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spf13/viper"
+)
+
+// mutatingCommands are the subcommands changing instances through the AWS
+// API, refused in read-only mode and left out of completion. Commands run on
+// the instances themselves, like push-file or --command, are allowed like
+// interactive shells are.
+var mutatingCommands = map[string]bool{
+	"stop":      true,
+	"terminate": true,
+}
+
+// errReadOnly is returned by actions refused in read-only mode
+var errReadOnly = fmt.Errorf("not allowed in read-only mode")
+
+// validateReadOnly refuses the commands and flags changing instances or
+// security groups in read-only mode, so a shared read-only config can be
+// handed to auditors
+func validateReadOnly(options Options) error {
+	if !options.ReadOnly {
+		return nil
+	}
+	if mutatingCommands[options.Command] {
+		return fmt.Errorf("%s is %w", options.Command, errReadOnly)
+	}
+	if options.AuthorizeMyIp {
+		return fmt.Errorf("--authorize-my-ip is %w", errReadOnly)
+	}
+	return nil
+}
+
+// checkWritable refuses an action changing AWS resources in read-only mode.
+// It's checked where the action is made, whatever path led to it.
+func (e *Ec2ssh) checkWritable(action string) error {
+	if e.options.ReadOnly {
+		return fmt.Errorf("%s is %w", action, errReadOnly)
+	}
+	return nil
+}
+
+// readOnlyConfigured reports whether read-only mode is on, from the flag or
+// the config file, before the options are parsed
+func readOnlyConfigured() bool {
+	return viper.GetBool("read-only") || viper.GetBool("ReadOnly")
+}
+
+// completionCommands returns the subcommands offered for completion, without
+// the mutating ones in read-only mode
+func completionCommands(readOnly bool) []string {
+	commands := []string{"cache", "completion", "daemon", "diff", "doctor", "unpin", "use"}
+	for command := range instanceCommands {
+		if readOnly && mutatingCommands[command] {
+			continue
+		}
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	return commands
+}
@@ -0,0 +1,31 @@
+package ec2ssh
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateReadOnly(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		refused bool
+	}{
+		{"read-write stop", Options{Command: "stop"}, false},
+		{"stop", Options{ReadOnly: true, Command: "stop"}, true},
+		{"terminate", Options{ReadOnly: true, Command: "terminate"}, true},
+		{"authorize my IP", Options{ReadOnly: true, AuthorizeMyIp: true}, true},
+		{"connect", Options{ReadOnly: true}, false},
+		{"logs", Options{ReadOnly: true, Command: "logs"}, false},
+		{"push-file", Options{ReadOnly: true, Command: "push-file"}, false},
+		{"fleet command", Options{ReadOnly: true, Fleet: FleetConfig{Command: "uptime"}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateReadOnly(test.options)
+			if refused := errors.Is(err, errReadOnly); refused != test.refused {
+				t.Errorf("validateReadOnly() = %v, want refused %v", err, test.refused)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package ec2ssh
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// regionProbeTimeout bounds how long measuring the latency to a region takes,
+// regions not answering in time are listed last
+const regionProbeTimeout = 2 * time.Second
+
+// RegionOrderConfig orders the region fan-out, and so the grouping of the
+// merged list, instead of following the configured region order
+type RegionOrderConfig struct {
+	Home    string // region listed first
+	Latency bool   // order the other regions by measured latency
+}
+
+// regionLatencies caches the measured latencies, every profile listing the
+// same regions
+var regionLatencies = struct {
+	sync.Mutex
+	measured map[string]time.Duration
+}{measured: make(map[string]time.Duration)}
+
+// orderRegions returns the regions with the home region first and, when
+// enabled, the others from the nearest to the farthest. Ties, and regions
+// that couldn't be measured, keep their configured order.
+func orderRegions(options Options, regions []string) []string {
+	config := options.RegionOrder
+	ordered := append([]string(nil), regions...)
+
+	// Custom endpoints are the same wherever the region is
+	if config.Latency && options.serviceEndpoint("ec2") == "" {
+		latencies := measureRegions(ordered)
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return latencies[ordered[i]] < latencies[ordered[j]]
+		})
+	}
+
+	if config.Home != "" {
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return ordered[i] == config.Home && ordered[j] != config.Home
+		})
+	}
+	return ordered
+}
+
+// measureRegions measures the time to open a connection to the EC2 endpoint
+// of each region, in parallel
+func measureRegions(regions []string) map[string]time.Duration {
+	wg := &sync.WaitGroup{}
+	for _, region := range regions {
+		regionLatencies.Lock()
+		_, ok := regionLatencies.measured[region]
+		regionLatencies.Unlock()
+		if ok {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			latency := regionLatency(region)
+			regionLatencies.Lock()
+			regionLatencies.measured[region] = latency
+			regionLatencies.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	latencies := make(map[string]time.Duration, len(regions))
+	regionLatencies.Lock()
+	for _, region := range regions {
+		latencies[region] = regionLatencies.measured[region]
+	}
+	regionLatencies.Unlock()
+	return latencies
+}
+
+// regionLatency returns the time to open a connection to the EC2 endpoint of
+// a region, or the probe timeout when it fails
+func regionLatency(region string) time.Duration {
+	host := fmt.Sprintf("ec2.%s.amazonaws.com", region)
+	if partition(region) == "aws-cn" {
+		host += ".cn"
+	}
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, "443"), regionProbeTimeout)
+	if err != nil {
+		return regionProbeTimeout
+	}
+	conn.Close()
+	return time.Since(start)
+}
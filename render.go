@@ -0,0 +1,57 @@
+package ec2ssh
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// renderList renders the finder line of every instance once, spread over
+// the CPUs, so the finder shows prebuilt strings instead of executing the
+// list template on every redraw, which makes typing lag on huge lists
+func (e *Ec2ssh) renderList(instances []Instance, bookmarks map[string]Bookmark) []string {
+	displays := make([]string, len(instances))
+	parallel(len(instances), func(i int) {
+		str, _ := TemplateForInstance(&instances[i], e.listTemplate)
+		displays[i] = bookmarkMarker(&instances[i], bookmarks) + findingsBadge(&instances[i]) + stateMarker(&instances[i]) + str
+	})
+
+	// The extra search fields start after the longest row, so the rows
+	// aren't longer than they need to be and cut by the finder
+	width := 0
+	if len(e.options.SearchFields) > 0 {
+		for _, display := range displays {
+			if w := runewidth.StringWidth(display); w > width {
+				width = w
+			}
+		}
+	}
+
+	lines := make([]string, len(instances))
+	parallel(len(instances), func(i int) {
+		lines[i] = fmt.Sprintf("%s\n", e.searchString(&instances[i], displays[i], width))
+	})
+	return lines
+}
+
+// parallel calls fn with every index up to n, spread over the CPUs
+func parallel(n int, fn func(i int)) {
+	next := make(chan int)
+	wg := &sync.WaitGroup{}
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
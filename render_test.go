@@ -0,0 +1,65 @@
+package ec2ssh
+
+import (
+	"fmt"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// benchmarkRedraws is the number of times the finder redraws the list in a
+// benchmarked session, about one per key typed
+const benchmarkRedraws = 20
+
+// benchmarkInstances returns a fleet to render, tagged like real instances
+func benchmarkInstances(n int) []Instance {
+	instances := make([]Instance, n)
+	for i := range instances {
+		instances[i] = Instance{
+			InstanceId:       fmt.Sprintf("i-%017x", i),
+			InstanceType:     "m5.large",
+			State:            InstanceState{Name: "running"},
+			PrivateIpAddress: fmt.Sprintf("10.0.%d.%d", i/256%256, i%256),
+			Placement:        Placement{AvailabilityZone: "us-east-1a"},
+			LaunchTime:       time.Now().Add(-time.Duration(i) * time.Hour),
+			Tags:             map[string]string{"Name": fmt.Sprintf("web-%d", i), "env": "prod"},
+			detail:           &instanceDetail{},
+		}
+	}
+	return instances
+}
+
+// BenchmarkRenderList compares a finder session over the lines rendered once
+// by renderList with one executing the list template on every redraw, as the
+// finder callback did before
+func BenchmarkRenderList(b *testing.B) {
+	options := Options{SearchFields: []string{"tags", "private-ip"}}
+	tmpl := template.Must(template.New("Instance").Funcs(templateFuncs(options, NewAccountAliases(nil))).Parse(defaultTemplate))
+	e := &Ec2ssh{options: options, listTemplate: tmpl}
+
+	for _, n := range []int{1000, 10000} {
+		instances := benchmarkInstances(n)
+
+		b.Run(fmt.Sprintf("prebuilt/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				lines := e.renderList(instances, nil)
+				for redraw := 0; redraw < benchmarkRedraws; redraw++ {
+					for j := range instances {
+						_ = lines[j]
+					}
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("per-redraw/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for redraw := 0; redraw < benchmarkRedraws; redraw++ {
+					for j := range instances {
+						str, _ := TemplateForInstance(&instances[j], e.listTemplate)
+						_ = fmt.Sprintf("%s\n", bookmarkMarker(&instances[j], nil)+findingsBadge(&instances[j])+stateMarker(&instances[j])+e.searchString(&instances[j], str, 0))
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package ec2ssh
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroups"
+	rgtypes "github.com/aws/aws-sdk-go-v2/service/resourcegroups/types"
+)
+
+// tagQuery is the query of a tag-based resource group
+type tagQuery struct {
+	TagFilters []struct {
+		Key    string
+		Values []string
+	}
+}
+
+// resourceGroupFilters returns the DescribeInstances filters equivalent to
+// the query of the --resource-group group in the region of the clients. Tag
+// based groups match their tags, CloudFormation stack ones the instances of
+// their stack. Groups are regional, so it returns no filters and false in
+// the regions the group doesn't exist in.
+func (e *Ec2ssh) resourceGroupFilters(ctx context.Context, c *awsClients) ([]types.Filter, bool, error) {
+	name := e.options.ResourceGroup
+	output, err := c.ResourceGroups.GetGroupQuery(ctx, &resourcegroups.GetGroupQueryInput{
+		Group: aws.String(name),
+	})
+	var notFound *rgtypes.NotFoundException
+	if errors.As(err, &notFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get the query of resource group %s: %w", name, err)
+	}
+	filters, err := groupQueryFilters(name, output.GroupQuery.ResourceQuery)
+	return filters, err == nil, err
+}
+
+// groupQueryFilters converts a resource group query to DescribeInstances
+// filters
+func groupQueryFilters(name string, query *rgtypes.ResourceQuery) ([]types.Filter, error) {
+	if query == nil {
+		return nil, fmt.Errorf("resource group %s has no query", name)
+	}
+
+	switch query.Type {
+	case rgtypes.QueryTypeTagFilters10:
+		var tags tagQuery
+		if err := json.Unmarshal([]byte(aws.ToString(query.Query)), &tags); err != nil {
+			return nil, fmt.Errorf("invalid query in resource group %s: %w", name, err)
+		}
+		var filters []types.Filter
+		for _, tag := range tags.TagFilters {
+			if len(tag.Values) == 0 {
+				filters = append(filters, types.Filter{Name: aws.String("tag-key"), Values: []string{tag.Key}})
+			} else {
+				filters = append(filters, types.Filter{Name: aws.String("tag:" + tag.Key), Values: tag.Values})
+			}
+		}
+		return filters, nil
+
+	case rgtypes.QueryTypeCloudformationStack10:
+		var stack struct {
+			StackIdentifier string
+		}
+		if err := json.Unmarshal([]byte(aws.ToString(query.Query)), &stack); err != nil {
+			return nil, fmt.Errorf("invalid query in resource group %s: %w", name, err)
+		}
+		if stack.StackIdentifier == "" {
+			return nil, fmt.Errorf("resource group %s doesn't name its stack", name)
+		}
+		return []types.Filter{{
+			Name:   aws.String("tag:aws:cloudformation:stack-id"),
+			Values: []string{stack.StackIdentifier},
+		}}, nil
+	}
+	return nil, fmt.Errorf("resource group %s has a %s query, only tag and CloudFormation stack queries are supported", name, query.Type)
+}
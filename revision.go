@@ -0,0 +1,178 @@
+package ec2ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Masterminds/sprig"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codedeploy"
+	cdtypes "github.com/aws/aws-sdk-go-v2/service/codedeploy/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// RevisionConfig configures where the version deployed on an instance is
+// read from, tried in order. Parameter and Application are templates
+// rendered for the instance.
+type RevisionConfig struct {
+	Tag         string // tag holding the version, e.g. a build SHA
+	Parameter   string // SSM parameter holding the version
+	Application string // CodeDeploy application deploying the instance
+}
+
+// Revision is the version deployed on an instance
+type Revision struct {
+	Version    string
+	Source     string // tag, parameter or codedeploy
+	Deployment string // CodeDeploy deployment id
+	Status     string // CodeDeploy status of the instance in the deployment
+	Time       time.Time
+}
+
+// revisionFunc returns the "revision" template function, which looks up the
+// version deployed on an instance, e.g. {{ with revision . }}{{ .Version }}
+// {{ end }}. It's nil when nothing is configured or found.
+func revisionFunc(config RevisionConfig) func(i *Instance) (*Revision, error) {
+	return func(i *Instance) (*Revision, error) {
+		if config.Tag != "" {
+			if version := i.Tags[config.Tag]; version != "" {
+				return &Revision{Version: version, Source: "tag"}, nil
+			}
+		}
+		if i.clients == nil {
+			return nil, nil
+		}
+
+		if config.Parameter != "" {
+			name, err := renderRevisionTemplate("revision.parameter", config.Parameter, i)
+			if err != nil {
+				return nil, err
+			}
+			if name != "" {
+				output, err := i.clients.SSM.GetParameter(context.TODO(), &ssm.GetParameterInput{Name: aws.String(name)})
+				if err == nil {
+					return &Revision{
+						Version: aws.ToString(output.Parameter.Value),
+						Source:  "parameter",
+						Time:    aws.ToTime(output.Parameter.LastModifiedDate),
+					}, nil
+				}
+				var notFound *ssmtypes.ParameterNotFound
+				if !errors.As(err, &notFound) {
+					return nil, fmt.Errorf("failed to get the parameter %s: %w", name, err)
+				}
+			}
+		}
+
+		if config.Application != "" && i.clients.CodeDeploy != nil {
+			application, err := renderRevisionTemplate("revision.application", config.Application, i)
+			if err != nil || application == "" {
+				return nil, err
+			}
+			return lastDeployment(context.TODO(), i.clients.CodeDeploy, application, i.InstanceId)
+		}
+		return nil, nil
+	}
+}
+
+// renderRevisionTemplate renders a revision setting for an instance
+func renderRevisionTemplate(name, text string, i *Instance) (string, error) {
+	t, err := template.New(name).Funcs(sprig.TxtFuncMap()).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s template: %w", name, err)
+	}
+	var buffer bytes.Buffer
+	if err := t.Execute(&buffer, i); err != nil {
+		return "", fmt.Errorf("failed to render %s: %w", name, err)
+	}
+	return strings.TrimSpace(buffer.String()), nil
+}
+
+// lastDeployment returns the revision of the latest deployment of an
+// application that targeted the instance. The last attempted deployment of
+// every deployment group is checked, the most recent first.
+func lastDeployment(ctx context.Context, client *codedeploy.Client, application, instanceId string) (*Revision, error) {
+	var names []string
+	paginator := codedeploy.NewListDeploymentGroupsPaginator(client, &codedeploy.ListDeploymentGroupsInput{
+		ApplicationName: aws.String(application),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the deployment groups of %s: %w", application, err)
+		}
+		names = append(names, page.DeploymentGroups...)
+	}
+
+	var deployments []*cdtypes.LastDeploymentInfo
+	// BatchGetDeploymentGroups takes up to 100 groups at a time
+	for start := 0; start < len(names); start += 100 {
+		end := min(start+100, len(names))
+		output, err := client.BatchGetDeploymentGroups(ctx, &codedeploy.BatchGetDeploymentGroupsInput{
+			ApplicationName:      aws.String(application),
+			DeploymentGroupNames: names[start:end],
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe the deployment groups of %s: %w", application, err)
+		}
+		for _, group := range output.DeploymentGroupsInfo {
+			if group.LastAttemptedDeployment != nil {
+				deployments = append(deployments, group.LastAttemptedDeployment)
+			}
+		}
+	}
+	sort.Slice(deployments, func(a, b int) bool {
+		return aws.ToTime(deployments[a].CreateTime).After(aws.ToTime(deployments[b].CreateTime))
+	})
+
+	for _, deployment := range deployments {
+		target, err := client.GetDeploymentTarget(ctx, &codedeploy.GetDeploymentTargetInput{
+			DeploymentId: deployment.DeploymentId,
+			TargetId:     aws.String(instanceId),
+		})
+		if err != nil || target.DeploymentTarget == nil || target.DeploymentTarget.InstanceTarget == nil {
+			continue // the instance wasn't part of this deployment
+		}
+
+		output, err := client.GetDeployment(ctx, &codedeploy.GetDeploymentInput{DeploymentId: deployment.DeploymentId})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the deployment %s: %w", aws.ToString(deployment.DeploymentId), err)
+		}
+		return &Revision{
+			Version:    revisionVersion(output.DeploymentInfo.Revision),
+			Source:     "codedeploy",
+			Deployment: aws.ToString(deployment.DeploymentId),
+			Status:     string(target.DeploymentTarget.InstanceTarget.Status),
+			Time:       aws.ToTime(deployment.CreateTime),
+		}, nil
+	}
+	return nil, nil
+}
+
+// revisionVersion describes a CodeDeploy revision: the commit of GitHub
+// revisions, the key and version of S3 bundles
+func revisionVersion(location *cdtypes.RevisionLocation) string {
+	switch {
+	case location == nil:
+		return ""
+	case location.GitHubLocation != nil:
+		return aws.ToString(location.GitHubLocation.CommitId)
+	case location.S3Location != nil:
+		version := aws.ToString(location.S3Location.Key)
+		if v := aws.ToString(location.S3Location.Version); v != "" {
+			version += "@" + v
+		} else if etag := aws.ToString(location.S3Location.ETag); etag != "" {
+			version += "@" + etag
+		}
+		return version
+	default:
+		return string(location.RevisionType)
+	}
+}
@@ -0,0 +1,98 @@
+package ec2ssh
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// rightSizingPeriod is how far back the CPU utilization of instances is
+// averaged
+const rightSizingPeriod = 14 * 24 * time.Hour
+
+// RightSizingConfig configures the CPU utilization hint of the preview
+type RightSizingConfig struct {
+	Enabled bool
+	Low     float64 // average CPU % under which an instance is over-provisioned
+	High    float64 // average CPU % over which an instance is under-provisioned
+}
+
+// RightSizing is the CPU utilization of an instance over the last 14 days
+type RightSizing struct {
+	Average float64 // average of the daily averages, in %
+	Maximum float64 // highest daily maximum, in %
+	Hint    string  // over-provisioned, under-provisioned or right-sized
+}
+
+// rightSizingFunc returns the "rightsizing" template function, which averages
+// the CPU utilization of an instance from CloudWatch and hints whether its
+// type fits, e.g. {{ with rightsizing . }}{{ .Hint }}{{ end }}. It's nil when
+// disabled or when CloudWatch has no datapoints, and failures are ignored as
+// the hint is only a nudge.
+func rightSizingFunc(config RightSizingConfig) func(i *Instance) *RightSizing {
+	var mu sync.Mutex
+	cached := make(map[string]*RightSizing)
+
+	return func(i *Instance) *RightSizing {
+		if !config.Enabled || i.clients == nil {
+			return nil
+		}
+
+		mu.Lock()
+		sizing, ok := cached[i.TargetID()]
+		mu.Unlock()
+		if ok {
+			return sizing
+		}
+
+		sizing = cpuUtilization(i, config)
+		mu.Lock()
+		cached[i.TargetID()] = sizing
+		mu.Unlock()
+		return sizing
+	}
+}
+
+// cpuUtilization fetches the daily CPU utilization of an instance over the
+// right-sizing period and summarizes it
+func cpuUtilization(i *Instance, config RightSizingConfig) *RightSizing {
+	end := time.Now()
+	output, err := i.clients.CloudWatch.GetMetricStatistics(context.TODO(), &cloudwatch.GetMetricStatisticsInput{
+		Namespace:  aws.String("AWS/EC2"),
+		MetricName: aws.String("CPUUtilization"),
+		Dimensions: []cwtypes.Dimension{{
+			Name:  aws.String("InstanceId"),
+			Value: aws.String(i.InstanceId),
+		}},
+		StartTime:  aws.Time(end.Add(-rightSizingPeriod)),
+		EndTime:    aws.Time(end),
+		Period:     aws.Int32(int32((24 * time.Hour).Seconds())),
+		Statistics: []cwtypes.Statistic{cwtypes.StatisticAverage, cwtypes.StatisticMaximum},
+	})
+	if err != nil || len(output.Datapoints) == 0 {
+		return nil
+	}
+
+	sizing := &RightSizing{}
+	for _, datapoint := range output.Datapoints {
+		sizing.Average += aws.ToFloat64(datapoint.Average)
+		if maximum := aws.ToFloat64(datapoint.Maximum); maximum > sizing.Maximum {
+			sizing.Maximum = maximum
+		}
+	}
+	sizing.Average /= float64(len(output.Datapoints))
+
+	switch {
+	case sizing.Average < config.Low && sizing.Maximum < config.High:
+		sizing.Hint = "over-provisioned"
+	case sizing.Average > config.High:
+		sizing.Hint = "under-provisioned"
+	default:
+		sizing.Hint = "right-sized"
+	}
+	return sizing
+}
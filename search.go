@@ -0,0 +1,108 @@
+package ec2ssh
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/mattn/go-runewidth"
+)
+
+// describeImagesBatch is the number of image ids sent per DescribeImages call
+const describeImagesBatch = 100
+
+// searchString returns the finder line for an instance: the rendered list
+// template followed by the configured extra search fields, lined up in a
+// column after width, the display width of the longest rendered row
+func (e *Ec2ssh) searchString(instance *Instance, display string, width int) string {
+	if len(e.options.SearchFields) == 0 {
+		return display
+	}
+
+	var extra []string
+	for _, field := range e.options.SearchFields {
+		switch field {
+		case "tags":
+			for _, value := range instance.Tags {
+				extra = append(extra, value)
+			}
+		case "private-ip":
+			extra = append(extra, instance.PrivateIpAddress)
+		case "public-ip":
+			extra = append(extra, instance.PublicIpAddress)
+		case "ami-name":
+			extra = append(extra, instance.ImageName)
+		}
+	}
+
+	padding := width - runewidth.StringWidth(display) + 1
+	if padding < 1 {
+		padding = 1
+	}
+	return display + strings.Repeat(" ", padding) + strings.Join(extra, " ")
+}
+
+// validateSearchFields checks the configured search fields are known
+func validateSearchFields(fields []string) error {
+	for _, field := range fields {
+		switch field {
+		case "tags", "private-ip", "public-ip", "ami-name":
+		default:
+			return fmt.Errorf("unknown search field %q, valid fields are: tags, private-ip, public-ip, ami-name", field)
+		}
+	}
+	return nil
+}
+
+// resolveImageNames fills in the AMI name of the given instances, batching the
+// DescribeImages calls. This is best effort: deregistered or inaccessible
+// images, as well as failed calls, leave the name blank.
+func resolveImageNames(ec2Client *ec2.Client, instances []Instance) {
+	names := make(map[string]string)
+	var ids []string
+	for _, instance := range instances {
+		id := instance.ImageId
+		if _, ok := names[id]; id != "" && !ok {
+			names[id] = ""
+			ids = append(ids, id)
+		}
+	}
+
+	for start := 0; start < len(ids); start += describeImagesBatch {
+		end := start + describeImagesBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		// Filter rather than pass ImageIds, which fails on deregistered images
+		output, err := ec2Client.DescribeImages(context.TODO(), &ec2.DescribeImagesInput{
+			Filters: []types.Filter{{
+				Name:   aws.String("image-id"),
+				Values: ids[start:end],
+			}},
+		})
+		if err != nil {
+			break
+		}
+		for _, image := range output.Images {
+			names[aws.ToString(image.ImageId)] = aws.ToString(image.Name)
+		}
+	}
+
+	for i := range instances {
+		instances[i].ImageName = names[instances[i].ImageId]
+	}
+}
+
+// wantsSearchField reports whether an extra search field is enabled
+func (e *Ec2ssh) wantsSearchField(field string) bool {
+	for _, f := range e.options.SearchFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package ec2ssh
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	finder "github.com/ktr0731/go-fuzzyfinder"
+	"github.com/spf13/viper"
+)
+
+// SearchConfig is a named search run with "ec2-ssh search <name>" or picked
+// with --searches: the profiles, regions and filters to list, and a query
+// narrowing the list down
+type SearchConfig struct {
+	Profiles []string `mapstructure:"profiles"`
+	Regions  []string `mapstructure:"regions"`
+	Filters  []string `mapstructure:"filters"`
+	Query    string   `mapstructure:"query"`
+}
+
+// savedSearches returns the searches configured in the [searches] tables
+func savedSearches() (map[string]SearchConfig, error) {
+	var searches map[string]SearchConfig
+	if err := viper.UnmarshalKey("searches", &searches); err != nil {
+		return nil, fmt.Errorf("Invalid searches configuration: %v", err)
+	}
+	return searches, nil
+}
+
+// searchNames returns the names of the saved searches, sorted
+func searchNames(searches map[string]SearchConfig) []string {
+	names := make([]string, 0, len(searches))
+	for name := range searches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pickSearch lets the user pick one of the saved searches
+func pickSearch(searches map[string]SearchConfig) (string, error) {
+	names := searchNames(searches)
+	if len(names) == 0 {
+		return "", fmt.Errorf("no searches configured, add [searches.<name>] sections to the config file")
+	}
+
+	idx, err := finder.Find(names, func(i int) string {
+		search := searches[names[i]]
+		return fmt.Sprintf("%-16s %s %s", names[i], strings.Join(search.Filters, ","), search.Query)
+	}, finder.WithPromptString("search> "))
+	if err != nil {
+		if errors.Is(err, finder.ErrAbort) {
+			os.Exit(1)
+		}
+		return "", err
+	}
+	return names[idx], nil
+}
+
+// matchesQuery reports whether a finder line contains every word of a saved
+// search query, ignoring case. The finder can't be started with a query, so
+// saved queries narrow the list down before it's shown.
+func matchesQuery(line, query string) bool {
+	line = strings.ToLower(line)
+	for _, word := range strings.Fields(strings.ToLower(query)) {
+		if !strings.Contains(line, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterByQuery keeps the instances whose finder line matches the query of
+// the saved search
+func (e *Ec2ssh) filterByQuery(instances []Instance) []Instance {
+	matching := make([]Instance, 0, len(instances))
+	for i := range instances {
+		str, _ := TemplateForInstance(&instances[i], e.listTemplate)
+		if matchesQuery(e.searchString(&instances[i], str, 0), e.options.Query) {
+			matching = append(matching, instances[i])
+		}
+	}
+	return matching
+}
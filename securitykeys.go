@@ -0,0 +1,101 @@
+package ec2ssh
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// securityKeyPrefix starts the type of FIDO2 security key public keys, e.g.
+// sk-ssh-ed25519@openssh.com or sk-ecdsa-sha2-nistp256@openssh.com
+const securityKeyPrefix = "sk-"
+
+var (
+	agentKeysOnce sync.Once
+	agentKeys     []string
+)
+
+// agentSecurityKeys returns the comments of the security keys loaded in the
+// SSH agent, listed once
+func agentSecurityKeys() []string {
+	agentKeysOnce.Do(func() {
+		output, err := exec.Command("ssh-add", "-L").Output()
+		if err != nil {
+			return
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(output))
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 2 || !strings.HasPrefix(fields[0], securityKeyPrefix) {
+				continue
+			}
+			name := fields[0]
+			if len(fields) > 2 {
+				name = strings.Join(fields[2:], " ")
+			}
+			agentKeys = append(agentKeys, name+" (agent)")
+		}
+	})
+	return agentKeys
+}
+
+// securityKeys returns the FIDO2 security keys ssh may authenticate a plan
+// with, which need a touch: the agent's and the identity files of its ssh
+// config whose public key is a security key
+func securityKeys(plan *ConnectionPlan) []string {
+	if plan.Method != MethodSSH {
+		return nil
+	}
+	keys := append([]string{}, agentSecurityKeys()...)
+
+	output, err := exec.Command("ssh", append([]string{"-G"}, plan.sshArgs()...)...).Output()
+	if err != nil {
+		return keys
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		if key != "identityfile" {
+			continue
+		}
+		public, err := os.ReadFile(expandHome(value) + ".pub")
+		if err == nil && strings.HasPrefix(string(public), securityKeyPrefix) {
+			keys = append(keys, value)
+		}
+	}
+	return keys
+}
+
+// noticeSecurityKeys tells, before connecting to several instances at once,
+// that their connections wait for a touch of a security key. ssh only
+// prompts for it when its stderr is a terminal, and panes all wait at once,
+// so without a notice a blinking key is easy to miss. It returns which plans
+// may need a touch, nil when none does.
+func noticeSecurityKeys(plans []*ConnectionPlan) []bool {
+	var keys []string
+	seen := make(map[string]bool)
+	touches := make([]bool, len(plans))
+	count := 0
+	for i, plan := range plans {
+		planKeys := securityKeys(plan)
+		if len(planKeys) > 0 {
+			touches[i] = true
+			count++
+		}
+		for _, key := range planKeys {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	if count == 0 {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "%d of the %d connections may authenticate with a security key (%s), touch it each time it blinks\n", count, len(plans), strings.Join(keys, ", "))
+	return touches
+}
@@ -0,0 +1,85 @@
+package ec2ssh
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SelectionConfig configures the summary shown before acting on several
+// instances
+type SelectionConfig struct {
+	ConfirmAbove   int    // selections larger than this need a confirmation, 0 never does
+	EnvironmentTag string // tag the selection is summarized by, besides type and AZ
+	Reverse        bool   // act on the selection in reverse order
+}
+
+// orderSelection reverses the selection with --reverse. Instances are
+// connected to, and their panes laid out, in the order they were picked in
+// the finder or of the --instance ids, so e.g. a canary and its baseline can
+// be swapped side by side.
+func (e *Ec2ssh) orderSelection(instances []*Instance) {
+	if !e.options.Selection.Reverse {
+		return
+	}
+	for i, j := 0, len(instances)-1; i < j; i, j = i+1, j-1 {
+		instances[i], instances[j] = instances[j], instances[i]
+	}
+}
+
+// confirmSelection summarizes a selection of several instances by type,
+// availability zone and environment, and asks for a confirmation when it's
+// larger than the configured threshold, so a command isn't run on a whole
+// fleet by accident. It returns false if the user declined.
+func (e *Ec2ssh) confirmSelection(instances []*Instance) bool {
+	if len(instances) < 2 {
+		return true
+	}
+
+	types := make(map[string]int)
+	zones := make(map[string]int)
+	environments := make(map[string]int)
+	for _, instance := range instances {
+		types[instance.InstanceType]++
+		zones[instance.Placement.AvailabilityZone]++
+		if e.options.Selection.EnvironmentTag != "" {
+			environment := instance.Tags[e.options.Selection.EnvironmentTag]
+			if environment == "" {
+				environment = "(none)"
+			}
+			environments[environment]++
+		}
+	}
+
+	fmt.Printf("Selected %d instances:\n", len(instances))
+	fmt.Printf("  Types: %s\n", formatCounts(types))
+	fmt.Printf("  Availability zones: %s\n", formatCounts(zones))
+	if len(environments) > 0 {
+		fmt.Printf("  %s: %s\n", e.options.Selection.EnvironmentTag, formatCounts(environments))
+	}
+
+	if e.options.Selection.ConfirmAbove <= 0 || len(instances) <= e.options.Selection.ConfirmAbove {
+		return true
+	}
+	return confirm(fmt.Sprintf("Continue with these %d instances?", len(instances)))
+}
+
+// formatCounts formats counts by value, the most frequent values first
+func formatCounts(counts map[string]int) string {
+	values := make([]string, 0, len(counts))
+	for value := range counts {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if counts[values[i]] != counts[values[j]] {
+			return counts[values[i]] > counts[values[j]]
+		}
+		return values[i] < values[j]
+	})
+
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = fmt.Sprintf("%d × %s", counts[value], value)
+	}
+	return strings.Join(parts, ", ")
+}
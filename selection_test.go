@@ -0,0 +1,23 @@
+package ec2ssh
+
+import "testing"
+
+func TestFormatCounts(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts map[string]int
+		want   string
+	}{
+		{"empty", map[string]int{}, ""},
+		{"one value", map[string]int{"m5.large": 3}, "3 × m5.large"},
+		{"most frequent first", map[string]int{"t3.micro": 1, "m5.large": 4, "c5.xlarge": 2}, "4 × m5.large, 2 × c5.xlarge, 1 × t3.micro"},
+		{"ties by value", map[string]int{"us-west-2": 2, "eu-west-1": 2, "us-east-1": 2}, "2 × eu-west-1, 2 × us-east-1, 2 × us-west-2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := formatCounts(test.counts); got != test.want {
+				t.Errorf("formatCounts() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package ec2ssh
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2instanceconnect"
+)
+
+// connectSerialConsole connects to the serial console of an instance, which
+// works even when its network or boot is broken. A throwaway key is pushed
+// with EC2 Instance Connect, it stays valid for 60 seconds.
+func connectSerialConsole(instance *Instance, limits SessionConfig) error {
+	if instance.clients == nil {
+		return fmt.Errorf("no client available for %s", instance.InstanceId)
+	}
+	if err := requireTool("ssh-keygen"); err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "ec2-ssh-serial")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	key := filepath.Join(dir, "id_ed25519")
+	if output, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", key).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to generate a key: %v: %s", err, output)
+	}
+	publicKey, err := os.ReadFile(key + ".pub")
+	if err != nil {
+		return err
+	}
+
+	_, err = instance.clients.InstanceConnect.SendSerialConsoleSSHPublicKey(context.TODO(), &ec2instanceconnect.SendSerialConsoleSSHPublicKeyInput{
+		InstanceId:   aws.String(instance.InstanceId),
+		SSHPublicKey: aws.String(string(publicKey)),
+		SerialPort:   0,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to push a key to the serial console of %s (is serial console access enabled for the account?): %w", instance.InstanceId, err)
+	}
+
+	fmt.Printf("Connecting to the serial console of %s, press Enter if the prompt doesn't show, type ~. to exit\n", instance.InstanceId)
+	cmd := exec.Command("ssh", "-i", key,
+		"-o", "IdentitiesOnly=yes",
+		fmt.Sprintf("%s.port0@serial-console.ec2-instance-connect.%s.aws", instance.InstanceId, instance.Region))
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	defer watchProcess(cmd, limits, true)()
+	return cmd.Run()
+}
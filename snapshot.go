@@ -0,0 +1,90 @@
+package ec2ssh
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// Snapshot is a saved instance list, e.g. handed to a colleague as "these
+// are the boxes affected", and browsed again with --from-snapshot
+type Snapshot struct {
+	Taken     time.Time  `json:"taken"`
+	Profiles  []string   `json:"profiles,omitempty"`
+	Regions   []string   `json:"regions,omitempty"`
+	Filters   []string   `json:"filters,omitempty"`
+	Query     string     `json:"query,omitempty"`
+	Instances []Instance `json:"instances"`
+}
+
+// saveSnapshot writes the listed instances, as filtered by the options, to
+// a snapshot file
+func (e *Ec2ssh) saveSnapshot(path string, instances []Instance) error {
+	data, err := json.MarshalIndent(Snapshot{
+		Taken:     time.Now(),
+		Profiles:  e.options.Profiles,
+		Regions:   e.options.Regions,
+		Filters:   e.options.Filters,
+		Query:     e.options.Query,
+		Instances: instances,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("Saved %d instances to %s\n", len(instances), path)
+	return nil
+}
+
+// readSnapshot reads a snapshot file
+func readSnapshot(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %w", path, err)
+	}
+	return &snapshot, nil
+}
+
+// snapshotInstances returns the instances of the --from-snapshot file
+// instead of listing them. Instances of a profile and region the clients
+// were created for can be described and connected to as usual when their
+// credentials work; the others are only browsed.
+func (e *Ec2ssh) snapshotInstances() ([]Instance, error) {
+	snapshot, err := readSnapshot(e.options.FromSnapshot)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "Browsing the %d instances of %s, taken %s\n", len(snapshot.Instances), e.options.FromSnapshot, snapshot.Taken.Local().Format(time.RFC1123))
+
+	working := make(map[*awsClients]bool)
+	for i := range snapshot.Instances {
+		instance := &snapshot.Instances[i]
+		instance.detail = &instanceDetail{}
+		for _, c := range e.clients {
+			if c.Profile != instance.Profile || c.Region != instance.Region {
+				continue
+			}
+			ok, checked := working[c]
+			if !checked {
+				_, err := c.STS.GetCallerIdentity(context.TODO(), &sts.GetCallerIdentityInput{})
+				ok = err == nil
+				working[c] = ok
+			}
+			if ok {
+				instance.clients = c
+			}
+			break
+		}
+	}
+	return snapshot.Instances, nil
+}
@@ -0,0 +1,56 @@
+package ec2ssh
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// socksArgs returns the ssh command line opening a SOCKS proxy on a local
+// port through the planned instance
+func (e *Ec2ssh) socksArgs(plan *ConnectionPlan, port int) []string {
+	options, destination := e.sshTarget(plan)
+	args := []string{"ssh", "-N", "-D", strconv.Itoa(port), "-o", "ServerAliveInterval=15"}
+	args = append(args, options...)
+	return append(args, destination)
+}
+
+// sshTarget returns the ssh options and destination reaching the planned
+// instance for tools built on ssh. SSM instances are reached with ssh over an
+// AWS-StartSSHSession session, so they need sshd and an authorized key.
+func (e *Ec2ssh) sshTarget(plan *ConnectionPlan) (options []string, destination string) {
+	if plan.Method != MethodSSM {
+		args := plan.sshArgs()
+		return args[:len(args)-1], args[len(args)-1]
+	}
+
+	proxy := append([]string{"aws"}, e.ssmTargetArgs("%h", cliProfile(plan.Instance))...)
+	proxy = append(proxy, "--document-name", "AWS-StartSSHSession", "--parameters", "portNumber=%p")
+	options = []string{"-o", "ProxyCommand=" + shellJoin(proxy)}
+	if plan.Port != "" {
+		options = append(options, "-p", plan.Port)
+	}
+	destination = plan.Instance.InstanceId
+	if plan.User != "" {
+		destination = plan.User + "@" + destination
+	}
+	return options, destination
+}
+
+// runSocks opens a SOCKS proxy through the planned instance until
+// interrupted, after printing the environment to use it
+func (e *Ec2ssh) runSocks(plan *ConnectionPlan, port int) error {
+	proxy := fmt.Sprintf("socks5h://localhost:%d", port)
+	fmt.Printf("SOCKS proxy through %s on localhost:%d, use it with:\n\n", plan.Instance.InstanceId, port)
+	fmt.Printf("  export ALL_PROXY=%s HTTPS_PROXY=%s HTTP_PROXY=%s\n\n", proxy, proxy, proxy)
+	fmt.Println("Press Ctrl-C to close the proxy")
+
+	args := e.socksArgs(plan, port)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	defer watchProcess(cmd, e.options.Session, false)()
+	return cmd.Run()
+}
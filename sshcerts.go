@@ -0,0 +1,130 @@
+package ec2ssh
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// certificateMargin is how long a cached certificate must still be valid to
+// be reused, so it doesn't expire while connecting
+const certificateMargin = time.Minute
+
+// SSHCertificateConfig configures getting a short-lived SSH certificate from
+// a certificate authority, such as Vault's SSH secrets engine, before
+// connecting
+type SSHCertificateConfig struct {
+	Command   string // prints a certificate signing PublicKey
+	PublicKey string // public key signed, its private half comes from ~/.ssh or the agent
+}
+
+// certificatesDir holds the certificates issued, reused until they expire
+func certificatesDir() string {
+	return filepath.Join(cacheDir(), "certs")
+}
+
+// certificateCommand renders the certificate command for a plan: {public_key}
+// is replaced by the path of the public key, {user} by the remote user,
+// {profile} by the profile of the instance, {instance_id} by its id and
+// {key_name} by its EC2 key pair name
+func certificateCommand(config SSHCertificateConfig, plan *ConnectionPlan) string {
+	return strings.NewReplacer(
+		"{public_key}", shellQuote(expandHome(config.PublicKey)),
+		"{user}", plan.User,
+		"{profile}", plan.Instance.Profile,
+		"{instance_id}", plan.Instance.InstanceId,
+		"{key_name}", plan.Instance.KeyName,
+	).Replace(config.Command)
+}
+
+// expandHome expands a leading ~ to the home directory
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		return filepath.Join(os.Getenv("HOME"), path[1:])
+	}
+	return path
+}
+
+// loadCertificates gets a certificate for each ssh plan, once per distinct
+// command, and passes it to ssh as CertificateFile. Certificates are cached
+// and reused until they're about to expire.
+func (e *Ec2ssh) loadCertificates(plans []*ConnectionPlan) error {
+	if err := os.MkdirAll(certificatesDir(), 0o700); err != nil {
+		return err
+	}
+
+	issued := make(map[string]string)
+	for _, plan := range plans {
+		if plan.Method != MethodSSH {
+			continue
+		}
+		command := certificateCommand(e.options.SSHCertificate, plan)
+		path, ok := issued[command]
+		if !ok {
+			sum := sha256.Sum256([]byte(command))
+			path = filepath.Join(certificatesDir(), hex.EncodeToString(sum[:8])+"-cert.pub")
+			if err := issueCertificate(command, path); err != nil {
+				return err
+			}
+			issued[command] = path
+		}
+		plan.Options = append(plan.Options, "CertificateFile="+path)
+	}
+	return nil
+}
+
+// issueCertificate runs the certificate command and saves the certificate
+// it prints, unless the one saved by an earlier run is still valid
+func issueCertificate(command, path string) error {
+	if expiry, err := certificateExpiry(path); err == nil && time.Until(expiry) > certificateMargin {
+		return nil
+	}
+
+	certificate, err := runSecretCommand(command)
+	if err != nil {
+		return fmt.Errorf("failed to get an SSH certificate: %w", err)
+	}
+	if err := os.WriteFile(path, certificate, 0o600); err != nil {
+		return err
+	}
+	if _, err := certificateExpiry(path); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("%q didn't print a valid SSH certificate: %w", command, err)
+	}
+	return nil
+}
+
+// certificateExpiry returns when a certificate stops being valid, as shown
+// by ssh-keygen -L, e.g. "Valid: from 2024-05-01T10:00:00 to
+// 2024-05-01T11:00:00"
+func certificateExpiry(path string) (time.Time, error) {
+	output, err := exec.Command("ssh-keygen", "-L", "-f", path).Output()
+	if err != nil {
+		return time.Time{}, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "Valid:") {
+			continue
+		}
+		// Certificates without an end date are shown as valid "forever" or
+		// "after" their start
+		if strings.Contains(line, "forever") || strings.HasPrefix(line, "Valid: after") {
+			return time.Now().Add(24 * time.Hour), nil
+		}
+		_, to, ok := strings.Cut(line, " to ")
+		if !ok {
+			break
+		}
+		return time.ParseInLocation("2006-01-02T15:04:05", strings.TrimSpace(to), time.Local)
+	}
+	return time.Time{}, fmt.Errorf("no validity period in %s", path)
+}
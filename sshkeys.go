@@ -0,0 +1,154 @@
+package ec2ssh
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SSH key modes: keys fetched from a secret backend are either loaded into
+// the running ssh-agent or written to a private temporary file
+const (
+	SSHKeyAgent = "agent"
+	SSHKeyFile  = "file"
+)
+
+// SSHKeyConfig configures fetching the private key of ssh connections from a
+// secret backend, such as 1Password or Vault, instead of ~/.ssh
+type SSHKeyConfig struct {
+	Command            string        // prints the private key, e.g. op read op://Infra/{key_name}/private key
+	CertificateCommand string        // prints the certificate of the key, if any
+	Mode               string        // agent or file
+	Lifetime           time.Duration // how long the agent keeps the key, it's also removed on exit
+}
+
+// validateSSHKey checks the ssh_key configuration
+func validateSSHKey(config SSHKeyConfig) error {
+	switch config.Mode {
+	case "", SSHKeyAgent, SSHKeyFile:
+	default:
+		return fmt.Errorf("invalid ssh_key.mode %q, expected %s or %s", config.Mode, SSHKeyAgent, SSHKeyFile)
+	}
+	if config.CertificateCommand != "" && config.Command == "" {
+		return fmt.Errorf("ssh_key.certificate_command requires ssh_key.command")
+	}
+	return nil
+}
+
+// secretKeyCommand renders a key command for an instance: {profile} is
+// replaced by its profile and {key_name} by its EC2 key pair name
+func secretKeyCommand(command string, instance *Instance) string {
+	return strings.NewReplacer("{profile}", instance.Profile, "{key_name}", instance.KeyName).Replace(command)
+}
+
+// loadSecretKeys fetches the private key of each ssh plan with the ssh_key
+// command, once per distinct command, and has ssh use it: loaded into the
+// agent, or written to a file only readable by the user and passed as
+// IdentityFile. Both are removed when ec2-ssh exits.
+func (e *Ec2ssh) loadSecretKeys(plans []*ConnectionPlan) error {
+	config := e.options.SSHKey
+	dir, err := os.MkdirTemp("", "ec2-ssh-keys-")
+	if err != nil {
+		return err
+	}
+	loaded := make(map[string]string)
+	e.onExit(func() {
+		if config.Mode != SSHKeyFile {
+			for _, key := range loaded {
+				exec.Command("ssh-add", "-q", "-d", key+".pub").Run()
+			}
+		}
+		os.RemoveAll(dir)
+	})
+
+	for _, plan := range plans {
+		if plan.Method != MethodSSH {
+			continue
+		}
+		command := secretKeyCommand(config.Command, plan.Instance)
+		key, ok := loaded[command]
+		if !ok {
+			key = filepath.Join(dir, fmt.Sprintf("key%d", len(loaded)))
+			if err := e.fetchSecretKey(command, secretKeyCommand(config.CertificateCommand, plan.Instance), key); err != nil {
+				return err
+			}
+			loaded[command] = key
+		}
+		if config.Mode == SSHKeyFile {
+			plan.Options = append(plan.Options, "IdentityFile="+key)
+		}
+	}
+	return nil
+}
+
+// fetchSecretKey runs the key command, and the certificate one if any, and
+// writes their output next to each other as ssh expects, with the
+// certificate as <key>-cert.pub. In agent mode, the key is then added to
+// the agent and only its public half is kept, to remove it on exit.
+func (e *Ec2ssh) fetchSecretKey(command, certificateCommand, key string) error {
+	private, err := runSecretCommand(command)
+	if err != nil {
+		return err
+	}
+	// ssh rejects keys without their trailing newline
+	if !bytes.HasSuffix(private, []byte("\n")) {
+		private = append(private, '\n')
+	}
+	if err := os.WriteFile(key, private, 0o600); err != nil {
+		return err
+	}
+	if certificateCommand != "" {
+		certificate, err := runSecretCommand(certificateCommand)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(key+"-cert.pub", certificate, 0o600); err != nil {
+			return err
+		}
+	}
+
+	if e.options.SSHKey.Mode == SSHKeyFile {
+		return nil
+	}
+
+	public, err := exec.Command("ssh-keygen", "-y", "-f", key).Output()
+	if err != nil {
+		return fmt.Errorf("the key printed by %q isn't a valid private key: %w", command, err)
+	}
+	if err := os.WriteFile(key+".pub", public, 0o600); err != nil {
+		return err
+	}
+
+	args := []string{"-q"}
+	if lifetime := e.options.SSHKey.Lifetime; lifetime > 0 {
+		args = append(args, "-t", strconv.Itoa(int(lifetime.Seconds())))
+	}
+	if output, err := exec.Command("ssh-add", append(args, key)...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to add the key to the ssh-agent (is one running?): %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	// The agent has it now, only the public half is needed to remove it
+	os.Remove(key)
+	return nil
+}
+
+// runSecretCommand runs a secret backend command through the shell and
+// returns its output. Its prompts, e.g. to unlock 1Password, go to the
+// terminal.
+func runSecretCommand(command string) ([]byte, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%q failed: %w", command, err)
+	}
+	if len(bytes.TrimSpace(output)) == 0 {
+		return nil, fmt.Errorf("%q printed nothing", command)
+	}
+	return output, nil
+}
@@ -0,0 +1,73 @@
+package ec2ssh
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+// vpcCIDRs returns the IPv4 CIDR blocks associated with the instance's VPC
+func vpcCIDRs(instance *Instance) ([]string, error) {
+	if instance.VpcId == "" {
+		return nil, fmt.Errorf("%s isn't in a VPC", instance.InstanceId)
+	}
+	if instance.clients == nil {
+		return nil, fmt.Errorf("no client available for %s", instance.InstanceId)
+	}
+
+	output, err := instance.clients.EC2.DescribeVpcs(context.TODO(), &ec2.DescribeVpcsInput{
+		VpcIds: []string{instance.VpcId},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe %s: %w", instance.VpcId, err)
+	}
+
+	var cidrs []string
+	for _, vpc := range output.Vpcs {
+		for _, association := range vpc.CidrBlockAssociationSet {
+			if association.CidrBlockState != nil && association.CidrBlockState.State != "associated" {
+				continue
+			}
+			cidrs = append(cidrs, aws.ToString(association.CidrBlock))
+		}
+	}
+	if len(cidrs) == 0 {
+		return nil, fmt.Errorf("no CIDR block found for %s", instance.VpcId)
+	}
+	return cidrs, nil
+}
+
+// runSshuttle routes the VPC of the planned instance through it with
+// sshuttle until interrupted
+func (e *Ec2ssh) runSshuttle(plan *ConnectionPlan) error {
+	if err := requireTool("sshuttle"); err != nil {
+		return err
+	}
+
+	cidrs, err := vpcCIDRs(plan.Instance)
+	if err != nil {
+		return err
+	}
+
+	options, destination := e.sshTarget(plan)
+	args := []string{"-r", destination}
+	if len(options) > 0 {
+		args = append(args, "-e", shellJoin(append([]string{"ssh"}, options...)))
+	}
+	args = append(args, cidrs...)
+
+	fmt.Printf("Routing %s (%s) through %s, press Ctrl-C to stop\n",
+		plan.Instance.VpcId, strings.Join(cidrs, ", "), plan.Instance.InstanceId)
+
+	cmd := exec.Command("sshuttle", args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	defer watchProcess(cmd, e.options.Session, false)()
+	return cmd.Run()
+}
@@ -0,0 +1,129 @@
+package ec2ssh
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// runCommandTimeout bounds how long a Run Command invocation is waited for
+const runCommandTimeout = 2 * time.Minute
+
+// ssmTargetArgs returns the aws CLI arguments selecting an instance for
+// "aws ssm start-session"
+func (e *Ec2ssh) ssmTargetArgs(instanceId, profile string) []string {
+	args := []string{"ssm", "start-session", "--target", instanceId}
+	if profile != "" {
+		args = append(args, "--profile", profile)
+	}
+	if endpoint := e.options.serviceEndpoint("ssm"); endpoint != "" {
+		args = append(args, "--endpoint-url", endpoint)
+	}
+	return args
+}
+
+// ssmSessionArgs returns the aws CLI arguments starting an interactive SSM
+// session running the configured command for the instance's platform, or a
+// login shell as the planned user, in the planned environment, or the
+// configured session document
+func (e *Ec2ssh) ssmSessionArgs(plan *ConnectionPlan) []string {
+	instance := plan.Instance
+	if e.options.SSM.Document != "" {
+		return append(e.ssmTargetArgs(instance.InstanceId, cliProfile(instance)), "--document-name", e.options.SSM.Document)
+	}
+	shell := e.ssmShell(instance)
+	if plan.LoginAs != "" {
+		shell = loginAsCommand(plan.LoginAs)
+	} else if plan.Prompt != "" {
+		shell = promptShell(plan.Prompt)
+	}
+	return e.ssmCommandArgs(instance.InstanceId, cliProfile(instance), withEnv(plan.Env, e.withShellProfile(instance, shell)))
+}
+
+// withShellProfile wraps the session command to run the ssm.shell_profile
+// snippet first, e.g. to cd to the application directory, like the shell
+// profile of Session Manager preferences but per user and instance
+func (e *Ec2ssh) withShellProfile(instance *Instance, command string) string {
+	if e.shellProfile == nil || instance.OSFamily() == "windows" {
+		return command
+	}
+	snippet, err := TemplateForInstance(instance, e.shellProfile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ssm.shell_profile failed for %s: %v\n", instance.InstanceId, err)
+		return command
+	}
+	return "sh -c " + shellQuote(snippet+"\nexec "+command)
+}
+
+// ssmCommandArgs returns the aws CLI arguments starting an SSM session running
+// a command on the instance
+func (e *Ec2ssh) ssmCommandArgs(instanceId, profile, command string) []string {
+	args := e.ssmTargetArgs(instanceId, profile)
+	args = append(args, "--document-name", "AWS-StartInteractiveCommand")
+
+	// Given as JSON so quotes in the command survive the CLI's parsing
+	parameters, _ := json.Marshal(map[string][]string{"command": {command}})
+	args = append(args, "--parameters", string(parameters))
+	return args
+}
+
+// runShellScript runs commands on an instance through SSM Run Command and
+// returns their standard output. The output is truncated by SSM past 24000
+// characters, so it's only meant for short results.
+func runShellScript(ctx context.Context, instance *Instance, commands []string) (string, error) {
+	if instance.clients == nil {
+		return "", fmt.Errorf("no client available for %s", instance.InstanceId)
+	}
+	client := instance.clients.SSM
+
+	sent, err := client.SendCommand(ctx, &ssm.SendCommandInput{
+		DocumentName: aws.String("AWS-RunShellScript"),
+		InstanceIds:  []string{instance.InstanceId},
+		Parameters:   map[string][]string{"commands": commands},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to send command to %s: %w", instance.InstanceId, err)
+	}
+
+	input := &ssm.GetCommandInvocationInput{
+		CommandId:  sent.Command.CommandId,
+		InstanceId: aws.String(instance.InstanceId),
+	}
+	waitErr := ssm.NewCommandExecutedWaiter(client).Wait(ctx, input, runCommandTimeout)
+
+	invocation, err := client.GetCommandInvocation(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("failed to get command result from %s: %w", instance.InstanceId, err)
+	}
+	if waitErr != nil {
+		return "", fmt.Errorf("command failed on %s (%s): %s", instance.InstanceId,
+			invocation.Status, strings.TrimSpace(aws.ToString(invocation.StandardErrorContent)))
+	}
+	return aws.ToString(invocation.StandardOutputContent), nil
+}
+
+// shellJoin quotes arguments where needed and joins them into a command line
+// for a shell, as used by xpanes
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellQuote single-quotes an argument if it contains shell metacharacters
+func shellQuote(arg string) string {
+	if arg != "" && strings.IndexFunc(arg, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=@,+%", r))
+	}) < 0 {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
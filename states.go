@@ -0,0 +1,88 @@
+package ec2ssh
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+// startTimeout bounds how long a started instance is waited for
+const startTimeout = 5 * time.Minute
+
+// stateMarker returns the marker shown before instances that can't be
+// connected to right away. The finder can't color lines, so stopped
+// instances stand out with a textual marker instead.
+func stateMarker(instance *Instance) string {
+	switch instance.State.Name {
+	case "stopped", "stopping":
+		return "(" + instance.State.Name + ") "
+	default:
+		return ""
+	}
+}
+
+// confirm asks a yes/no question on the terminal, defaulting to no
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// offerStart offers to start a stopped instance, and waits for it to be
+// running so it has addresses to connect to. It returns false if the
+// instance was left stopped.
+func (e *Ec2ssh) offerStart(instance *Instance) (bool, error) {
+	if err := e.checkWritable("starting " + instance.InstanceId); err != nil {
+		return false, err
+	}
+	if !confirm(fmt.Sprintf("%s is stopped, start it?", instance.InstanceId)) {
+		return false, nil
+	}
+	if err := startInstance(instance); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// startInstance starts an instance, waits until it's running and refreshes
+// its addresses and state
+func startInstance(instance *Instance) error {
+	if instance.clients == nil {
+		return fmt.Errorf("no client available for %s", instance.InstanceId)
+	}
+	client := instance.clients.EC2
+
+	fmt.Printf("Starting %s...\n", instance.InstanceId)
+	_, err := client.StartInstances(context.TODO(), &ec2.StartInstancesInput{
+		InstanceIds: []string{instance.InstanceId},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start %s: %w", instance.InstanceId, err)
+	}
+
+	input := &ec2.DescribeInstancesInput{InstanceIds: []string{instance.InstanceId}}
+	output, err := ec2.NewInstanceRunningWaiter(client).WaitForOutput(context.TODO(), input, startTimeout)
+	if err != nil {
+		return fmt.Errorf("%s didn't reach the running state: %w", instance.InstanceId, err)
+	}
+
+	for _, r := range output.Reservations {
+		for _, i := range r.Instances {
+			started := newInstance(&i, instance.OwnerId, instance.Region)
+			started.Profile = instance.Profile
+			started.clients = instance.clients
+			*instance = started
+		}
+	}
+	fmt.Printf("%s is running (%s)\n", instance.InstanceId, orNone(instance.PrivateIpAddress))
+	return nil
+}
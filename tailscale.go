@@ -0,0 +1,115 @@
+package ec2ssh
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// MethodTailscale is the connection method of the built-in Tailscale
+// connector
+const MethodTailscale = "tailscale"
+
+// TailscaleConfig configures the instances reached over the tailnet
+type TailscaleConfig struct {
+	Enabled bool   // connect over the tailnet to instances found on it
+	Tag     string // tag holding the tailnet address of an instance
+}
+
+// tailscalePeer is a node of the tailnet, as printed by tailscale status
+type tailscalePeer struct {
+	HostName     string
+	DNSName      string
+	TailscaleIPs []string
+}
+
+// tailscale is the built-in Tailscale connector, configured by New
+var tailscale = &tailscaleConnector{}
+
+func init() {
+	RegisterConnector(MethodTailscale, tailscale)
+}
+
+// tailscaleConnector runs ssh to the tailnet address of instances
+type tailscaleConnector struct {
+	config TailscaleConfig
+
+	once  sync.Once
+	peers []tailscalePeer
+}
+
+// configure sets up the connector from the options
+func (t *tailscaleConnector) configure(config TailscaleConfig) {
+	t.config = config
+}
+
+// address returns the tailnet address of an instance: the value of the
+// configured tag, or the IP of the peer named after the instance, its Name
+// tag or its default ip-10-0-0-1 host name
+func (t *tailscaleConnector) address(instance *Instance) string {
+	if t.config.Tag != "" {
+		if address := instance.Tags[t.config.Tag]; address != "" {
+			return address
+		}
+	}
+	if !t.config.Enabled {
+		return ""
+	}
+
+	t.once.Do(func() {
+		peers, err := tailscaleStatus()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to list the tailnet peers: %v\n", err)
+		}
+		t.peers = peers
+	})
+
+	names := []string{instance.InstanceId, instance.Tags["Name"]}
+	if instance.PrivateIpAddress != "" {
+		names = append(names, "ip-"+strings.ReplaceAll(instance.PrivateIpAddress, ".", "-"))
+	}
+	for _, peer := range t.peers {
+		for _, name := range names {
+			if name != "" && strings.EqualFold(peer.HostName, name) && len(peer.TailscaleIPs) > 0 {
+				return peer.TailscaleIPs[0]
+			}
+		}
+	}
+	return ""
+}
+
+// tailscaleStatus lists the peers of the tailnet with tailscale status
+func tailscaleStatus() ([]tailscalePeer, error) {
+	if err := requireTool("tailscale"); err != nil {
+		return nil, err
+	}
+	output, err := exec.Command("tailscale", "status", "--json").Output()
+	if err != nil {
+		return nil, err
+	}
+	var status struct {
+		Peer map[string]tailscalePeer
+	}
+	if err := json.Unmarshal(output, &status); err != nil {
+		return nil, fmt.Errorf("failed to parse tailscale status: %w", err)
+	}
+
+	var peers []tailscalePeer
+	for _, peer := range status.Peer {
+		peers = append(peers, peer)
+	}
+	return peers, nil
+}
+
+// Command opens an ssh session over the tailnet
+func (t *tailscaleConnector) Command(plan *ConnectionPlan) []string {
+	return sshCommand(plan)
+}
+
+// RemoteCommand runs a command over the tailnet with ssh
+func (t *tailscaleConnector) RemoteCommand(plan *ConnectionPlan, command string) []string {
+	return append(append([]string{"ssh"}, plan.sshArgs()...), command)
+}
@@ -0,0 +1,112 @@
+package ec2ssh
+
+import (
+	"fmt"
+	"os"
+	"text/template"
+)
+
+// MethodTeleport is the connection method of the built-in Teleport connector
+const MethodTeleport = "teleport"
+
+// defaultTeleportNode names Teleport nodes after the Name tag of their
+// instance, or its id
+const defaultTeleportNode = `{{ or (index .Tags "Name") .InstanceId }}`
+
+// TeleportConfig configures the instances reached through Teleport with tsh
+type TeleportConfig struct {
+	Tag     string // instances carrying this tag use Teleport
+	Node    string // template naming the node of an instance
+	User    string // login, when neither ec2ssh:user nor the tsh profile set it
+	Proxy   string // --proxy of tsh, the current profile's otherwise
+	Cluster string // --cluster of tsh
+}
+
+// teleport is the built-in Teleport connector, configured by New
+var teleport = &teleportConnector{}
+
+func init() {
+	RegisterConnector(MethodTeleport, teleport)
+}
+
+// teleportConnector runs tsh ssh on the Teleport node of instances
+type teleportConnector struct {
+	config TeleportConfig
+	node   *template.Template
+}
+
+// configure sets up the connector from the options
+func (t *teleportConnector) configure(config TeleportConfig, funcs template.FuncMap) error {
+	if config.Node == "" {
+		config.Node = defaultTeleportNode
+	}
+	node, err := template.New("teleport.node").Funcs(funcs).Parse(config.Node)
+	if err != nil {
+		return fmt.Errorf("invalid teleport.node template: %w", err)
+	}
+	t.config = config
+	t.node = node
+	return nil
+}
+
+// usesTeleport reports whether an instance carries the Teleport tag
+func (t *teleportConnector) usesTeleport(instance *Instance) bool {
+	if t.config.Tag == "" {
+		return false
+	}
+	_, ok := instance.Tags[t.config.Tag]
+	return ok
+}
+
+// target returns the user@node tsh connects to
+func (t *teleportConnector) target(plan *ConnectionPlan) string {
+	node, err := TemplateForInstance(plan.Instance, t.node)
+	if err != nil || node == "" {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: teleport.node failed for %s: %v\n", plan.Instance.InstanceId, err)
+		}
+		node = plan.Instance.InstanceId
+	}
+	user := plan.User
+	if user == "" {
+		user = t.config.User
+	}
+	if user == "" {
+		return node
+	}
+	return user + "@" + node
+}
+
+// args returns the tsh ssh arguments up to the target
+func (t *teleportConnector) args(flags ...string) []string {
+	args := []string{"tsh"}
+	if t.config.Proxy != "" {
+		args = append(args, "--proxy="+t.config.Proxy)
+	}
+	args = append(args, "ssh")
+	if t.config.Cluster != "" {
+		args = append(args, "--cluster="+t.config.Cluster)
+	}
+	return append(args, flags...)
+}
+
+// Command opens a session on the node, as the login-as user and with the
+// session environment when set, which tsh doesn't forward
+func (t *teleportConnector) Command(plan *ConnectionPlan) []string {
+	command := plan.Command
+	if command == "" && plan.LoginAs != "" {
+		command = loginAsCommand(plan.LoginAs)
+	}
+	if command == "" && len(plan.Env) > 0 {
+		command = `"${SHELL:-/bin/sh}" -l`
+	}
+	if command == "" {
+		return append(t.args(), t.target(plan))
+	}
+	return append(t.args("-t"), t.target(plan), withEnv(plan.Env, command))
+}
+
+// RemoteCommand runs a command on the node without a terminal
+func (t *teleportConnector) RemoteCommand(plan *ConnectionPlan, command string) []string {
+	return append(t.args(), t.target(plan), command)
+}
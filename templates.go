@@ -0,0 +1,98 @@
+package ec2ssh
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// templateVersion is the version of the default templates. Bump it and
+// describe the change in templateChanges whenever the defaults change, so
+// users overriding them hear about it.
+const templateVersion = 7
+
+// templateChanges describes what each template version added to the defaults
+var templateChanges = map[int]string{
+	2: "instance type, availability zone, state and age",
+	3: "hibernation and stop/termination protection in the preview",
+	4: "who launched the instance in the preview",
+	5: "the deployed revision in the preview",
+	6: "scheduled events and maintenance windows in the preview",
+	7: "the CPU utilization next to the instance type in the preview",
+}
+
+const defaultTemplate = `{{ .InstanceId }}: {{ index .Tags "Name" }} ({{ .InstanceType }}, {{ .Placement.AvailabilityZone }}, {{ .State.Name }}, {{ age .LaunchTime }})`
+
+const defaultPreviewTemplate = `
+			Instance Id: {{.InstanceId}}
+			Name:        {{index .Tags "Name"}}
+			Type:        {{.InstanceType}}{{ with rightsizing . }} (CPU {{ printf "%.0f" .Average }}% avg, {{ printf "%.0f" .Maximum }}% max over 14d, {{ .Hint }}){{ end }}
+			AZ:          {{.Placement.AvailabilityZone}}
+			State:       {{.State.Name}}
+			Launched:    {{.LaunchTime.Format "2006-01-02 15:04"}} ({{age .LaunchTime}} ago)
+			Private IP:  {{.PrivateIpAddress}}
+			Public IP:   {{.PublicIpAddress}}
+
+			Tags:
+			{{ range $key, $value := .Tags }}
+				{{ indent 2 $key }}: {{ $value }}
+			{{- end }}
+			{{ with .Detail }}
+			Architecture:    {{ .Architecture }}
+			IAM Profile:     {{ with .IamInstanceProfile }}{{ .Arn }}{{ end }}
+			Security Groups: {{ range .SecurityGroups }}{{ .GroupName }} {{ end }}
+			Interfaces:      {{ range .NetworkInterfaces }}{{ .NetworkInterfaceId }} {{ end }}
+			Hibernation:     {{ with .HibernationOptions }}{{ .Configured }}{{ end }}
+			{{- end }}
+
+			Console: {{ .ConsoleURL }}
+			{{ with .Protection }}
+			Protection:  stop={{ .Stop }} termination={{ .Termination }}
+			{{- end }}
+			{{ with .LaunchedBy }}
+			Launched by: {{ .User }} {{ age .Time }} ago
+			{{- end }}
+			{{ with revision . }}
+			Revision:    {{ .Version }} ({{ .Source }}{{ with .Status }}, {{ . }}{{ end }})
+			{{- end }}
+			{{ with .Maintenance }}
+			{{- range .Events }}
+			Scheduled:   {{ .Code }} {{ .NotBefore.Local.Format "2006-01-02 15:04" }} {{ .Description }}
+			{{- end }}
+			{{- range .Windows }}
+			Maintenance: {{ .Name }} {{ if .Active }}in progress{{ else if not .Next.IsZero }}next {{ .Next.Local.Format "2006-01-02 15:04" }}{{ end }}
+			{{- end }}
+			{{- end }}
+		`
+
+// templateMigrationNotice tells users overriding the default templates in
+// their config what the defaults gained since the TemplateVersion they wrote
+// them against, until they bump it
+func templateMigrationNotice() {
+	if !viper.InConfig("template") && !viper.InConfig("previewtemplate") {
+		return
+	}
+	configured := viper.GetInt("TemplateVersion")
+	if configured >= templateVersion {
+		return
+	}
+
+	var versions []int
+	for version := range templateChanges {
+		if version > configured {
+			versions = append(versions, version)
+		}
+	}
+	sort.Ints(versions)
+
+	var changes []string
+	for _, version := range versions {
+		changes = append(changes, templateChanges[version])
+	}
+	fmt.Fprintf(os.Stderr, "Note: the default templates now show %s, your config overrides them. "+
+		"Set TemplateVersion = %d in the config to hide this notice.\n",
+		strings.Join(changes, "; "), templateVersion)
+}
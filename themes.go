@@ -0,0 +1,112 @@
+package ec2ssh
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// defaultTheme is the theme used when none is configured, the default
+// templates
+const defaultTheme = "detailed"
+
+// Theme is a built-in pair of list and preview templates. Template and
+// PreviewTemplate in the config still override either of them.
+type Theme struct {
+	List    string
+	Preview string
+}
+
+// themes are the built-in themes selected with --theme or theme in the config
+var themes = map[string]Theme{
+	"minimal": {
+		List: `{{ .InstanceId }}: {{ index .Tags "Name" }}`,
+		Preview: `
+			Instance Id: {{.InstanceId}}
+			Name:        {{index .Tags "Name"}}
+			Type:        {{.InstanceType}}
+			State:       {{.State.Name}}
+			Private IP:  {{.PrivateIpAddress}}
+			Public IP:   {{.PublicIpAddress}}
+		`,
+	},
+	"detailed": {
+		List:    defaultTemplate,
+		Preview: defaultPreviewTemplate,
+	},
+	"ops": {
+		List: `{{ index .Tags "Name" }} {{ .InstanceId }} ({{ .State.Name }}, up {{ age .LaunchTime }}{{ with index .Tags "Environment" }}, {{ . }}{{ end }})`,
+		Preview: `
+			Instance Id: {{.InstanceId}}
+			Name:        {{index .Tags "Name"}}
+			Environment: {{index .Tags "Environment"}}
+			Service:     {{index .Tags "Service"}}
+			Type:        {{.InstanceType}}{{ with rightsizing . }} (CPU {{ printf "%.0f" .Average }}% avg, {{ printf "%.0f" .Maximum }}% max over 14d, {{ .Hint }}){{ end }}
+			State:       {{.State.Name}}
+			Launched:    {{.LaunchTime.Format "2006-01-02 15:04"}} ({{age .LaunchTime}} ago)
+			AMI:         {{.ImageId}}
+
+			Console: {{ .ConsoleURL }}
+			{{ with .Protection }}
+			Protection:  stop={{ .Stop }} termination={{ .Termination }}
+			{{- end }}
+			{{ with .LaunchedBy }}
+			Launched by: {{ .User }} {{ age .Time }} ago
+			{{- end }}
+			{{ with revision . }}
+			Revision:    {{ .Version }} ({{ .Source }}{{ with .Status }}, {{ . }}{{ end }})
+			{{- end }}
+			{{ with .Maintenance }}
+			{{- range .Events }}
+			Scheduled:   {{ .Code }} {{ .NotBefore.Local.Format "2006-01-02 15:04" }} {{ .Description }}
+			{{- end }}
+			{{- range .Windows }}
+			Maintenance: {{ .Name }} {{ if .Active }}in progress{{ else if not .Next.IsZero }}next {{ .Next.Local.Format "2006-01-02 15:04" }}{{ end }}
+			{{- end }}
+			{{- end }}
+			{{ with .Compliance }}
+			SSM agent:   {{ if .Managed }}{{ .PingStatus }} {{ .AgentVersion }}{{ else }}not managed{{ end }}
+			Patches:     {{ if .Scanned }}{{ .Missing }} missing, {{ .Failed }} failed, {{ .PendingReboot }} pending reboot{{ else }}never scanned{{ end }}
+			{{- end }}
+		`,
+	},
+	"network": {
+		List: `{{ .InstanceId }}: {{ index .Tags "Name" }} ({{ .PrivateIpAddress }}{{ with .PublicIpAddress }}, {{ . }}{{ end }}, {{ .Placement.AvailabilityZone }})`,
+		Preview: `
+			Instance Id: {{.InstanceId}}
+			Name:        {{index .Tags "Name"}}
+			AZ:          {{.Placement.AvailabilityZone}}
+			VPC:         {{.VpcId}}
+			Subnet:      {{.SubnetId}}
+			Private IP:  {{.PrivateIpAddress}}
+			Public IP:   {{.PublicIpAddress}}
+			Public DNS:  {{.PublicDnsName}}
+
+			Interfaces:
+			{{ range .NetworkInterfaces }}
+				{{ indent 2 .NetworkInterfaceId }}: device {{ .DeviceIndex }}, {{ .SubnetId }}, {{ join ", " .PrivateIpAddresses }}{{ with .PublicIpAddress }} ({{ . }}){{ end }}
+			{{- end }}
+			{{ with .Detail }}
+			Security Groups: {{ range .SecurityGroups }}{{ .GroupId }} ({{ .GroupName }}) {{ end }}
+			Source/dest check: {{ with .SourceDestCheck }}{{ . }}{{ end }}
+			{{- end }}
+		`,
+	},
+}
+
+// lookupTheme returns a built-in theme by name
+func lookupTheme(name string) (Theme, error) {
+	if name == "" {
+		name = defaultTheme
+	}
+	theme, ok := themes[name]
+	if !ok {
+		names := make([]string, 0, len(themes))
+		for name := range themes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return Theme{}, fmt.Errorf("unknown theme %q, expected one of: %s", name, strings.Join(names, ", "))
+	}
+	return theme, nil
+}
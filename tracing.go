@@ -0,0 +1,367 @@
+package ec2ssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// Trace exporters of tracing.exporter
+const (
+	TraceExporterStdout = "stdout"
+	TraceExporterOTLP   = "otlp"
+)
+
+// defaultOTLPEndpoint is where OTLP traces are sent by default, the OTLP/HTTP
+// receiver of a local collector
+const defaultOTLPEndpoint = "http://localhost:4318/v1/traces"
+
+// traceExportTimeout bounds how long exporting traces may delay exiting
+const traceExportTimeout = 5 * time.Second
+
+// TracingConfig configures the OpenTelemetry tracing of a run
+type TracingConfig struct {
+	Exporter string // stdout or otlp, disabled when empty
+	Endpoint string // OTLP/HTTP traces endpoint
+}
+
+// span is a finished or running OpenTelemetry span
+type span struct {
+	traceId    string
+	spanId     string
+	parentId   string
+	name       string
+	start, end time.Time
+	attributes map[string]string
+	err        error
+}
+
+// tracer collects the spans of the run, exported on flush
+type tracer struct {
+	config  TracingConfig
+	client  httpDoer
+	headers map[string]string // sent with OTLP exports
+	mu      sync.Mutex
+	spans   []*span
+}
+
+// activeTracer is nil unless tracing is enabled
+var activeTracer *tracer
+
+type spanKey struct{}
+
+// enableTracing turns tracing on for the run, exporting with the shared
+// HTTP client
+func enableTracing(config TracingConfig, client httpDoer) {
+	if config.Exporter == "" {
+		return
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = otlpEndpointFromEnv()
+	}
+	activeTracer = &tracer{config: config, client: client, headers: otlpHeadersFromEnv()}
+}
+
+// otlpEndpointFromEnv returns the traces endpoint from the standard
+// OpenTelemetry environment variables, the local collector otherwise
+func otlpEndpointFromEnv() string {
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint != "" {
+		return endpoint + "/v1/traces"
+	}
+	return defaultOTLPEndpoint
+}
+
+// otlpHeadersFromEnv returns the headers sent to the collector, e.g. its
+// credentials, from the standard OpenTelemetry environment variables: comma
+// separated key=value pairs with URL encoded values
+func otlpHeadersFromEnv() map[string]string {
+	headers := make(map[string]string)
+	for _, name := range []string{"OTEL_EXPORTER_OTLP_HEADERS", "OTEL_EXPORTER_OTLP_TRACES_HEADERS"} {
+		for _, pair := range strings.Split(os.Getenv(name), ",") {
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok || strings.TrimSpace(key) == "" {
+				continue
+			}
+			if decoded, err := url.QueryUnescape(strings.TrimSpace(value)); err == nil {
+				value = decoded
+			}
+			headers[strings.TrimSpace(key)] = value
+		}
+	}
+	return headers
+}
+
+// startSpan starts a span, child of the span of the context if any. Key
+// value pairs are recorded as attributes. With tracing disabled, it returns
+// the context as is and a nil span, which End accepts.
+func startSpan(ctx context.Context, name string, attributes ...string) (context.Context, *span) {
+	if activeTracer == nil {
+		return ctx, nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	s := &span{
+		spanId:     randomHex(8),
+		name:       name,
+		start:      time.Now(),
+		attributes: make(map[string]string),
+	}
+	if parent, ok := ctx.Value(spanKey{}).(*span); ok {
+		s.traceId = parent.traceId
+		s.parentId = parent.spanId
+	} else {
+		s.traceId = randomHex(16)
+	}
+	for i := 0; i+1 < len(attributes); i += 2 {
+		s.attributes[attributes[i]] = attributes[i+1]
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// SetAttribute records an attribute on the span
+func (s *span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	activeTracer.mu.Lock()
+	defer activeTracer.mu.Unlock()
+	s.attributes[key] = value
+}
+
+// End ends the span, failed when err isn't nil
+func (s *span) End(err error) {
+	if s == nil {
+		return
+	}
+	activeTracer.mu.Lock()
+	defer activeTracer.mu.Unlock()
+	s.end = time.Now()
+	s.err = err
+	activeTracer.spans = append(activeTracer.spans, s)
+}
+
+// randomHex returns n random bytes, hex encoded
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// flushTraces exports the spans ended so far
+func flushTraces() {
+	if activeTracer == nil {
+		return
+	}
+	activeTracer.mu.Lock()
+	spans := activeTracer.spans
+	activeTracer.spans = nil
+	activeTracer.mu.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+
+	var err error
+	switch activeTracer.config.Exporter {
+	case TraceExporterStdout:
+		err = writeSpans(spans)
+	case TraceExporterOTLP:
+		err = activeTracer.exportOTLP(spans)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to export traces: %v\n", err)
+	}
+}
+
+// writeSpans prints the spans as JSON lines on stderr, keeping stdout for
+// the output of the run
+func writeSpans(spans []*span) error {
+	encoder := json.NewEncoder(os.Stderr)
+	for _, s := range spans {
+		line := map[string]interface{}{
+			"name":       s.name,
+			"traceId":    s.traceId,
+			"spanId":     s.spanId,
+			"parentId":   s.parentId,
+			"start":      s.start.Format(time.RFC3339Nano),
+			"duration":   s.end.Sub(s.start).String(),
+			"attributes": s.attributes,
+		}
+		if s.err != nil {
+			line["error"] = s.err.Error()
+		}
+		if err := encoder.Encode(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// otlpValue, otlpAttribute and otlpSpan follow the OTLP/JSON encoding of
+// traces, so no OpenTelemetry SDK is needed to export them
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+// otlpAttributes converts attributes to their OTLP form
+func otlpAttributes(attributes map[string]string) []otlpAttribute {
+	converted := make([]otlpAttribute, 0, len(attributes))
+	for _, key := range sortedKeys(attributes) {
+		converted = append(converted, otlpAttribute{Key: key, Value: otlpValue{StringValue: attributes[key]}})
+	}
+	return converted
+}
+
+// exportOTLP sends the spans to the OTLP/HTTP endpoint, JSON encoded
+func (t *tracer) exportOTLP(spans []*span) error {
+	converted := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		status := otlpStatus{Code: 1} // ok
+		if s.err != nil {
+			status = otlpStatus{Code: 2, Message: s.err.Error()}
+		}
+		converted = append(converted, otlpSpan{
+			TraceId:           s.traceId,
+			SpanId:            s.spanId,
+			ParentSpanId:      s.parentId,
+			Name:              s.name,
+			Kind:              1, // internal
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.attributes),
+			Status:            status,
+		})
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]string{
+					"service.name":    "ec2-ssh",
+					"service.version": VERSION,
+				}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "ec2-ssh"},
+				"spans": converted,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), traceExportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, value := range t.headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s answered %s", t.config.Endpoint, resp.Status)
+	}
+	return nil
+}
+
+// tracingMiddleware traces every AWS API call, pages of paginated calls
+// included, as a child of the span of the call's context
+func tracingMiddleware(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("ec2sshTracing", func(
+		ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler,
+	) (middleware.InitializeOutput, middleware.Metadata, error) {
+		service := awsmiddleware.GetServiceID(ctx)
+		operation := awsmiddleware.GetOperationName(ctx)
+		ctx, s := startSpan(ctx, service+"."+operation,
+			"rpc.system", "aws-api", "rpc.service", service, "rpc.method", operation,
+			"cloud.region", awsmiddleware.GetRegion(ctx))
+		out, metadata, err := next.HandleInitialize(ctx, in)
+		s.End(err)
+		return out, metadata, err
+	}), middleware.Before)
+}
+
+// validateTracing checks the tracing exporter is known
+func validateTracing(config TracingConfig) error {
+	switch config.Exporter {
+	case "", TraceExporterStdout, TraceExporterOTLP:
+		return nil
+	}
+	return fmt.Errorf("invalid tracing exporter %q, valid exporters are: %s, %s", config.Exporter, TraceExporterStdout, TraceExporterOTLP)
+}
+
+// traceContext returns the context holding the root span of the run
+func (e *Ec2ssh) traceContext() context.Context {
+	if e.traceCtx == nil {
+		return context.Background()
+	}
+	return e.traceCtx
+}
+
+// endTrace ends the root span of the run and exports the trace
+func (e *Ec2ssh) endTrace(err error) {
+	e.traceSpan.End(err)
+	e.traceSpan = nil
+	flushTraces()
+}
+
+// traceCommand runs a child process, connections included, in a span lasting
+// as long as it does
+func (e *Ec2ssh) traceCommand(cmd *exec.Cmd, name string, attributes ...string) error {
+	_, s := startSpan(e.traceContext(), name, append(attributes, "process.executable.name", filepath.Base(cmd.Path))...)
+	err := cmd.Run()
+	if cmd.ProcessState != nil {
+		s.SetAttribute("process.exit.code", strconv.Itoa(cmd.ProcessState.ExitCode()))
+	}
+	s.End(err)
+	return err
+}
@@ -0,0 +1,295 @@
+package ec2ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type TunnelConfig struct {
+	Name       string `mapstructure:"name"`
+	LocalPort  int    `mapstructure:"local_port"`
+	RemotePort int    `mapstructure:"remote_port"`
+	RemoteHost string `mapstructure:"remote_host"` // empty means the instance itself
+}
+
+const (
+	tunnelCheckInterval = 5 * time.Second
+	tunnelMaxBackoff    = 30 * time.Second
+)
+
+// tunnel is a port forward kept up by a supervisor goroutine
+type tunnel struct {
+	config    TunnelConfig
+	localPort int
+	plan      *ConnectionPlan
+
+	lock     sync.Mutex
+	cmd      *exec.Cmd
+	status   string
+	restarts int
+	lastErr  string
+}
+
+// runTunnels maintains the configured port forwards to the selected
+// instances until interrupted, re-establishing the ones that drop. With
+// several instances, local ports are shifted by the instance's position, and
+// past the ports already taken, so forwards don't collide.
+func (e *Ec2ssh) runTunnels(plans []*ConnectionPlan) error {
+	if len(e.options.Tunnels) == 0 {
+		return fmt.Errorf("no tunnels configured, add [[tunnels]] entries to the config file")
+	}
+
+	var tunnels []*tunnel
+	taken := make(map[int]bool)
+	for i, plan := range plans {
+		for _, config := range e.options.Tunnels {
+			if config.LocalPort == 0 || config.RemotePort == 0 {
+				return fmt.Errorf("tunnel %q needs both local_port and remote_port", config.Name)
+			}
+			port, err := freeLocalPort(config.LocalPort+i, taken)
+			if err != nil {
+				return fmt.Errorf("no free local port for tunnel %q: %w", config.Name, err)
+			}
+			taken[port] = true
+			tunnels = append(tunnels, &tunnel{
+				config:    config,
+				localPort: port,
+				plan:      plan,
+				status:    "starting",
+			})
+		}
+	}
+
+	stop := make(chan struct{})
+	for _, t := range tunnels {
+		go e.superviseTunnel(t, stop)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	ticker := time.NewTicker(tunnelCheckInterval)
+	defer ticker.Stop()
+	maxDuration := e.options.Session.MaxDuration
+	start := time.Now()
+	var durationWarned bool
+	for {
+		for _, t := range tunnels {
+			t.check()
+		}
+		printTunnels(tunnels)
+
+		// The tunnels have no output to measure idle time on, only the
+		// maximum duration applies
+		if maxDuration > 0 {
+			left := maxDuration - time.Since(start)
+			if left <= 0 {
+				sessionNotice("closing the tunnels: maximum session duration of %s reached", maxDuration)
+				close(stop)
+				for _, t := range tunnels {
+					t.kill()
+				}
+				return nil
+			}
+			if !durationWarned && left <= warningLead(maxDuration) {
+				durationWarned = true
+				sessionNotice("tunnels will be closed in %s, the maximum session duration", left.Round(time.Second))
+			}
+		}
+
+		select {
+		case <-signals:
+			close(stop)
+			for _, t := range tunnels {
+				t.kill()
+			}
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// freeLocalPort returns the first port from port on that no other tunnel
+// uses and nothing listens on
+func freeLocalPort(port int, taken map[int]bool) (int, error) {
+	for ; port <= 65535; port++ {
+		if taken[port] {
+			continue
+		}
+		listener, err := net.Listen("tcp", net.JoinHostPort("localhost", strconv.Itoa(port)))
+		if err != nil {
+			continue
+		}
+		listener.Close()
+		return port, nil
+	}
+	return 0, fmt.Errorf("every port is in use")
+}
+
+// superviseTunnel runs the forwarding command, restarting it with an
+// increasing backoff whenever it exits, unless it was killed on purpose
+func (e *Ec2ssh) superviseTunnel(t *tunnel, stop chan struct{}) {
+	backoff := time.Second
+	for {
+		args := e.tunnelArgs(t)
+		cmd := exec.Command(args[0], args[1:]...)
+		stderr := new(bytes.Buffer)
+		cmd.Stderr = stderr
+
+		t.lock.Lock()
+		t.cmd = cmd
+		t.lock.Unlock()
+
+		started := time.Now()
+		err := cmd.Start()
+		if err == nil {
+			err = cmd.Wait()
+		}
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		t.lock.Lock()
+		t.lastErr = lastLine(stderr.String())
+		if t.lastErr == "" && err != nil {
+			t.lastErr = err.Error()
+		}
+		if killedOnPurpose(err, t.lastErr) {
+			// The user closed it, e.g. with kill, it's left closed
+			t.status = "stopped"
+			t.lock.Unlock()
+			return
+		}
+		t.status = "down"
+		t.restarts++
+		t.lock.Unlock()
+
+		// A tunnel that stayed up for a while starts over with a short delay
+		if time.Since(started) > tunnelMaxBackoff {
+			backoff = time.Second
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > tunnelMaxBackoff {
+			backoff = tunnelMaxBackoff
+		}
+	}
+}
+
+// killedOnPurpose tells whether a forwarding command was ended by an
+// interrupt, termination or kill signal rather than by a dropped connection.
+// ssh catches the first two and exits with "Killed by signal".
+func killedOnPurpose(err error, lastErr string) bool {
+	if strings.HasPrefix(lastErr, "Killed by signal") {
+		return true
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return false
+	}
+	switch status.Signal() {
+	case syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL:
+		return true
+	}
+	return false
+}
+
+// tunnelArgs returns the command line forwarding the tunnel's local port
+func (e *Ec2ssh) tunnelArgs(t *tunnel) []string {
+	instance := t.plan.Instance
+	if t.plan.Method == MethodSSM {
+		args := append([]string{"aws"}, e.ssmTargetArgs(instance.InstanceId, cliProfile(instance))...)
+		parameters := fmt.Sprintf("portNumber=%d,localPortNumber=%d", t.config.RemotePort, t.localPort)
+		if t.config.RemoteHost != "" {
+			return append(args, "--document-name", "AWS-StartPortForwardingSessionToRemoteHost",
+				"--parameters", parameters+",host="+t.config.RemoteHost)
+		}
+		return append(args, "--document-name", "AWS-StartPortForwardingSession", "--parameters", parameters)
+	}
+
+	remoteHost := t.config.RemoteHost
+	if remoteHost == "" {
+		remoteHost = "localhost"
+	}
+	args := []string{"ssh", "-N",
+		"-o", "ExitOnForwardFailure=yes",
+		"-o", "ServerAliveInterval=15",
+		"-L", fmt.Sprintf("%d:%s:%d", t.localPort, remoteHost, t.config.RemotePort),
+	}
+	return append(args, t.plan.sshArgs()...)
+}
+
+// check updates the tunnel status by connecting to its local port
+func (t *tunnel) check() {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort("localhost", strconv.Itoa(t.localPort)), time.Second)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.status == "stopped" {
+		if err == nil {
+			conn.Close()
+		}
+		return
+	}
+	if err != nil {
+		if t.status == "up" {
+			t.status = "down"
+		}
+		return
+	}
+	conn.Close()
+	t.status = "up"
+}
+
+// kill stops the tunnel's forwarding command
+func (t *tunnel) kill() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.cmd != nil && t.cmd.Process != nil {
+		t.cmd.Process.Kill()
+	}
+}
+
+// printTunnels redraws the status table
+func printTunnels(tunnels []*tunnel) {
+	fmt.Print("\033[H\033[2J")
+	fmt.Printf("%-16s %-7s %-40s %-9s %-8s %s\n", "NAME", "LOCAL", "REMOTE", "STATUS", "RESTARTS", "LAST ERROR")
+	for _, t := range tunnels {
+		remoteHost := t.config.RemoteHost
+		if remoteHost == "" {
+			remoteHost = t.plan.Instance.InstanceId
+		}
+
+		t.lock.Lock()
+		fmt.Printf("%-16s %-7d %-40s %-9s %-8d %s\n", t.config.Name, t.localPort,
+			fmt.Sprintf("%s:%d", remoteHost, t.config.RemotePort), t.status, t.restarts, t.lastErr)
+		t.lock.Unlock()
+	}
+	fmt.Println("\nPress Ctrl-C to close the tunnels")
+}
+
+// lastLine returns the last non-empty line of a command output
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
@@ -0,0 +1,114 @@
+package ec2ssh
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	latestReleaseURL = "https://api.github.com/repos/laurentgoudet/ec2-ssh/releases/latest"
+	changelogURL     = "https://github.com/laurentgoudet/ec2-ssh/blob/master/CHANGELOG.md"
+
+	versionCheckInterval = 24 * time.Hour
+	versionCheckTimeout  = 2 * time.Second
+)
+
+type versionCheckState struct {
+	CheckedAt time.Time `json:"checked_at"`
+	Latest    string    `json:"latest"`
+}
+
+// checkForUpdate prints a one-line hint if a newer release is known, and
+// refreshes the known latest release in the background at most once a day.
+// It never blocks startup and stays silent when offline: the hint is based
+// on the result of a previous run.
+func checkForUpdate(enabled bool, client httpDoer) {
+	if !enabled || os.Getenv("EC2SSH_NO_UPDATE_CHECK") != "" {
+		return
+	}
+
+	statePath := filepath.Join(cacheDir(), "version-check.json")
+	var state versionCheckState
+	if data, err := os.ReadFile(statePath); err == nil {
+		json.Unmarshal(data, &state)
+	}
+
+	if state.Latest != "" && newerVersion(state.Latest, VERSION) {
+		fmt.Fprintf(os.Stderr, "ec2-ssh %s is available (you have %s), see %s\n",
+			strings.TrimPrefix(state.Latest, "v"), VERSION, changelogURL)
+	}
+
+	if time.Since(state.CheckedAt) < versionCheckInterval {
+		return
+	}
+
+	go func() {
+		latest, err := fetchLatestVersion(client)
+		if err != nil {
+			return
+		}
+		data, err := json.Marshal(versionCheckState{CheckedAt: time.Now(), Latest: latest})
+		if err != nil {
+			return
+		}
+		os.MkdirAll(filepath.Dir(statePath), 0o755)
+		os.WriteFile(statePath, data, 0o644)
+	}()
+}
+
+// fetchLatestVersion returns the tag of the latest GitHub release
+func fetchLatestVersion(client httpDoer) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), versionCheckTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, latestReleaseURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return "", err
+	}
+	return release.TagName, nil
+}
+
+// newerVersion reports whether version a is newer than version b, comparing
+// dot-separated numeric components and ignoring a leading "v"
+func newerVersion(a, b string) bool {
+	pa := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	pb := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var na, nb int
+		if i < len(pa) {
+			na, _ = strconv.Atoi(pa[i])
+		}
+		if i < len(pb) {
+			nb, _ = strconv.Atoi(pb[i])
+		}
+		if na != nb {
+			return na > nb
+		}
+	}
+	return false
+}
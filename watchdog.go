@@ -0,0 +1,185 @@
+package ec2ssh
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// sessionWarningLead is how long before a session is cut off users are
+// warned, at most
+const sessionWarningLead = 5 * time.Minute
+
+// sessionKillDelay is how long a session is given to exit once asked to,
+// before being killed
+const sessionKillDelay = 5 * time.Second
+
+// SessionConfig limits how long sessions last, for organizations with
+// session time policies
+type SessionConfig struct {
+	MaxDuration time.Duration // sessions are closed after this long
+	IdleTimeout time.Duration // SSH sessions are closed after this long without output
+}
+
+// activityWriter records when output was last written through it
+type activityWriter struct {
+	w    io.Writer
+	last atomic.Int64 // unix nanoseconds
+}
+
+func (a *activityWriter) Write(p []byte) (int, error) {
+	a.last.Store(time.Now().UnixNano())
+	return a.w.Write(p)
+}
+
+// warningLead returns how long before a limit to warn about it
+func warningLead(limit time.Duration) time.Duration {
+	if limit/2 < sessionWarningLead {
+		return limit / 2
+	}
+	return sessionWarningLead
+}
+
+// watchSession closes the session run by cmd once it exceeds the configured
+// duration or idle time, warning beforehand. Idle time is measured on the
+// session output, which is relayed for that, so it's only watched for SSH
+// sessions: the Session Manager plugin needs the terminal as its output, and
+// Session Manager has its own idle timeout preference. It must be called
+// before the command is started, and the returned function once it ended.
+func (e *Ec2ssh) watchSession(cmd *exec.Cmd, plan *ConnectionPlan) (stop func()) {
+	return watchProcess(cmd, e.options.Session, plan.Method == MethodSSH)
+}
+
+// watchedCommand returns the command of a session run outside this process,
+// e.g. in an xpanes pane, wrapped in "ec2-ssh watch-session" so the session
+// limits still apply to it
+func (e *Ec2ssh) watchedCommand(plan *ConnectionPlan, command []string) []string {
+	config := e.options.Session
+	idleTimeout := config.IdleTimeout
+	if plan.Method != MethodSSH {
+		idleTimeout = 0
+	}
+	if config.MaxDuration <= 0 && idleTimeout <= 0 {
+		return command
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		executable = "ec2-ssh"
+	}
+	return append([]string{executable, "watch-session", config.MaxDuration.String(), idleTimeout.String()}, command...)
+}
+
+// runWatchSessionCommand handles "ec2-ssh watch-session <max duration> <idle
+// timeout> <command...>", running a session command under the session
+// limits, and returns its exit code
+func runWatchSessionCommand(args []string) (int, error) {
+	if len(args) < 3 {
+		return 1, fmt.Errorf("Usage: ec2-ssh watch-session <max-duration> <idle-timeout> <command> [args...]")
+	}
+	maxDuration, err := time.ParseDuration(args[0])
+	if err != nil {
+		return 1, fmt.Errorf("invalid max duration %q: %w", args[0], err)
+	}
+	idleTimeout, err := time.ParseDuration(args[1])
+	if err != nil {
+		return 1, fmt.Errorf("invalid idle timeout %q: %w", args[1], err)
+	}
+
+	cmd := exec.Command(args[2], args[3:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	stop := watchProcess(cmd, SessionConfig{MaxDuration: maxDuration, IdleTimeout: idleTimeout}, idleTimeout > 0)
+	err = cmd.Run()
+	stop()
+	if cmd.ProcessState != nil {
+		return cmd.ProcessState.ExitCode(), nil
+	}
+	return 1, err
+}
+
+// watchProcess closes a long-lived child process, a session, tunnel or
+// remote command, once it exceeds the configured duration or, if watchIdle
+// is set, idle time measured on its output, which is relayed for that
+func watchProcess(cmd *exec.Cmd, config SessionConfig, watchIdle bool) (stop func()) {
+	idleTimeout := config.IdleTimeout
+	if !watchIdle {
+		idleTimeout = 0
+	}
+	if config.MaxDuration <= 0 && idleTimeout <= 0 {
+		return func() {}
+	}
+
+	start := time.Now()
+	var relayed io.Writer = io.Discard
+	if cmd.Stdout != nil {
+		relayed = cmd.Stdout
+	}
+	output := &activityWriter{w: relayed}
+	output.last.Store(start.UnixNano())
+	if idleTimeout > 0 {
+		cmd.Stdout = output
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		var durationWarned, idleWarned bool
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if config.MaxDuration > 0 {
+					left := config.MaxDuration - now.Sub(start)
+					if left <= 0 {
+						closeSession(cmd, fmt.Sprintf("maximum session duration of %s reached", config.MaxDuration))
+						return
+					}
+					if !durationWarned && left <= warningLead(config.MaxDuration) {
+						durationWarned = true
+						sessionNotice("session will be closed in %s, the maximum session duration", left.Round(time.Second))
+					}
+				}
+
+				if idleTimeout > 0 {
+					idle := now.Sub(time.Unix(0, output.last.Load()))
+					if idle >= idleTimeout {
+						closeSession(cmd, fmt.Sprintf("session idle for %s", idleTimeout))
+						return
+					}
+					if idle < idleTimeout-warningLead(idleTimeout) {
+						idleWarned = false
+					} else if !idleWarned {
+						idleWarned = true
+						sessionNotice("idle session will be closed in %s", (idleTimeout - idle).Round(time.Second))
+					}
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// sessionNotice prints a notice in the middle of a session, whose terminal is
+// in raw mode
+func sessionNotice(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "\r\n[ec2-ssh] "+format+"\r\n", args...)
+}
+
+// closeSession asks the session process to exit, and kills it if it doesn't
+func closeSession(cmd *exec.Cmd, reason string) {
+	if cmd.Process == nil {
+		return
+	}
+	sessionNotice("closing the session: %s", reason)
+	cmd.Process.Signal(syscall.SIGTERM)
+	time.AfterFunc(sessionKillDelay, func() {
+		cmd.Process.Kill()
+	})
+}
@@ -0,0 +1,165 @@
+package ec2ssh
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+)
+
+// webhookTimeout is how long a webhook gets to accept an event, so a slow
+// chat service doesn't hold sessions up
+const webhookTimeout = 3 * time.Second
+
+// Session events posted to the webhook
+const (
+	SessionStart = "session_start"
+	SessionEnd   = "session_end"
+)
+
+// Webhook payload formats
+const (
+	WebhookJSON = "json"
+	WebhookText = "text"
+)
+
+// WebhookConfig configures posting who connected to what, and when, to a
+// chat or incident tool
+type WebhookConfig struct {
+	URL    string // receives a POST on session start and end
+	Format string // json posts the event, text a {"text": ...} message for Slack or Teams incoming webhooks
+}
+
+// SessionEvent is the JSON payload posted to the webhook
+type SessionEvent struct {
+	Event      string    `json:"event"`
+	User       string    `json:"user"`
+	Host       string    `json:"host"`
+	InstanceId string    `json:"instance_id"`
+	Name       string    `json:"name,omitempty"`
+	Profile    string    `json:"profile,omitempty"`
+	Account    string    `json:"account,omitempty"`
+	Region     string    `json:"region"`
+	Method     string    `json:"method"`
+	Time       time.Time `json:"time"`
+	Duration   float64   `json:"duration_seconds,omitempty"` // on session end
+	Error      string    `json:"error,omitempty"`            // on session end, when it failed
+}
+
+// validateWebhook checks the webhook payload format
+func validateWebhook(config WebhookConfig) error {
+	switch config.Format {
+	case WebhookJSON, WebhookText:
+		return nil
+	}
+	return fmt.Errorf("invalid webhook.format %q, valid formats are: %s, %s", config.Format, WebhookJSON, WebhookText)
+}
+
+// notifySessions posts a session event for each plan to the webhook, if one
+// is configured, all at once. started and err are those of the session on
+// its end. Failures to post are only warned about.
+func (e *Ec2ssh) notifySessions(event string, plans []*ConnectionPlan, started time.Time, err error) {
+	if e.options.Webhook.URL == "" {
+		return
+	}
+
+	host, _ := os.Hostname()
+	wg := &sync.WaitGroup{}
+	for _, plan := range plans {
+		payload := SessionEvent{
+			Event:      event,
+			User:       currentUser(),
+			Host:       host,
+			InstanceId: plan.Instance.InstanceId,
+			Name:       plan.Instance.Tags["Name"],
+			Profile:    plan.Instance.Profile,
+			Account:    plan.Instance.OwnerId,
+			Region:     plan.Instance.Region,
+			Method:     plan.Method,
+			Time:       time.Now(),
+		}
+		if event == SessionEnd {
+			payload.Duration = time.Since(started).Round(time.Second).Seconds()
+			if err != nil {
+				payload.Error = err.Error()
+			}
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := e.postWebhook(payload); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to post %s of %s to the webhook: %v\n", event, payload.InstanceId, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// currentUser returns the name of the local user connecting
+func currentUser() string {
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return "unknown"
+}
+
+// postWebhook posts an event to the webhook in the configured format,
+// through the configured proxy
+func (e *Ec2ssh) postWebhook(event SessionEvent) error {
+	var body interface{} = event
+	if e.options.Webhook.Format == WebhookText {
+		body = map[string]string{"text": sessionText(event)}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.options.Webhook.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// sessionText describes a session event in one line for chat
+func sessionText(event SessionEvent) string {
+	target := event.InstanceId
+	if event.Name != "" {
+		target = event.Name + " (" + event.InstanceId + ")"
+	}
+	where := event.Region
+	if event.Profile != "" {
+		where = event.Profile + " " + where
+	}
+
+	if event.Event == SessionStart {
+		return fmt.Sprintf("%s@%s connected to %s in %s via %s", event.User, event.Host, target, where, event.Method)
+	}
+	text := fmt.Sprintf("%s@%s disconnected from %s in %s after %s", event.User, event.Host, target, where, time.Duration(event.Duration)*time.Second)
+	if event.Error != "" {
+		text += " (" + event.Error + ")"
+	}
+	return text
+}